@@ -2,17 +2,24 @@ package api
 
 import (
 	"blocklite/blockchain"
+	"blocklite/miner"
+	"blocklite/p2p"
 	"blocklite/wallet"
 	"encoding/hex"
+	"errors"
 	"net/http"
 	"strconv"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 )
 
+// defaultMinerAddress receives the rewards of blocks mined without a miner address
+const defaultMinerAddress = "system-miner"
+
 // GetBlocks Retrieve all blocks from the blockchain
 func GetBlocks(c *gin.Context, bc *blockchain.Blockchain) {
-	c.JSON(http.StatusOK, bc.Chain)
+	c.JSON(http.StatusOK, bc.Blocks())
 }
 
 // CreateBlock Add a new block to the blockchain
@@ -24,9 +31,11 @@ func CreateBlock(c *gin.Context, bc *blockchain.Blockchain) {
 		return
 	}
 
-	latestBlock := bc.GetLatestBlock()
-	newProof := blockchain.ProofOfWork(latestBlock.Proof)
-	newBlock = bc.CreateBlock(newProof, latestBlock.CalculateHash())
+	newBlock, err := bc.CreateBlockContext(c.Request.Context(), defaultMinerAddress)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
 
 	c.JSON(http.StatusCreated, gin.H{"message": "Block mined successfully", "block": newBlock})
 }
@@ -41,24 +50,105 @@ func MineBlock(c *gin.Context, bc *blockchain.Blockchain) {
 
 	miner := input.MinerAddress
 	if miner == "" {
-		miner = "system-miner" // default if not provided
+		miner = defaultMinerAddress // default if not provided
 	}
 
-	// Add mining reward transaction
-	bc.AddTransaction("0", miner, blockchain.MiningReward, "")
+	// The block template pays the mining reward and the fees to the miner. Mining stops when
+	// the client goes away, and starts over when a new tip arrives meanwhile.
+	newBlock, err := bc.CreateBlockContext(c.Request.Context(), miner)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
 
-	latestBlock := bc.GetLatestBlock()
-	newProof := blockchain.ProofOfWork(latestBlock.Proof)
-	newBlock := bc.CreateBlock(newProof, latestBlock.CalculateHash())
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Congratulations! You just mined a block",
+		"block":   newBlock,
+		"reward":  newBlock.Transactions[0].Amount,
+		"fees":    newBlock.TotalFees(),
+	})
+}
+
+// StartMiner Start mining in the background
+func StartMiner(c *gin.Context, m *miner.Miner) {
+	var input struct {
+		MinerAddress string `json:"miner_address"`
+	}
+	_ = c.ShouldBindJSON(&input)
+
+	address := input.MinerAddress
+	if address == "" {
+		address = defaultMinerAddress
+	}
+
+	if err := m.Start(address); err != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error(), "miner": m.Stats()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Miner started", "miner": m.Stats()})
+}
+
+// StopMiner Stop the background miner, abandoning its current attempt
+func StopMiner(c *gin.Context, m *miner.Miner) {
+	message := "Miner stopped"
+	if !m.Stop() {
+		message = "Miner was not running"
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": message, "miner": m.Stats()})
+}
+
+// GetMinerStatus Hashrate and blocks found of the background miner
+func GetMinerStatus(c *gin.Context, m *miner.Miner) {
+	c.JSON(http.StatusOK, m.Stats())
+}
+
+// GetMiningTemplate Hand out a block template to an external miner
+func GetMiningTemplate(c *gin.Context, m *miner.Miner) {
+	address := c.Query("miner_address")
+	if address == "" {
+		address = defaultMinerAddress
+	}
+
+	c.JSON(http.StatusOK, m.Template(address))
+}
+
+// SubmitWork Connect the block of a template with the nonce an external miner found
+func SubmitWork(c *gin.Context, m *miner.Miner) {
+	var input struct {
+		JobID string `json:"job_id" binding:"required"`
+		Nonce *int   `json:"nonce" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+		return
+	}
+
+	block, err := m.Submit(input.JobID, *input.Nonce)
+	switch {
+	case errors.Is(err, miner.ErrUnknownJob):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	case err != nil:
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "Congratulations! You just mined a block", "block": newBlock})
+	c.JSON(http.StatusCreated, gin.H{"message": "Block accepted", "block": block, "hash": block.CalculateHash()})
 }
 
-// GetProofOfWork Calculate the proof of work for the latest block
+// GetProofOfWork Calculate the proof of work for the next block template
 func GetProofOfWork(c *gin.Context, bc *blockchain.Blockchain) {
-	latestBlock := bc.GetLatestBlock()
-	proof := blockchain.ProofOfWork(latestBlock.Proof)
-	c.JSON(http.StatusOK, gin.H{"proof": proof})
+	template := bc.NewBlockTemplate(defaultMinerAddress)
+	proof, err := blockchain.SearchProof(c.Request.Context(), template.Header(), nil)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	template.Proof = proof
+	c.JSON(http.StatusOK, gin.H{"proof": template.Proof, "header": template.Header()})
 }
 
 // GetPreviousHash Return the hash of the latest block
@@ -99,14 +189,65 @@ func GetLength(c *gin.Context, bc *blockchain.Blockchain) {
 
 // GetFullChain Return the entire blockchain
 func GetFullChain(c *gin.Context, bc *blockchain.Blockchain) {
+	chain := bc.Blocks()
 	response := struct {
 		Length int                `json:"length"`
 		Chain  []blockchain.Block `json:"chain"`
 	}{
-		Length: bc.GetLength(),
-		Chain:  bc.Chain,
+		Length: len(chain),
+		Chain:  chain,
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// GetHeaders Return the headers following the first known hash of the block locator
+func GetHeaders(c *gin.Context, bc *blockchain.Blockchain) {
+	max := blockchain.MaxHeadersPerRequest
+	if value := c.Query("max"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid max"})
+			return
+		}
+		max = min(n, max)
 	}
 
+	locator := []string{}
+	if value := c.Query("locator"); value != "" {
+		locator = strings.Split(value, ",")
+	}
+	c.JSON(http.StatusOK, gin.H{"headers": bc.HeadersAfter(locator, max)})
+}
+
+// GetBlocksByHash Return the blocks with the given comma-separated hashes
+func GetBlocksByHash(c *gin.Context, bc *blockchain.Blockchain) {
+	hashes := strings.Split(c.Query("hashes"), ",")
+	if len(hashes) > blockchain.MaxBlocksPerRequest {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Too many blocks requested"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"blocks": bc.GetBlocksByHash(hashes)})
+}
+
+// GetSyncStatus Report the height of our chain, the best height known from peers and the sync progress
+func GetSyncStatus(c *gin.Context, syncer *blockchain.Syncer) {
+	c.JSON(http.StatusOK, syncer.Status())
+}
+
+// VerifyChain Replay the whole chain and report the first block, transaction and rule at fault
+func VerifyChain(c *gin.Context, bc *blockchain.Blockchain) {
+	err := bc.VerifyChain()
+	if err == nil {
+		c.JSON(http.StatusOK, gin.H{"valid": true, "length": bc.GetLength()})
+		return
+	}
+
+	response := gin.H{"valid": false, "error": err.Error()}
+	var validationErr *blockchain.ValidationError
+	if errors.As(err, &validationErr) {
+		response["details"] = validationErr
+	}
 	c.JSON(http.StatusOK, response)
 }
 
@@ -119,35 +260,124 @@ func NewTransaction(c *gin.Context, bc *blockchain.Blockchain) {
 		return
 	}
 
-	// Verify signature if sender is not "0" (system)
-	if tx.Sender != "0" {
-		// Check balance
-		if bc.GetBalance(tx.Sender) < tx.Amount {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Insufficient balance"})
-			return
-		}
-
-		data := tx.Sender + tx.Receiver + strconv.FormatFloat(tx.Amount, 'f', -1, 64)
-		if !wallet.Verify(tx.Sender, data, tx.Signature) {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid signature"})
-			return
-		}
+	// Signature, balance and nonce (or inputs on a UTXO chain) are checked before the transaction is queued,
+	// and mining rewards cannot be spent before they have enough confirmations
+	index, err := bc.SubmitTransaction(tx)
+	if errors.Is(err, blockchain.ErrInvalidSignature) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+	if errors.Is(err, blockchain.ErrImmatureCoinbase) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":                  err.Error(),
+			"immature":               bc.GetImmatureBalance(tx.Sender),
+			"required_confirmations": bc.GetCoinbaseMaturity(),
+		})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
 	}
 
-	index := bc.AddTransaction(tx.Sender, tx.Receiver, tx.Amount, tx.Signature)
-	c.JSON(http.StatusCreated, gin.H{"message": "Transaction will be added to Block " + strconv.Itoa(index)})
+	c.JSON(http.StatusCreated, gin.H{
+		"message": "Transaction will be added to Block " + strconv.Itoa(index),
+		"tx_id":   tx.Hash(),
+	})
 }
 
-// GetBalance returns the balance of an address
+// GetBalance returns the balance of an address, split into what can be spent and the
+// mining rewards that are not mature yet
 func GetBalance(c *gin.Context, bc *blockchain.Blockchain) {
 	address := c.Param("address")
 	balance := bc.GetBalance(address)
-	c.JSON(http.StatusOK, gin.H{"address": address, "balance": balance})
+	immature := bc.GetImmatureBalance(address)
+	c.JSON(http.StatusOK, gin.H{
+		"address":    address,
+		"balance":    balance,
+		"spendable":  balance - immature,
+		"immature":   immature,
+		"next_nonce": bc.NextNonce(address),
+	})
+}
+
+// GetSupply Report the circulating supply, the current block reward and the next halving
+func GetSupply(c *gin.Context, bc *blockchain.Blockchain) {
+	c.JSON(http.StatusOK, bc.GetSupply())
+}
+
+// GetUnspentOutputs returns the unspent outputs of an address on a UTXO chain
+func GetUnspentOutputs(c *gin.Context, bc *blockchain.Blockchain) {
+	if bc.Ledger != blockchain.UTXOModel {
+		c.JSON(http.StatusNotFound, gin.H{"error": "This chain uses the account model"})
+		return
+	}
+	address := c.Param("address")
+	c.JSON(http.StatusOK, gin.H{"address": address, "utxos": bc.GetUnspentOutputs(address)})
 }
 
 // GetPendingTransactions Return the list of pending transactions
 func GetPendingTransactions(c *gin.Context, bc *blockchain.Blockchain) {
-	c.JSON(http.StatusOK, bc.CurrentTransactions)
+	c.JSON(http.StatusOK, bc.PendingTransactions())
+}
+
+// GetTransaction Report whether a transaction is pending, confirmed (with its confirmations) or unknown
+func GetTransaction(c *gin.Context, bc *blockchain.Blockchain) {
+	status := bc.GetTransactionStatus(c.Param("id"))
+	if status.Status == blockchain.TxStatusUnknown {
+		c.JSON(http.StatusNotFound, status)
+		return
+	}
+	c.JSON(http.StatusOK, status)
+}
+
+// GetTransactionProof Return the Merkle branch proving a transaction is included in a block,
+// together with the block header, so light clients can check it without the full chain
+func GetTransactionProof(c *gin.Context, bc *blockchain.Blockchain) {
+	id := c.Param("id")
+
+	block, position, ok := bc.FindTransaction(id)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Transaction Not Found"})
+		return
+	}
+
+	proof, _ := blockchain.MerkleProof(block.Transactions, position)
+	c.JSON(http.StatusOK, gin.H{
+		"tx_id":    id,
+		"position": position,
+		"proof":    proof,
+		"header":   block.Header(),
+	})
+}
+
+// ReceiveBlock Accept a block announced by another node and relay it. Misbehavior counts
+// against the address of the connection, not the node the announcement claims to be from.
+func ReceiveBlock(c *gin.Context, gossip *blockchain.Gossip) {
+	var msg blockchain.GossipMessage
+	if err := c.ShouldBindJSON(&msg); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := gossip.HandleBlock(msg, c.RemoteIP()); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Block received"})
+}
+
+// ReceiveTransaction Accept a transaction announced by another node and relay it
+func ReceiveTransaction(c *gin.Context, gossip *blockchain.Gossip) {
+	var msg blockchain.GossipMessage
+	if err := c.ShouldBindJSON(&msg); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := gossip.HandleTransaction(msg, c.RemoteIP()); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Transaction received"})
 }
 
 // CreateWallet Generate a new wallet
@@ -177,23 +407,89 @@ func RegisterNodes(c *gin.Context, bc *blockchain.Blockchain) {
 
 	c.JSON(http.StatusCreated, gin.H{
 		"message": "New nodes have been added",
-		"nodes":   bc.Nodes,
+		"nodes":   bc.RegisteredNodes(),
+	})
+}
+
+// GetBans List the banned peers and the misbehavior scores of all peers
+func GetBans(c *gin.Context, bc *blockchain.Blockchain) {
+	scores := bc.PeerScores()
+	c.JSON(http.StatusOK, gin.H{
+		"bans":          scores.Bans(),
+		"scores":        scores.Scores(),
+		"ban_threshold": blockchain.BanThreshold,
+	})
+}
+
+// ClearBans Lift every ban
+func ClearBans(c *gin.Context, bc *blockchain.Blockchain) {
+	cleared := bc.PeerScores().ClearBans()
+	c.JSON(http.StatusOK, gin.H{"message": "Bans cleared", "cleared": cleared})
+}
+
+// Unban Lift the ban of a peer and reset its score
+func Unban(c *gin.Context, bc *blockchain.Blockchain) {
+	address := c.Param("address")
+	if !bc.PeerScores().Unban(address) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Peer is not banned", "address": address})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Peer unbanned", "address": address})
+}
+
+// GetPeers List the peers connected over the peer-to-peer protocol
+func GetPeers(c *gin.Context, node *p2p.Node) {
+	c.JSON(http.StatusOK, gin.H{"peers": node.Peers()})
+}
+
+// GetKnownPeers List the address book: every node address we know, most recently seen first
+func GetKnownPeers(c *gin.Context, node *p2p.Node) {
+	c.JSON(http.StatusOK, gin.H{"addresses": node.AddressBook().Addresses(p2p.MaxKnownAddresses)})
+}
+
+// ConnectPeer Open a peer-to-peer connection to another node
+func ConnectPeer(c *gin.Context, node *p2p.Node) {
+	var input struct {
+		Address string `json:"address" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+		return
+	}
+
+	peer, err := node.Connect(input.Address)
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message": "Connected to peer",
+		"peer":    peer.Info(),
 	})
 }
 
 // Consensus Resolve conflicts between nodes
 func Consensus(c *gin.Context, bc *blockchain.Blockchain) {
-	replaced := bc.ResolveConflicts()
+	result := bc.ResolveConflicts(c.Request.Context())
 
-	if replaced {
+	if result.Replaced {
 		c.JSON(http.StatusOK, gin.H{
-			"message":   "Our chain was replaced",
-			"new_chain": bc.Chain,
+			"message":     "Our chain was replaced",
+			"new_chain":   bc.Blocks(),
+			"local_work":  result.LocalWork,
+			"remote_work": result.RemoteWork,
+			"reorg":       result.Reorg,
+			"peers":       result.Peers,
 		})
 	} else {
 		c.JSON(http.StatusOK, gin.H{
-			"message": "Our chain is authoritative",
-			"chain":   bc.Chain,
+			"message":     "Our chain is authoritative",
+			"chain":       bc.Blocks(),
+			"local_work":  result.LocalWork,
+			"remote_work": result.RemoteWork,
+			"peers":       result.Peers,
 		})
 	}
 }
@@ -2,25 +2,69 @@ package api
 
 import (
 	"blocklite/blockchain"
+	"blocklite/miner"
+	"blocklite/p2p"
+	"crypto/subtle"
+	"net"
+	"net/http"
 
 	"github.com/gin-gonic/gin"
 )
 
-// SetupRoutes sets up the API routes with the blockchain instance.
-func SetupRoutes(router *gin.Engine, bc *blockchain.Blockchain) {
+// SetupRoutes sets up the API routes with the blockchain instance, its gossip, its peer-to-peer node, its background syncer and its miner.
+// The admin routes require adminToken, or come from this machine when it is empty.
+func SetupRoutes(router *gin.Engine, bc *blockchain.Blockchain, gossip *blockchain.Gossip, node *p2p.Node, syncer *blockchain.Syncer, m *miner.Miner, adminToken string) {
 	router.GET("/api/blocks", func(c *gin.Context) { GetBlocks(c, bc) })
 	router.POST("/api/blocks", func(c *gin.Context) { CreateBlock(c, bc) })
 	router.POST("/api/mine", func(c *gin.Context) { MineBlock(c, bc) })
+	router.POST("/api/miner/start", func(c *gin.Context) { StartMiner(c, m) })
+	router.POST("/api/miner/stop", func(c *gin.Context) { StopMiner(c, m) })
+	router.GET("/api/miner/status", func(c *gin.Context) { GetMinerStatus(c, m) })
+	router.GET("/api/mining/template", func(c *gin.Context) { GetMiningTemplate(c, m) })
+	router.POST("/api/mining/submit", func(c *gin.Context) { SubmitWork(c, m) })
 	router.GET("/api/proof", func(c *gin.Context) { GetProofOfWork(c, bc) })
 	router.GET("/api/previous-hash", func(c *gin.Context) { GetPreviousHash(c, bc) })
 	router.GET("/api/blocks/:index", func(c *gin.Context) { GetBlockByIndex(c, bc) })
 	router.GET("/api/timestamp", func(c *gin.Context) { GetTimestamp(c, bc) })
 	router.GET("/api/length", func(c *gin.Context) { GetLength(c, bc) })
 	router.GET("/api/full-chain", func(c *gin.Context) { GetFullChain(c, bc) })
+	router.GET("/api/chain/verify", func(c *gin.Context) { VerifyChain(c, bc) })
+	router.GET("/api/sync/headers", func(c *gin.Context) { GetHeaders(c, bc) })
+	router.GET("/api/sync/blocks", func(c *gin.Context) { GetBlocksByHash(c, bc) })
+	router.GET("/api/sync/status", func(c *gin.Context) { GetSyncStatus(c, syncer) })
 	router.POST("/api/transactions/new", func(c *gin.Context) { NewTransaction(c, bc) })
 	router.GET("/api/transactions/pending", func(c *gin.Context) { GetPendingTransactions(c, bc) })
+	router.GET("/api/transactions/:id", func(c *gin.Context) { GetTransaction(c, bc) })
+	router.GET("/api/transactions/:id/proof", func(c *gin.Context) { GetTransactionProof(c, bc) })
+	router.POST("/api/gossip/blocks", func(c *gin.Context) { ReceiveBlock(c, gossip) })
+	router.POST("/api/gossip/transactions", func(c *gin.Context) { ReceiveTransaction(c, gossip) })
 	router.POST("/api/wallet", func(c *gin.Context) { CreateWallet(c) })
 	router.POST("/api/nodes/register", func(c *gin.Context) { RegisterNodes(c, bc) })
 	router.GET("/api/nodes/resolve", func(c *gin.Context) { Consensus(c, bc) })
+	router.GET("/api/peers", func(c *gin.Context) { GetPeers(c, node) })
+	admin := router.Group("/api/admin", AdminOnly(adminToken))
+	admin.GET("/bans", func(c *gin.Context) { GetBans(c, bc) })
+	admin.DELETE("/bans", func(c *gin.Context) { ClearBans(c, bc) })
+	admin.DELETE("/bans/:address", func(c *gin.Context) { Unban(c, bc) })
+	router.GET("/api/peers/known", func(c *gin.Context) { GetKnownPeers(c, node) })
+	router.POST("/api/peers/connect", func(c *gin.Context) { ConnectPeer(c, node) })
 	router.GET("/api/balance/:address", func(c *gin.Context) { GetBalance(c, bc) })
+	router.GET("/api/supply", func(c *gin.Context) { GetSupply(c, bc) })
+	router.GET("/api/utxos/:address", func(c *gin.Context) { GetUnspentOutputs(c, bc) })
+}
+
+// AdminOnly Let a request through only with the admin token as a bearer token, or, when no
+// token is set, only from this machine
+func AdminOnly(token string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if token == "" {
+			if ip := net.ParseIP(c.RemoteIP()); ip == nil || !ip.IsLoopback() {
+				c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Admin endpoints are only available from localhost unless ADMIN_TOKEN is set"})
+			}
+			return
+		}
+		if subtle.ConstantTimeCompare([]byte(c.GetHeader("Authorization")), []byte("Bearer "+token)) != 1 {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Missing or invalid admin token"})
+		}
+	}
 }
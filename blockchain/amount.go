@@ -0,0 +1,114 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Amount is a quantity of MaskedCoin in base units. 1 MaskedCoin is 10^8 units.
+type Amount int64
+
+// AmountDecimals is the number of decimal places of a MaskedCoin
+const AmountDecimals = 8
+
+// Coin is one MaskedCoin in base units
+const Coin Amount = 100_000_000
+
+// ErrInvalidAmount is returned for amounts that are malformed, negative or out of range
+var ErrInvalidAmount = errors.New("invalid amount")
+
+// ParseAmount parses a decimal number of coins such as "10.5" into base units without rounding
+func ParseAmount(s string) (Amount, error) {
+	negative := strings.HasPrefix(s, "-")
+	whole, fraction, _ := strings.Cut(strings.TrimPrefix(s, "-"), ".")
+
+	if whole == "" || len(fraction) > AmountDecimals || !isDigits(whole) || !isDigits(fraction) {
+		return 0, fmt.Errorf("%w: %q", ErrInvalidAmount, s)
+	}
+
+	coins, err := strconv.ParseInt(whole, 10, 64)
+	if err != nil || coins > int64(maxAmount/Coin) {
+		return 0, fmt.Errorf("%w: %q is out of range", ErrInvalidAmount, s)
+	}
+
+	units := int64(0)
+	if fraction != "" {
+		units, _ = strconv.ParseInt(fraction+strings.Repeat("0", AmountDecimals-len(fraction)), 10, 64)
+	}
+
+	amount := Amount(coins)*Coin + Amount(units)
+	if amount > maxAmount {
+		return 0, fmt.Errorf("%w: %q is out of range", ErrInvalidAmount, s)
+	}
+	if negative {
+		amount = -amount
+	}
+	return amount, nil
+}
+
+// maxAmount keeps sums of a few amounts far away from int64 overflow
+const maxAmount = Amount(1 << 60)
+
+// String formats the amount as a decimal number of coins without trailing zeros, e.g. "10.5".
+// For amounts created from the old float64 values this is the same text strconv.FormatFloat
+// produced.
+func (a Amount) String() string {
+	sign := ""
+	if a < 0 {
+		sign = "-"
+		a = -a
+	}
+
+	whole := strconv.FormatInt(int64(a/Coin), 10)
+	fraction := strings.TrimRight(fmt.Sprintf("%08d", int64(a%Coin)), "0")
+	if fraction == "" {
+		return sign + whole
+	}
+	return sign + whole + "." + fraction
+}
+
+// MarshalJSON encodes the amount as a decimal string so that clients never round it
+func (a Amount) MarshalJSON() ([]byte, error) {
+	return json.Marshal(a.String())
+}
+
+// UnmarshalJSON accepts a decimal string or a plain JSON number of coins.
+// Numbers are parsed from their text, never through float64.
+func (a *Amount) UnmarshalJSON(data []byte) error {
+	text := string(bytes.TrimSpace(data))
+	if text == "null" {
+		return nil
+	}
+	if strings.HasPrefix(text, `"`) {
+		if err := json.Unmarshal(data, &text); err != nil {
+			return err
+		}
+	} else if strings.ContainsAny(text, "eE") {
+		// float64 values were written in exponent form when very small, e.g. 1e-07
+		value, err := strconv.ParseFloat(text, 64)
+		if err != nil {
+			return fmt.Errorf("%w: %s", ErrInvalidAmount, text)
+		}
+		text = strconv.FormatFloat(value, 'f', -1, 64)
+	}
+
+	amount, err := ParseAmount(text)
+	if err != nil {
+		return err
+	}
+	*a = amount
+	return nil
+}
+
+func isDigits(s string) bool {
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
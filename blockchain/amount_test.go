@@ -0,0 +1,75 @@
+package blockchain
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+	"testing"
+)
+
+func TestParseAmount(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected Amount
+		err      error
+	}{
+		{input: "50", expected: 50 * Coin},
+		{input: "10.5", expected: 1_050_000_000},
+		{input: "0.00000001", expected: 1},
+		{input: "0.1", expected: 10_000_000},
+		{input: "-2.25", expected: -225_000_000},
+		{input: "1.000000001", err: ErrInvalidAmount},
+		{input: "1e8", err: ErrInvalidAmount},
+		{input: "", err: ErrInvalidAmount},
+		{input: ".5", err: ErrInvalidAmount},
+		{input: "99999999999999999999", err: ErrInvalidAmount},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := ParseAmount(tt.input)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("ParseAmount(%q) error = %v; want %v", tt.input, err, tt.err)
+			}
+			if got != tt.expected {
+				t.Errorf("ParseAmount(%q) = %d; want %d", tt.input, got, tt.expected)
+			}
+		})
+	}
+}
+
+// TestAmountStringMatchesFloatFormatting guards the hashes of transactions migrated from float64 amounts.
+func TestAmountStringMatchesFloatFormatting(t *testing.T) {
+	for _, value := range []float64{50, 10.5, 0.1, 0.00000001, 123.456, 100} {
+		legacy := strconv.FormatFloat(value, 'f', -1, 64)
+		amount, err := ParseAmount(legacy)
+		if err != nil {
+			t.Fatalf("ParseAmount(%q) error = %v", legacy, err)
+		}
+		if amount.String() != legacy {
+			t.Errorf("Amount(%q).String() = %q; want %q", legacy, amount.String(), legacy)
+		}
+	}
+}
+
+func TestAmountJSON(t *testing.T) {
+	var tx Transaction
+	if err := json.Unmarshal([]byte(`{"sender":"A","receiver":"B","amount":10.1}`), &tx); err != nil {
+		t.Fatalf("Unmarshal number error = %v", err)
+	}
+	if tx.Amount != 1_010_000_000 {
+		t.Errorf("Amount from number = %d; want 1010000000", tx.Amount)
+	}
+
+	if err := json.Unmarshal([]byte(`{"sender":"A","receiver":"B","amount":"0.3"}`), &tx); err != nil {
+		t.Fatalf("Unmarshal string error = %v", err)
+	}
+	if tx.Amount != 30_000_000 {
+		t.Errorf("Amount from string = %d; want 30000000", tx.Amount)
+	}
+
+	data, err := json.Marshal(Amount(30_000_000))
+	if err != nil || string(data) != `"0.3"` {
+		t.Errorf("Marshal = %s, %v; want \"0.3\"", data, err)
+	}
+}
@@ -5,28 +5,75 @@ import (
 	"encoding/hex"
 	"fmt"
 	"strconv"
+	"strings"
 )
 
 type Block struct {
 	Index        int
 	Timestamp    string
 	Transactions []Transaction
-	Proof        int // TODO: for now, we keep it int
+	Proof        int // nonce of the header; TODO: for now, we keep it int
 	PreviousHash string
+	Difficulty   int    // number of leading zero bits required for the header hash
+	MerkleRoot   string // Merkle root of the transactions, committed to by the header
+	ChainWork    uint64 // cumulative work of the chain up to and including this block
 }
 
-// CalculateHash Calculate Hash of the Block
-func (b *Block) CalculateHash() string {
-	txData := ""
-	for _, tx := range b.Transactions {
-		txData += tx.Sender + tx.Receiver + strconv.FormatFloat(tx.Amount, 'f', -1, 64)
+// BlockHeader holds the fields of a block covered by the proof of work.
+type BlockHeader struct {
+	Index        int    `json:"index"`
+	Timestamp    string `json:"timestamp"`
+	PreviousHash string `json:"previous_hash"`
+	MerkleRoot   string `json:"merkle_root"`
+	Difficulty   int    `json:"difficulty"`
+	Nonce        int    `json:"nonce"`
+}
+
+// Header Return the header of the block
+func (b *Block) Header() BlockHeader {
+	return BlockHeader{
+		Index:        b.Index,
+		Timestamp:    b.Timestamp,
+		PreviousHash: b.PreviousHash,
+		MerkleRoot:   b.MerkleRoot,
+		Difficulty:   b.Difficulty,
+		Nonce:        b.Proof,
 	}
-	blockData := strconv.Itoa(b.Index) + b.Timestamp + txData + strconv.Itoa(b.Proof) + b.PreviousHash
-	hashedData := utils.SHA256(blockData)
+}
+
+// Serialize Return the canonical encoding of the header that gets hashed
+func (h BlockHeader) Serialize() string {
+	return strings.Join([]string{
+		strconv.Itoa(h.Index),
+		h.Timestamp,
+		h.PreviousHash,
+		h.MerkleRoot,
+		strconv.Itoa(h.Difficulty),
+		strconv.Itoa(h.Nonce),
+	}, "|")
+}
+
+// Hash Calculate the SHA-256 hash of the serialized header
+func (h BlockHeader) Hash() [32]byte {
+	return utils.SHA256(h.Serialize())
+}
+
+// CalculateHash Calculate Hash of the Block, which is the hash of its header
+func (b *Block) CalculateHash() string {
+	hashedData := b.Header().Hash()
 	hashHex := hex.EncodeToString(hashedData[:])
 	return hashHex
 }
 
+// TotalFees Return the sum of the fees paid by the transactions of the block
+func (b *Block) TotalFees() Amount {
+	fees := Amount(0)
+	for _, tx := range b.Transactions {
+		fees += tx.Fee
+	}
+	return fees
+}
+
 // Print the details of the block
 func (b *Block) Print() {
 	fmt.Printf("{Index: %d, Timestamp: %s, Transactions: %d, Proof: %d, PreviousHash: %s}\n",
@@ -8,7 +8,7 @@ import (
 )
 
 // TestCalculateHash verifies that the CalculateHash method produces the expected SHA-256 hash
-// for various block configurations, ensuring correct concatenation and hashing of block fields.
+// for various block configurations, ensuring correct serialization and hashing of the block header.
 func TestCalculateHash(t *testing.T) {
 	// Test cases cover standard scenarios, zero proof, and empty previous hash for robustness.
 	tests := []struct {
@@ -24,8 +24,8 @@ func TestCalculateHash(t *testing.T) {
 				Proof:        12345,
 				PreviousHash: "0",
 			},
-			// Expected hash computed for "12025-07-06T12:00:00Z123450" using SHA-256
-			expected: "3a6cd2d1894f38cd050281be09ef785723fe45ddc291ed9f4b78d13f12571eb0",
+			// Expected hash computed for the header "1|2025-07-06T12:00:00Z|0||0|12345" using SHA-256
+			expected: "0098dca44a222bd2919aa820aeda787f47b30548c7dece6b9345125918cbb98b",
 		},
 		{
 			name: "Zero proof",
@@ -35,8 +35,8 @@ func TestCalculateHash(t *testing.T) {
 				Proof:        0,
 				PreviousHash: "abc123",
 			},
-			// Expected hash computed for "22025-07-06T13:00:00Z0abc123" using SHA-256
-			expected: "dbc830895f2083649ffbe715d9aeae0216c597003655d63802f0da02b7e0e4c2",
+			// Expected hash computed for the header "2|2025-07-06T13:00:00Z|abc123||0|0" using SHA-256
+			expected: "4cf872be8c20f8a798f2278a439e009fdd1502c0259e0e7d47ce0d7d630ad4da",
 		},
 		{
 			name: "Empty previous hash",
@@ -46,8 +46,8 @@ func TestCalculateHash(t *testing.T) {
 				Proof:        999,
 				PreviousHash: "",
 			},
-			// Expected hash computed for "32025-07-06T14:00:00Z999" using SHA-256
-			expected: "c507012b088a24d63be367859a1c51f619a2e1c045771581339b0cdba87cb095",
+			// Expected hash computed for the header "3|2025-07-06T14:00:00Z|||0|999" using SHA-256
+			expected: "62f37b62ceb2c11ad726e123c96e8e91646a1bb7cdd435a73064ce1014c33b04",
 		},
 	}
 
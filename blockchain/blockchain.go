@@ -1,153 +1,387 @@
 package blockchain
 
 import (
-	"blocklite/utils"
-	"blocklite/wallet"
+	"bytes"
+	"context"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"net/http"
+	"math"
+	"net"
 	"os"
-	"strconv"
+	"runtime"
+	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
-// ValidChain check if a given blockchain is valid
-func (bc *Blockchain) ValidChain(chain []Block) bool {
+// ValidChain replays a chain from its genesis block and checks every block against the
+// consensus rules: header, proof of work, signatures, coinbase, and that every transfer is
+// covered by its sender's balance. It returns a *ValidationError naming the block,
+// transaction and rule at fault, or nil for a valid chain.
+func (bc *Blockchain) ValidChain(chain []Block) error {
 	if len(chain) == 0 {
-		return false
+		return &ValidationError{TxIndex: -1, Rule: RuleGenesis, Err: errors.New("empty chain")}
+	}
+	if err := checkGenesis(chain[0]); err != nil {
+		return err
 	}
 
-	previousBlock := chain[0]
-	currentIndex := 1
-
-	for currentIndex < len(chain) {
-		block := chain[currentIndex]
-
-		// Check that the hash of the block is correct
-		if block.PreviousHash != previousBlock.CalculateHash() {
-			return false
-		}
-
-		// Check that the Proof of Work is correct
-		if valid, _ := VerifyProof(block.Proof, previousBlock.Proof); !valid {
-			return false
-		}
-
-		// Verify all transaction signatures in the block
-		for _, tx := range block.Transactions {
-			if tx.Sender != "0" {
-				data := tx.Sender + tx.Receiver + strconv.FormatFloat(tx.Amount, 'f', -1, 64)
-				if !wallet.Verify(tx.Sender, data, tx.Signature) {
-					return false
-				}
-			}
+	// Every block must apply to the ledger as it stands after the previous one
+	state := newLedgerState(bc.ledger(), bc.coinbaseMaturity())
+	if _, err := state.connectBlock(chain[0]); err != nil {
+		return err
+	}
+	for i := 1; i < len(chain); i++ {
+		if _, err := checkBlock(chain[:i], chain[i], state, bc.emission(), bc.retarget()); err != nil {
+			return err
 		}
-
-		previousBlock = block
-		currentIndex++
 	}
 
-	return true
+	return nil
 }
 
 var timeNow = time.Now
 
 const BlockchainFile = "blockchain.json"
-const MiningReward = 50.0
+
+// MiningReward is the reward of the first blocks under the default emission schedule
+const MiningReward = 50 * Coin
+
+// ErrStaleBlock is returned when a block does not build on the current tip of the chain.
+var ErrStaleBlock = errors.New("block does not extend the current tip")
+
+// ErrInvalidBlock is returned when a block breaks one of the consensus rules.
+var ErrInvalidBlock = errors.New("invalid block")
+
+// ErrGenesisMismatch is returned for a chain that does not start with the genesis block of
+// our genesis settings
+var ErrGenesisMismatch = errors.New("genesis block does not match the genesis settings")
+
+// ErrLegacyChain is returned by LoadFromFile for a version 1 file. Version 1 transactions
+// were signed without a nonce and blocks carried no Merkle root, difficulty or chain work,
+// so such a chain cannot pass the current consensus rules; the node has to start over.
+var ErrLegacyChain = errors.New("version 1 blockchain file is no longer supported, remove it to reinitialize the chain")
 
 // Blockchain The entire blockchain
 type Blockchain struct {
-	Chain               []Block
-	CurrentTransactions []Transaction
-	Nodes               map[string]bool
-	mux                 sync.Mutex
+	Chain            []Block
+	Nodes            map[string]bool
+	Ledger           LedgerModel // account or UTXO model, from the genesis settings
+	ChainID          string      // name of the network, from the genesis settings
+	Emission         Emission    // block reward schedule, from the genesis settings
+	CoinbaseMaturity int         // confirmations a mining reward needs before it can be spent, from the genesis settings
+	Retarget         Retarget    // difficulty adjustment schedule, from the genesis settings
+	mux              sync.Mutex
+
+	mempool Mempool                  // transactions waiting for the next block
+	txIndex map[string]TxLocation    // transaction ID -> where it was confirmed
+	heights map[string]int           // block hash -> position in Chain
+	state   *ledgerState             // balances, nonces or unspent outputs at the tip
+	undo    map[string][]SpentOutput // block hash -> outputs it spent (UTXO model)
+	peers   PeerScores               // misbehavior of the nodes we talk to
+
+	blockListeners []func(Block)
+	txListeners    []func(Transaction)
+	reorgListeners []func(ReorgEvent)
+	tipListeners   []func(Block)
+	tipChanged     chan struct{} // closed at the next tip change, see tipWatch
 }
 
-// Transaction represents a transfer of value
-type Transaction struct {
-	Sender    string  `json:"sender"`
-	Receiver  string  `json:"receiver"`
-	Amount    float64 `json:"amount"`
-	Signature string  `json:"signature,omitempty"`
+// AddBlock checks that a mined block extends the current tip and appends it to the chain.
+// Pending transactions are checked again afterwards: the ones included in the block, and the
+// ones it made invalid, leave the mempool. The OnBlock and OnTipChanged listeners are called
+// for the new block.
+func (bc *Blockchain) AddBlock(block Block) error {
+	if err := bc.addBlock(block); err != nil {
+		return err
+	}
+	bc.notifyTipChanged(block)
+	bc.notifyBlock(block)
+	return nil
 }
 
-// CreateBlock adds a new block to the blockchain
-func (bc *Blockchain) CreateBlock(proof int, previousHash string) Block {
+// addBlock checks and appends a block
+func (bc *Blockchain) addBlock(block Block) error {
 	bc.mux.Lock()
 	defer bc.mux.Unlock()
 
-	block := Block{
-		Index: len(bc.Chain) + 1,
-		// Timestamp: time.Now().String(),
-		Timestamp:    timeNow().UTC().Format(time.RFC3339),
-		Transactions: bc.CurrentTransactions,
-		Proof:        proof,
-		PreviousHash: previousHash,
+	previousHash := "0"
+	if len(bc.Chain) > 0 {
+		previousHash = bc.Chain[len(bc.Chain)-1].CalculateHash()
 	}
 
-	// Reset the current list of transactions
-	bc.CurrentTransactions = []Transaction{}
+	if block.Index != len(bc.Chain)+1 || block.PreviousHash != previousHash {
+		return ErrStaleBlock
+	}
+	undo, err := checkBlock(bc.Chain, block, bc.ledgerState(), bc.emission(), bc.retarget())
+	if err != nil {
+		return err
+	}
+	if undo != nil {
+		bc.undo[block.CalculateHash()] = undo
+	}
 
 	bc.Chain = append(bc.Chain, block)
-	
+	bc.indexBlock(block)
+	bc.mempool.revalidate(bc.state)
+
 	// Persistence: save the chain after every new block
-	_ = bc.Save(BlockchainFile)
-	
-	return block
+	bc.persist()
+
+	return nil
 }
 
-// NewBlockChain creates a new blockchain and adds the genesis block
-func NewBlockChain() *Blockchain {
-	bc := &Blockchain{
-		Chain:               []Block{},
-		CurrentTransactions: []Transaction{},
-		Nodes:               make(map[string]bool),
+// CreateBlock mines the pending transactions into a new block paying the reward and fees
+// to miner, and adds it to the blockchain
+func (bc *Blockchain) CreateBlock(miner string) (Block, error) {
+	return bc.CreateBlockContext(context.Background(), miner)
+}
+
+// CreateBlockContext is CreateBlock, but gives up with ctx.Err() when ctx is done. When
+// the tip changes while it mines, it abandons the attempt and starts over on the new tip.
+func (bc *Blockchain) CreateBlockContext(ctx context.Context, miner string) (Block, error) {
+	for {
+		if err := ctx.Err(); err != nil {
+			return Block{}, err
+		}
+
+		tipChanged := bc.tipWatch()
+		block := bc.NewBlockTemplate(miner)
+		attempt, abort := context.WithCancel(ctx)
+		go func() {
+			select {
+			case <-tipChanged:
+				abort()
+			case <-attempt.Done():
+			}
+		}()
+		proof, err := SearchProof(attempt, block.Header(), nil)
+		abort()
+		if err != nil {
+			// Either ctx is done or a new tip arrived; the loop tells them apart
+			continue
+		}
+
+		// Another block may have been added while we were mining; start over on the new tip
+		block.Proof = proof
+		err = bc.AddBlock(block)
+		if !errors.Is(err, ErrStaleBlock) {
+			return block, err
+		}
 	}
+}
 
-	// Persistence: try to load existing chain
-	if err := bc.LoadFromFile(BlockchainFile); err == nil && len(bc.Chain) > 0 {
-		return bc
+// newGenesisBlock returns the first block of a chain with the given settings. It is not
+// mined and depends on nothing but the settings: it has a fixed timestamp, and having no
+// transactions, its Merkle root commits to the hash of the settings instead. Nodes with the
+// same settings thus share the genesis block, and nodes with other settings cannot.
+func newGenesisBlock(settings Genesis) Block {
+	return Block{
+		Index:        1,
+		Timestamp:    GenesisTimestamp,
+		Transactions: []Transaction{},
+		Proof:        1,
+		PreviousHash: "0",
+		Difficulty:   InitialDifficulty,
+		MerkleRoot:   settings.Hash(),
+		ChainWork:    BlockWork(InitialDifficulty),
 	}
+}
 
-	bc.CreateBlock(1, "0")
+// NewBlockChain creates a new blockchain with the default genesis settings
+func NewBlockChain() *Blockchain {
+	return NewBlockChainWithGenesis(DefaultGenesis())
+}
 
+// NewBlockChainWithGenesis creates a new blockchain with the given genesis settings. The chain
+// saved in BlockchainFile is used when it is valid; otherwise the reason is reported and a new
+// chain starts from the genesis block. Use OpenBlockChain to refuse an unusable file instead.
+func NewBlockChainWithGenesis(genesis Genesis) *Blockchain {
+	bc, err := OpenBlockChain(genesis)
+	if err != nil {
+		fmt.Printf("Starting a new chain, %s cannot be used: %v\n", BlockchainFile, err)
+		bc = newBlockchain(genesis)
+		bc.Chain = append(bc.Chain, newGenesisBlock(genesis))
+		_ = bc.reindex()
+		_ = bc.Save(BlockchainFile)
+	}
 	return bc
 }
 
-// AddTransaction creates a new transaction to go into the next mined Block
-func (bc *Blockchain) AddTransaction(sender, receiver string, amount float64, signature string) int {
+// OpenBlockChain loads the chain saved in BlockchainFile, replaying it with ValidChain, or
+// starts a new one from the genesis block when there is no file yet. A file that cannot be
+// read, holds an invalid chain or a chain with another genesis block is an error.
+func OpenBlockChain(genesis Genesis) (*Blockchain, error) {
+	bc := newBlockchain(genesis)
+	err := bc.LoadFromFile(BlockchainFile)
+	if errors.Is(err, os.ErrNotExist) {
+		bc.Chain = []Block{newGenesisBlock(genesis)}
+		if err := bc.reindex(); err != nil {
+			return nil, err
+		}
+		return bc, bc.Save(BlockchainFile)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if len(bc.Chain) > 0 {
+		expected := newGenesisBlock(genesis)
+		if saved, want := bc.Chain[0].CalculateHash(), expected.CalculateHash(); saved != want {
+			return nil, fmt.Errorf("%w: saved genesis block %s, the settings give %s", ErrGenesisMismatch, saved, want)
+		}
+	}
+	if err := bc.ValidChain(bc.Chain); err != nil {
+		return nil, err
+	}
+	if err := bc.reindex(); err != nil {
+		return nil, err
+	}
+	return bc, nil
+}
+
+// newBlockchain returns an empty blockchain with the given genesis settings
+func newBlockchain(genesis Genesis) *Blockchain {
+	return &Blockchain{
+		Chain:            []Block{},
+		Nodes:            make(map[string]bool),
+		Ledger:           genesis.Ledger,
+		ChainID:          genesis.ChainID,
+		Emission:         genesis.Emission,
+		CoinbaseMaturity: genesis.CoinbaseMaturity,
+		Retarget:         genesis.Retarget,
+	}
+}
+
+// AddTransaction creates a new transaction to go into the next mined Block.
+// The transaction gets the sender's next nonce and is not validated, so it is dropped when the
+// next block is assembled unless it is valid by then; see SubmitTransaction.
+func (bc *Blockchain) AddTransaction(sender, receiver string, amount Amount, signature string) int {
 	bc.mux.Lock()
 	defer bc.mux.Unlock()
 
-	bc.CurrentTransactions = append(bc.CurrentTransactions, Transaction{
+	tx := Transaction{
 		Sender:    sender,
 		Receiver:  receiver,
 		Amount:    amount,
 		Signature: signature,
-	})
+	}
+	if tx.IsCoinbase() {
+		// Coinbase transactions carry the index of their block so that their IDs are unique
+		tx.Nonce = uint64(len(bc.Chain) + 1)
+	} else {
+		tx.Nonce = bc.mempool.nextNonce(sender, bc.ledgerState())
+	}
+	bc.mempool.insert(tx, bc.ledgerState())
 
 	return len(bc.Chain) + 1
 }
 
+// SubmitTransaction validates a signed transaction against the chain and the pending
+// transactions, then adds it to the mempool for the next mined Block. A sender cannot
+// spend more than its confirmed balance minus what it already has pending, and on a UTXO
+// chain an output can only be spent by one pending transaction. The OnTransaction listeners
+// are called for accepted transactions.
+func (bc *Blockchain) SubmitTransaction(tx Transaction) (int, error) {
+	bc.mux.Lock()
+	err := bc.mempool.add(tx, bc.ledgerState())
+	index := len(bc.Chain) + 1
+	bc.mux.Unlock()
+
+	if err != nil {
+		return 0, err
+	}
+	bc.notifyTransaction(tx)
+	return index, nil
+}
+
+// NextNonce returns the nonce the next transaction from address must use
+func (bc *Blockchain) NextNonce(address string) uint64 {
+	bc.mux.Lock()
+	defer bc.mux.Unlock()
+	return bc.mempool.nextNonce(address, bc.ledgerState())
+}
+
+// PendingTransactions returns the transactions waiting to be mined, in arrival order
+func (bc *Blockchain) PendingTransactions() []Transaction {
+	bc.mux.Lock()
+	defer bc.mux.Unlock()
+	return bc.mempool.Transactions()
+}
+
 // GetBalance returns the balance of a given address
-func (bc *Blockchain) GetBalance(address string) float64 {
+func (bc *Blockchain) GetBalance(address string) Amount {
 	bc.mux.Lock()
 	defer bc.mux.Unlock()
+	return bc.balance(address)
+}
 
-	balance := 0.0
-	for _, block := range bc.Chain {
-		for _, tx := range block.Transactions {
-			if tx.Sender == address {
-				balance -= tx.Amount
-			}
-			if tx.Receiver == address {
-				balance += tx.Amount
-			}
-		}
+// GetImmatureBalance returns the part of the balance of an address paid by coinbases that do
+// not have enough confirmations yet, which the next block cannot spend
+func (bc *Blockchain) GetImmatureBalance(address string) Amount {
+	bc.mux.Lock()
+	defer bc.mux.Unlock()
+	return bc.ledgerState().immatureBalance(address)
+}
+
+// GetUnspentOutputs returns the unspent outputs locked to an address on a UTXO chain
+func (bc *Blockchain) GetUnspentOutputs(address string) []UTXO {
+	bc.mux.Lock()
+	defer bc.mux.Unlock()
+	return bc.ledgerState().utxo.Unspent(address)
+}
+
+// ledger returns the ledger model of the chain, the account model unless configured otherwise
+func (bc *Blockchain) ledger() LedgerModel {
+	if bc.Ledger == "" {
+		return AccountModel
+	}
+	return bc.Ledger
+}
+
+// GetChainID returns the name of the network, DefaultChainID unless configured otherwise
+func (bc *Blockchain) GetChainID() string {
+	if bc.ChainID == "" {
+		return DefaultChainID
+	}
+	return bc.ChainID
+}
+
+// GetCoinbaseMaturity returns the number of confirmations a mining reward needs before it can
+// be spent, DefaultCoinbaseMaturity unless configured otherwise
+func (bc *Blockchain) GetCoinbaseMaturity() int {
+	return bc.coinbaseMaturity()
+}
+
+// coinbaseMaturity returns the maturity of the genesis settings
+func (bc *Blockchain) coinbaseMaturity() int {
+	if bc.CoinbaseMaturity == 0 {
+		return DefaultCoinbaseMaturity
+	}
+	return bc.CoinbaseMaturity
+}
+
+// GetGenesisHash returns the hash of the first block of the chain
+func (bc *Blockchain) GetGenesisHash() string {
+	bc.mux.Lock()
+	defer bc.mux.Unlock()
+	return bc.Chain[0].CalculateHash()
+}
+
+// ledgerState returns the confirmed ledger state at the tip. The caller must hold bc.mux.
+func (bc *Blockchain) ledgerState() *ledgerState {
+	if bc.state == nil {
+		bc.rebuild()
 	}
-	return balance
+	return bc.state
+}
+
+// balance returns the confirmed balance of an address. The caller must hold bc.mux.
+func (bc *Blockchain) balance(address string) Amount {
+	return bc.ledgerState().balance(address)
 }
 
 // GetLatestBlock Return the last/previous Block in the Blockchain
@@ -159,82 +393,121 @@ func (bc *Blockchain) GetLatestBlock() Block {
 	return lastBlock
 }
 
-// VerifyProof Verify the proof
-func VerifyProof(proof, lastProof int) (bool, string) {
-	blockData := strconv.Itoa(proof) + strconv.Itoa(lastProof)
-	// fmt.Printf("Generated code: %s\n", blockData)
-
-	hashedData := utils.SHA256(blockData)
+// VerifyProof Verify that the hash of the header meets the header's difficulty
+func VerifyProof(header BlockHeader) (bool, string) {
+	hashedData := header.Hash()
 	hashHex := hex.EncodeToString(hashedData[:])
 
 	// fmt.Printf("Encoded Hex Code: %s\n", hashHex)
-	return hashHex[:4] == "0000", hashHex
+	return meetsDifficulty(hashedData, header.Difficulty), hashHex
 }
 
-// ProofOfWork is a simple algorithm that identifies a new proof number
-// such that the hash of the concatenation of the previous proof and the new proof
-// contains 4 leading zeroes. The previous proof is provided as input.
-func ProofOfWork(lastProof int) int {
-	proofNumber := 0
-
-	// Keep incrementing the proof number until a valid proof is found
-	// A valid proof is one that, when hashed with the last proof, results in a hash
-	// with 4 leading zeroes.
-	valid, hashHex := VerifyProof(proofNumber, lastProof)
-	for !valid {
-		proofNumber += 1
-		valid, hashHex = VerifyProof(proofNumber, lastProof)
-	}
+// ProofOfWork is a simple algorithm that identifies a nonce for the given header
+// such that the hash of the serialized header has at least Difficulty leading zero bits.
+// Because the header commits to the Merkle root, changing any transaction means redoing the work.
+func ProofOfWork(header BlockHeader) int {
+	nonce, _ := SearchProof(context.Background(), header, nil)
+	header.Nonce = nonce
+	_, hashHex := VerifyProof(header)
 
 	fmt.Printf("Encoded Hex Code: %s\n", hashHex)
 
-	return proofNumber
+	return nonce
 }
 
-// IsChainValid Function to check if the blockchain is valid
-func (bc *Blockchain) IsChainValid() bool {
-	bc.mux.Lock()
-	defer bc.mux.Unlock()
-
-	previousBlock := bc.Chain[0]
-	blockIndex := 1
-
-	for blockIndex < len(bc.Chain) {
+// MiningWorkers is the number of goroutines that search for a proof of work together
+var MiningWorkers = runtime.NumCPU()
+
+// proofCheckInterval is the number of nonces a worker tries between checks for cancellation
+const proofCheckInterval = 1024
+
+// SearchProof looks for a proof of work like ProofOfWork, but gives up with ctx.Err() when
+// ctx is done. The number of hashes tried is added to hashes, unless it is nil, as the
+// search goes, so callers can measure the hash rate.
+//
+// The nonces are split across MiningWorkers goroutines, worker i trying i, i+workers, and
+// so on. When a worker finds a proof, the others stop as soon as they pass its nonce, so the
+// lowest valid nonce wins whatever the number of workers.
+func SearchProof(ctx context.Context, header BlockHeader, hashes *atomic.Uint64) (int, error) {
+	workers := max(MiningWorkers, 1)
+	var best atomic.Int64
+	best.Store(math.MaxInt64)
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(start int) {
+			defer wg.Done()
+			searchNonces(ctx, header, start, workers, &best, hashes)
+		}(i)
+	}
+	wg.Wait()
 
-		block := bc.Chain[blockIndex]
+	if nonce := best.Load(); nonce != math.MaxInt64 {
+		return int(nonce), nil
+	}
+	return 0, ctx.Err()
+}
 
-		// compare the indices
-		if block.Index != previousBlock.Index+1 {
-			fmt.Println("Index Invalid")
-			return false
-		}
-		// Compare the previous hash of the block with the hash of the previous block
-		if block.PreviousHash != previousBlock.CalculateHash() {
-			fmt.Println("Hash Invalid")
-			return false
+// searchNonces tries the nonces start, start+step, ... below best and lowers best to the
+// first one that meets the difficulty. It returns early when ctx is done.
+func searchNonces(ctx context.Context, header BlockHeader, start, step int, best *atomic.Int64, hashes *atomic.Uint64) {
+	tried := uint64(0)
+	defer func() {
+		if hashes != nil {
+			hashes.Add(tried)
 		}
-		// Verify the proof of work
-		if valid, _ := VerifyProof(block.Proof, previousBlock.Proof); !valid {
-			fmt.Println("Proof Invalid")
-			return false
+	}()
+
+	for nonce := start; int64(nonce) < best.Load(); nonce += step {
+		header.Nonce = nonce
+		tried++
+		if meetsDifficulty(header.Hash(), header.Difficulty) {
+			for current := best.Load(); int64(nonce) < current && !best.CompareAndSwap(current, int64(nonce)); {
+				current = best.Load()
+			}
+			return
 		}
-		// Compare the timestamps
-		if block.Timestamp <= previousBlock.Timestamp {
-			fmt.Println("Timestamp Invalid")
-			return false
+		if tried == proofCheckInterval {
+			if hashes != nil {
+				hashes.Add(tried)
+			}
+			tried = 0
+			if ctx.Err() != nil {
+				return
+			}
 		}
+	}
+}
 
-		previousBlock = bc.Chain[blockIndex]
-		blockIndex += 1
+// IsChainValid checks the headers of our own chain: indices, hashes, difficulty, chain work,
+// Merkle roots, proofs and timestamps. It returns a *ValidationError for the
+// first block at fault, or nil.
+func (bc *Blockchain) IsChainValid() error {
+	bc.mux.Lock()
+	defer bc.mux.Unlock()
 
+	if err := checkGenesis(bc.Chain[0]); err != nil {
+		return err
 	}
 
-	return true
+	for blockIndex := 1; blockIndex < len(bc.Chain); blockIndex++ {
+		if err := checkHeader(bc.Chain[:blockIndex], bc.Chain[blockIndex], bc.retarget()); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// VerifyChain replays our own chain from genesis with ValidChain
+func (bc *Blockchain) VerifyChain() error {
+	return bc.ValidChain(bc.Blocks())
 }
 
 // Print PrintBlocks prints all blocks in the blockchain
 func (bc *Blockchain) Print() {
-	for _, block := range bc.Chain {
+	for _, block := range bc.Blocks() {
 		// fmt.Printf("Index: %d, Timestamp: %s, Proof: %d, PreviousHash: %s\n", block.Index, block.Timestamp, block.Proof, block.PreviousHash)
 		block.Print()
 	}
@@ -247,6 +520,13 @@ func (bc *Blockchain) GetLength() int {
 	return len(bc.Chain)
 }
 
+// Blocks Return a copy of the chain, safe to use while blocks are being added
+func (bc *Blockchain) Blocks() []Block {
+	bc.mux.Lock()
+	defer bc.mux.Unlock()
+	return append([]Block{}, bc.Chain...)
+}
+
 // GetBlockByIndex Return the block at the specified index (1-based)
 func (bc *Blockchain) GetBlockByIndex(index int) (Block, bool) {
 	bc.mux.Lock()
@@ -257,22 +537,151 @@ func (bc *Blockchain) GetBlockByIndex(index int) (Block, bool) {
 	return bc.Chain[index-1], true
 }
 
+// FindTransaction Return the block containing the transaction with the given ID and its position in it
+func (bc *Blockchain) FindTransaction(id string) (Block, int, bool) {
+	bc.mux.Lock()
+	defer bc.mux.Unlock()
+
+	location, ok := bc.lookupTransaction(id)
+	if !ok {
+		return Block{}, 0, false
+	}
+	return bc.Chain[location.BlockIndex-1], location.Position, true
+}
+
+// GetTransactionStatus Report whether a transaction is pending, confirmed or unknown to this node
+func (bc *Blockchain) GetTransactionStatus(id string) TransactionStatus {
+	bc.mux.Lock()
+	defer bc.mux.Unlock()
+
+	if location, ok := bc.lookupTransaction(id); ok {
+		tx := bc.Chain[location.BlockIndex-1].Transactions[location.Position]
+		return TransactionStatus{
+			ID:            id,
+			Status:        TxStatusConfirmed,
+			Location:      &location,
+			Confirmations: len(bc.Chain) - location.BlockIndex + 1,
+			Transaction:   &tx,
+		}
+	}
+
+	if tx, ok := bc.mempool.find(id); ok {
+		return TransactionStatus{ID: id, Status: TxStatusPending, Transaction: &tx}
+	}
+
+	return TransactionStatus{ID: id, Status: TxStatusUnknown}
+}
+
+// lookupTransaction finds a confirmed transaction in the index. The caller must hold bc.mux.
+func (bc *Blockchain) lookupTransaction(id string) (TxLocation, bool) {
+	if bc.txIndex == nil {
+		bc.reindex()
+	}
+	location, ok := bc.txIndex[id]
+	return location, ok
+}
+
+// reindex rebuilds the transaction index and the ledger state from the chain. It stops at
+// the first block that does not apply to the ledger and returns its error; the indexes then
+// cover the chain up to that block. The caller must hold bc.mux.
+func (bc *Blockchain) reindex() error {
+	bc.txIndex = make(map[string]TxLocation)
+	bc.heights = make(map[string]int)
+	bc.state = newLedgerState(bc.ledger(), bc.coinbaseMaturity())
+	bc.undo = make(map[string][]SpentOutput)
+	for _, block := range bc.Chain {
+		undo, err := bc.state.connectBlock(block)
+		if err != nil {
+			return err
+		}
+		bc.indexBlock(block)
+		if undo != nil {
+			bc.undo[block.CalculateHash()] = undo
+		}
+	}
+	return nil
+}
+
+// rebuild reindexes a chain that was validated when it became ours, reporting the error
+// should it no longer apply. The caller must hold bc.mux.
+func (bc *Blockchain) rebuild() {
+	if err := bc.reindex(); err != nil {
+		fmt.Printf("Rebuilding the ledger failed: %v\n", err)
+	}
+}
+
+// indexBlock adds a block and its transactions to the indexes. The caller must hold bc.mux.
+func (bc *Blockchain) indexBlock(block Block) {
+	if bc.txIndex == nil || bc.heights == nil {
+		bc.rebuild()
+		return
+	}
+	bc.heights[block.CalculateHash()] = block.Index - 1
+	for i, tx := range block.Transactions {
+		bc.txIndex[tx.Hash()] = TxLocation{BlockIndex: block.Index, Position: i}
+	}
+}
+
+// chainFileVersion is the layout of the blockchain file written by Save.
+// Version 1 files are a bare array of blocks with float64 amounts.
+const chainFileVersion = 2
+
+// chainFile is the on-disk layout of the blockchain
+type chainFile struct {
+	Version int     `json:"version"`
+	Chain   []Block `json:"chain"`
+}
+
 // Save serializes the blockchain and saves it to a file
 func (bc *Blockchain) Save(filename string) error {
-	data, err := json.MarshalIndent(bc.Chain, "", "  ")
+	bc.mux.Lock()
+	defer bc.mux.Unlock()
+	return bc.save(filename)
+}
+
+// save writes the chain to a temporary file and renames it over filename, so that the file
+// always holds a complete chain. The caller must hold bc.mux.
+func (bc *Blockchain) save(filename string) error {
+	data, err := json.MarshalIndent(chainFile{Version: chainFileVersion, Chain: bc.Chain}, "", "  ")
 	if err != nil {
 		return err
 	}
-	return os.WriteFile(filename, data, 0644)
+	if err := os.WriteFile(filename+".tmp", data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(filename+".tmp", filename)
+}
+
+// persist saves the chain to BlockchainFile after a change, reporting a failure. The chain
+// in memory stays valid, the next change tries again. The caller must hold bc.mux.
+func (bc *Blockchain) persist() {
+	if err := bc.save(BlockchainFile); err != nil {
+		fmt.Printf("Failed to save %s: %v\n", BlockchainFile, err)
+	}
 }
 
-// LoadFromFile loads the blockchain from a file
+// LoadFromFile loads the blockchain from a file.
+// Version 1 files are refused with ErrLegacyChain and left untouched.
 func (bc *Blockchain) LoadFromFile(filename string) error {
 	data, err := os.ReadFile(filename)
 	if err != nil {
 		return err
 	}
-	return json.Unmarshal(data, &bc.Chain)
+
+	if legacy := bytes.HasPrefix(bytes.TrimSpace(data), []byte("[")); legacy {
+		return fmt.Errorf("%s: %w", filename, ErrLegacyChain)
+	}
+	var file chainFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return err
+	}
+	if file.Version != chainFileVersion {
+		return fmt.Errorf("unsupported blockchain file version %d", file.Version)
+	}
+	bc.Chain = file.Chain
+
+	bc.txIndex, bc.heights, bc.state = nil, nil, nil // rebuilt on next lookup
+	return nil
 }
 
 // RegisterNode adds a new node to the list of nodes
@@ -282,52 +691,152 @@ func (bc *Blockchain) RegisterNode(address string) {
 	bc.Nodes[address] = true
 }
 
-// ResolveConflicts implements our consensus algorithm.
-// It replaces our chain with the longest one in the network.
-func (bc *Blockchain) ResolveConflicts() bool {
+// UnregisterNode removes a node from the list of nodes
+func (bc *Blockchain) UnregisterNode(address string) {
 	bc.mux.Lock()
+	defer bc.mux.Unlock()
+	delete(bc.Nodes, address)
+}
+
+// GetNodes returns the addresses of the registered nodes that are not banned
+func (bc *Blockchain) GetNodes() []string {
+	bc.mux.Lock()
+	defer bc.mux.Unlock()
 	nodes := []string{}
 	for node := range bc.Nodes {
-		nodes = append(nodes, node)
+		if !bc.peers.IsBanned(node) {
+			nodes = append(nodes, node)
+		}
 	}
-	bc.mux.Unlock()
+	return nodes
+}
 
-	var newChain []Block
-	maxLength := bc.GetLength()
+// isNode reports whether node is registered and not banned
+func (bc *Blockchain) isNode(node string) bool {
+	bc.mux.Lock()
+	defer bc.mux.Unlock()
+	return bc.Nodes[node] && !bc.peers.IsBanned(node)
+}
 
-	for _, node := range nodes {
-		resp, err := http.Get("http://" + node + "/api/full-chain")
-		if err != nil {
-			continue
-		}
+// RegisteredNodes returns a copy of the registered nodes, banned ones included
+func (bc *Blockchain) RegisteredNodes() map[string]bool {
+	bc.mux.Lock()
+	defer bc.mux.Unlock()
+	nodes := make(map[string]bool, len(bc.Nodes))
+	for node, registered := range bc.Nodes {
+		nodes[node] = registered
+	}
+	return nodes
+}
 
-		if resp.StatusCode == http.StatusOK {
-			var result struct {
-				Length int     `json:"length"`
-				Chain  []Block `json:"chain"`
-			}
-			if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-				resp.Body.Close()
-				continue
-			}
-			resp.Body.Close()
+// PeerScores returns the misbehavior scores and bans of the nodes we talk to
+func (bc *Blockchain) PeerScores() *PeerScores {
+	return &bc.peers
+}
+
+// Outcomes of syncing with a peer during ResolveConflicts
+const (
+	OutcomeAdopted     = "adopted"     // we switched to the peer's chain
+	OutcomeShorter     = "shorter"     // the peer had no chain with more work than ours
+	OutcomeTimeout     = "timeout"     // the peer did not answer in time
+	OutcomeInvalid     = "invalid"     // the peer sent an invalid chain or garbage
+	OutcomeUnreachable = "unreachable" // the request failed otherwise
+	OutcomeBanned      = "banned"      // the peer is banned and was not asked
+	OutcomeCanceled    = "canceled"    // we gave up before the peer answered
+)
+
+// PeerOutcome describes how syncing with one peer went
+type PeerOutcome struct {
+	Node       string `json:"node"`
+	Outcome    string `json:"outcome"`
+	RemoteWork uint64 `json:"remote_work,omitempty"`
+	Height     int    `json:"height,omitempty"` // height of the peer's chain when it had blocks we lack
+	Blocks     int    `json:"blocks,omitempty"` // block bodies downloaded
+	Error      string `json:"error,omitempty"`
+}
+
+// ConsensusResult describes the outcome of ResolveConflicts
+type ConsensusResult struct {
+	Replaced   bool          `json:"replaced"`
+	LocalWork  uint64        `json:"local_work"`
+	RemoteWork uint64        `json:"remote_work"`
+	Reorg      *ReorgEvent   `json:"reorg,omitempty"` // set when blocks of our chain were disconnected
+	Peers      []PeerOutcome `json:"peers"`           // one per registered node, ordered by address
+}
 
-			if result.Length > maxLength && bc.ValidChain(result.Chain) {
-				maxLength = result.Length
-				newChain = result.Chain
+// ResolveConflicts implements our consensus algorithm.
+// It syncs headers first with every registered node that is not banned, all at once, and
+// reorganizes our chain onto the valid chain in the network that has the most cumulative
+// work. When two chains have the same work, the one whose tip has the lowest hash wins, so
+// every node makes the same choice. Every request to a peer must be answered within
+// SyncTimeout, and peers still busy when ctx is done are given up on. Nodes sending invalid
+// chains or garbage are penalized, so they are eventually banned.
+func (bc *Blockchain) ResolveConflicts(ctx context.Context) ConsensusResult {
+	nodes := bc.GetNodes()
+	bc.mux.Lock()
+	result := ConsensusResult{LocalWork: ChainWork(bc.Chain), Peers: make([]PeerOutcome, len(nodes))}
+	bc.mux.Unlock()
+
+	syncs := make([]SyncResult, len(nodes))
+	var wg sync.WaitGroup
+	for i, node := range nodes {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sync, err := bc.SyncWithPeer(ctx, node)
+			syncs[i] = sync
+			result.Peers[i] = peerOutcome(ctx, node, sync, err)
+		}()
+	}
+	wg.Wait()
+
+	for _, sync := range syncs {
+		if sync.RemoteWork > result.RemoteWork {
+			result.RemoteWork = sync.RemoteWork
+		}
+		if sync.Adopted {
+			result.Replaced = true
+			if sync.Reorg != nil {
+				result.Reorg = sync.Reorg
 			}
-		} else {
-			resp.Body.Close()
 		}
 	}
+	sort.Slice(result.Peers, func(i, j int) bool { return result.Peers[i].Node < result.Peers[j].Node })
 
-	if newChain != nil {
-		bc.mux.Lock()
-		bc.Chain = newChain
-		bc.mux.Unlock()
-		_ = bc.Save(BlockchainFile)
-		return true
+	return result
+}
+
+// peerOutcome classifies the result of syncing with a peer
+func peerOutcome(ctx context.Context, node string, sync SyncResult, err error) PeerOutcome {
+	outcome := PeerOutcome{Node: node, RemoteWork: sync.RemoteWork, Height: sync.RemoteHeight, Blocks: sync.Blocks}
+	var netErr net.Error
+	switch {
+	case err == nil && sync.Adopted:
+		outcome.Outcome = OutcomeAdopted
+	case err == nil, errors.Is(err, ErrStaleBlock):
+		outcome.Outcome = OutcomeShorter // or beaten by another peer while we downloaded
+		return outcome
+	case errors.Is(err, ErrPeerBanned):
+		outcome.Outcome = OutcomeBanned
+	case ctx.Err() != nil:
+		outcome.Outcome = OutcomeCanceled
+	case errors.As(err, &netErr) && netErr.Timeout():
+		outcome.Outcome = OutcomeTimeout
+	case errors.Is(err, ErrInvalidBlock), errors.Is(err, ErrInvalidHeaders), errors.Is(err, ErrMalformed):
+		outcome.Outcome = OutcomeInvalid
+	default:
+		outcome.Outcome = OutcomeUnreachable
 	}
+	if err != nil {
+		outcome.Error = err.Error()
+	}
+	return outcome
+}
 
-	return false
+// hasMoreWork reports whether a chain beats the current best one under our fork choice rule
+func hasMoreWork(work uint64, tip string, bestWork uint64, bestTip string) bool {
+	if work != bestWork {
+		return work > bestWork
+	}
+	return tip < bestTip
 }
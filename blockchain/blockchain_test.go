@@ -2,11 +2,14 @@ package blockchain
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"reflect"
 	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -18,52 +21,54 @@ func mockTime(t time.Time) func() {
 	return func() { timeNow = originalTimeNow }
 }
 
-// TestCreateBlock verifies that CreateBlock constructs a new block with the correct fields
-// and appends it to the blockchain.
+// TestCreateBlock verifies that CreateBlock mines a new block with the correct fields and
+// a coinbase paying the miner on top of the current tip, and appends it to the blockchain.
 func TestCreateBlock(t *testing.T) {
 	// Clean up before and after test
 	os.Remove(BlockchainFile)
 	defer os.Remove(BlockchainFile)
 
-	// Test cases cover initial block creation and subsequent blocks with varied inputs.
+	genesis := Block{Index: 1, Timestamp: "2025-07-06T12:00:00Z", Proof: 1, PreviousHash: "0", Difficulty: InitialDifficulty, MerkleRoot: EmptyMerkleRoot, ChainWork: BlockWork(InitialDifficulty)}
+
+	coinbase := func(index uint64) []Transaction {
+		return []Transaction{{Sender: "0", Receiver: "miner", Amount: MiningReward, Nonce: index}}
+	}
+
+	// Test cases cover initial block creation and subsequent blocks.
 	tests := []struct {
 		name          string
 		chain         []Block
-		proof         int
-		previousHash  string
 		timestamp     time.Time
 		expectedBlock Block
 		expectedLen   int
 	}{
 		{
-			name:         "First block",
-			chain:        []Block{},
-			proof:        100,
-			previousHash: "0",
-			timestamp:    time.Date(2025, 7, 6, 12, 0, 0, 0, time.UTC),
+			name:      "First block",
+			chain:     []Block{},
+			timestamp: time.Date(2025, 7, 6, 12, 0, 0, 0, time.UTC),
 			expectedBlock: Block{
 				Index:        1,
 				Timestamp:    time.Date(2025, 7, 6, 12, 0, 0, 0, time.UTC).Format(time.RFC3339),
-				Transactions: []Transaction{},
-				Proof:        100,
+				Transactions: coinbase(1),
 				PreviousHash: "0",
+				Difficulty:   InitialDifficulty,
+				MerkleRoot:   MerkleRoot(coinbase(1)),
+				ChainWork:    BlockWork(InitialDifficulty),
 			},
 			expectedLen: 1,
 		},
 		{
-			name: "Second block",
-			chain: []Block{
-				{Index: 1, Timestamp: "2025-07-06T12:00:00Z", Proof: 100, PreviousHash: "0"},
-			},
-			proof:        200,
-			previousHash: "abc123",
-			timestamp:    time.Date(2025, 7, 6, 13, 0, 0, 0, time.UTC),
+			name:      "Second block",
+			chain:     []Block{genesis},
+			timestamp: time.Date(2025, 7, 6, 13, 0, 0, 0, time.UTC),
 			expectedBlock: Block{
 				Index:        2,
 				Timestamp:    time.Date(2025, 7, 6, 13, 0, 0, 0, time.UTC).Format(time.RFC3339),
-				Transactions: []Transaction{},
-				Proof:        200,
-				PreviousHash: "abc123",
+				Transactions: coinbase(2),
+				PreviousHash: genesis.CalculateHash(),
+				Difficulty:   InitialDifficulty,
+				MerkleRoot:   MerkleRoot(coinbase(2)),
+				ChainWork:    2 * BlockWork(InitialDifficulty),
 			},
 			expectedLen: 2,
 		},
@@ -76,11 +81,19 @@ func TestCreateBlock(t *testing.T) {
 
 			bc := &Blockchain{
 				Chain:               tt.chain,
-				CurrentTransactions: []Transaction{},
 			}
-			got := bc.CreateBlock(tt.proof, tt.previousHash)
+			got, err := bc.CreateBlock("miner")
+			if err != nil {
+				t.Fatalf("CreateBlock() error = %v", err)
+			}
+
+			// Verify the proof of work over the header.
+			if valid, hashHex := VerifyProof(got.Header()); !valid {
+				t.Errorf("CreateBlock() produced an invalid proof, header hash %s", hashHex)
+			}
 
 			// Verify block fields.
+			tt.expectedBlock.Proof = got.Proof
 			if !reflect.DeepEqual(got, tt.expectedBlock) {
 				t.Errorf("CreateBlock() = %+v; want %+v", got, tt.expectedBlock)
 			}
@@ -112,13 +125,16 @@ func TestNewBlockChain(t *testing.T) {
 	}{
 		{
 			name:      "Genesis block",
-			timestamp: time.Date(2025, 7, 6, 12, 0, 0, 0, time.UTC),
+			timestamp: time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC), // the clock plays no part
 			expectedBlock: Block{
 				Index:        1,
-				Timestamp:    time.Date(2025, 7, 6, 12, 0, 0, 0, time.UTC).Format(time.RFC3339),
+				Timestamp:    GenesisTimestamp,
 				Transactions: []Transaction{},
 				Proof:        1,
 				PreviousHash: "0",
+				Difficulty:   InitialDifficulty,
+				MerkleRoot:   DefaultGenesis().Hash(),
+				ChainWork:    BlockWork(InitialDifficulty),
 			},
 		},
 	}
@@ -206,7 +222,6 @@ func TestGetLatestBlock(t *testing.T) {
 			// Execute GetLatestBlock.
 			bc := &Blockchain{
 				Chain:               tt.chain,
-				CurrentTransactions: []Transaction{},
 			}
 			got := bc.GetLatestBlock()
 
@@ -239,36 +254,43 @@ func TestGetLatestBlock(t *testing.T) {
 	}
 }
 
-// TestVerifyProof checks that VerifyProof correctly validates proof combinations
-// based on the SHA-256 hash's leading zeros.
+// TestVerifyProof checks that VerifyProof correctly validates block headers
+// based on the leading zero bits of the SHA-256 hash of the serialized header.
 func TestVerifyProof(t *testing.T) {
-	// Test cases cover valid and invalid proofs with precomputed hashes.
+	header := BlockHeader{
+		Index:        2,
+		Timestamp:    "2025-07-06T13:00:00Z",
+		PreviousHash: "61ef5423cb43aab04245e02fa53e711cca4d0c9257b14bb5153f13a7ee1dbb18",
+		MerkleRoot:   EmptyMerkleRoot,
+		Difficulty:   InitialDifficulty,
+	}
+
+	// Test cases cover valid and invalid nonces with precomputed hashes.
 	tests := []struct {
 		name        string
-		proof       int
-		lastProof   int
+		nonce       int
 		expected    bool
 		expectedHex string
 	}{
 		{
 			name:        "Valid proof",
-			proof:       93711,
-			lastProof:   1,
+			nonce:       73529,
 			expected:    true,
-			expectedHex: "0000e6b89b0f08f0f7faf26f0ce60185e5043f11ed84c8881033ea0580011111",
+			expectedHex: "0000d8a68e7730b3007485337234a5fa0bbcf8274b3221728a6142b718c7b430",
 		},
 		{
 			name:        "Invalid proof",
-			proof:       101,
-			lastProof:   200,
+			nonce:       101,
 			expected:    false,
-			expectedHex: "918178f1c1d36996e59ca9fbe7e99e40dc8aa34c80f267766f8bf23cdb0a83c3",
+			expectedHex: "0042e3ddd31cc85b473ca7b8afe041797c5d9d7fd462b44d421f7e1aaa634a69",
 		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			gotValid, gotHex := VerifyProof(tt.proof, tt.lastProof)
+			h := header
+			h.Nonce = tt.nonce
+			gotValid, gotHex := VerifyProof(h)
 			if gotValid != tt.expected {
 				t.Errorf("VerifyProof valid = %v; want %v", gotValid, tt.expected)
 			}
@@ -279,20 +301,26 @@ func TestVerifyProof(t *testing.T) {
 	}
 }
 
-// TestProofOfWork validates that ProofOfWork finds a proof producing a hash with four leading zeros.
+// TestProofOfWork validates that ProofOfWork finds a nonce producing a header hash with enough leading zero bits.
 func TestProofOfWork(t *testing.T) {
-	// Test cases cover different lastProof values with precomputed proof and hash.
+	// Test cases cover a header with precomputed nonce and hash.
 	tests := []struct {
 		name       string
-		lastProof  int
+		header     BlockHeader
 		expected   int
 		hashOutput string
 	}{
 		{
-			name:       "Basic proof",
-			lastProof:  1,
-			expected:   93711,
-			hashOutput: "0000e6b89b0f08f0f7faf26f0ce60185e5043f11ed84c8881033ea0580011111",
+			name: "Basic proof",
+			header: BlockHeader{
+				Index:        2,
+				Timestamp:    "2025-07-06T13:00:00Z",
+				PreviousHash: "61ef5423cb43aab04245e02fa53e711cca4d0c9257b14bb5153f13a7ee1dbb18",
+				MerkleRoot:   EmptyMerkleRoot,
+				Difficulty:   InitialDifficulty,
+			},
+			expected:   73529,
+			hashOutput: "0000d8a68e7730b3007485337234a5fa0bbcf8274b3221728a6142b718c7b430",
 		},
 	}
 
@@ -314,7 +342,7 @@ func TestProofOfWork(t *testing.T) {
 			})
 
 			// Execute ProofOfWork.
-			got := ProofOfWork(tt.lastProof)
+			got := ProofOfWork(tt.header)
 
 			// Close writer to flush output.
 			if err := w.Close(); err != nil {
@@ -341,58 +369,175 @@ func TestProofOfWork(t *testing.T) {
 	}
 }
 
+// TestSearchProofWorkers finds the same, lowest, nonce with any number of workers.
+func TestSearchProofWorkers(t *testing.T) {
+	defer func(workers int) { MiningWorkers = workers }(MiningWorkers)
+
+	header := BlockHeader{
+		Index:        2,
+		Timestamp:    "2025-07-06T13:00:00Z",
+		PreviousHash: "61ef5423cb43aab04245e02fa53e711cca4d0c9257b14bb5153f13a7ee1dbb18",
+		MerkleRoot:   EmptyMerkleRoot,
+		Difficulty:   InitialDifficulty,
+	}
+	for _, workers := range []int{0, 1, 2, 4, 7} {
+		t.Run(fmt.Sprintf("%d workers", workers), func(t *testing.T) {
+			MiningWorkers = workers
+			var hashes atomic.Uint64
+			nonce, err := SearchProof(context.Background(), header, &hashes)
+			if err != nil || nonce != 73529 {
+				t.Errorf("SearchProof() = %d, %v; want 73529", nonce, err)
+			}
+			if hashes.Load() < 73530 {
+				t.Errorf("Hashes = %d; want at least the 73530 nonces up to the proof", hashes.Load())
+			}
+		})
+	}
+}
+
+// TestSearchProofCanceled stops every worker when the context is done.
+func TestSearchProofCanceled(t *testing.T) {
+	defer func(workers int) { MiningWorkers = workers }(MiningWorkers)
+	MiningWorkers = 4
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := SearchProof(ctx, BlockHeader{Index: 2, Difficulty: 255}, nil); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("SearchProof() error = %v; want %v", err, context.DeadlineExceeded)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("SearchProof() took %v after the deadline", elapsed)
+	}
+}
+
+// TestCreateBlockContext gives up when the context is done, and wakes up the search when
+// another block changes the tip.
+func TestCreateBlockContext(t *testing.T) {
+	os.Remove(BlockchainFile)
+	defer os.Remove(BlockchainFile)
+
+	bc := NewBlockChain()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := bc.CreateBlockContext(ctx, "miner"); !errors.Is(err, context.Canceled) {
+		t.Errorf("CreateBlockContext() error = %v; want %v", err, context.Canceled)
+	}
+	if bc.GetLength() != 1 {
+		t.Errorf("GetLength() = %d; want no block mined", bc.GetLength())
+	}
+
+	tipChanged := bc.tipWatch()
+	block, err := bc.CreateBlockContext(context.Background(), "miner")
+	if err != nil {
+		t.Fatalf("CreateBlockContext() error = %v", err)
+	}
+	select {
+	case <-tipChanged:
+	default:
+		t.Errorf("tipWatch() not closed after block %d", block.Index)
+	}
+	if latest := bc.GetLatestBlock(); latest.CalculateHash() != block.CalculateHash() {
+		t.Errorf("GetLatestBlock() is not the block mined")
+	}
+}
+
+// BenchmarkSearchProof reports the hashes per second for different numbers of workers.
+func BenchmarkSearchProof(b *testing.B) {
+	defer func(workers int) { MiningWorkers = workers }(MiningWorkers)
+
+	for _, workers := range []int{1, 2, 4, 8, 16} {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			MiningWorkers = workers
+			var hashes atomic.Uint64
+			header := BlockHeader{Timestamp: "2025-07-06T13:00:00Z", MerkleRoot: EmptyMerkleRoot, Difficulty: InitialDifficulty}
+			for i := 0; i < b.N; i++ {
+				header.Index = i
+				if _, err := SearchProof(context.Background(), header, &hashes); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.ReportMetric(float64(hashes.Load())/b.Elapsed().Seconds(), "hashes/s")
+		})
+	}
+}
+
 // TestIsChainValid confirms that IsChainValid correctly identifies valid and invalid blockchains.
 func TestIsChainValid(t *testing.T) {
 	// Test cases cover valid chains, invalid indices, hashes, proofs, and timestamps.
 	tests := []struct {
 		name     string
 		chain    []Block
-		expected bool
+		expected string // broken rule, empty for a valid chain
 	}{
 		{
 			name: "Valid chain",
 			chain: []Block{
-				{Index: 1, Timestamp: "2025-07-06T12:00:00Z", Proof: 1, PreviousHash: "0"},
-				{Index: 2, Timestamp: "2025-07-06T13:00:00Z", Proof: 93711, PreviousHash: "5828c510a157edfbb185531ea35578281374e2a407ac87dac1665b0d647423c0"},
+				{Index: 1, Timestamp: "2025-07-06T12:00:00Z", Proof: 1, PreviousHash: "0", Difficulty: InitialDifficulty, MerkleRoot: EmptyMerkleRoot, ChainWork: BlockWork(InitialDifficulty)},
+				{Index: 2, Timestamp: "2025-07-06T13:00:00Z", Proof: 73529, PreviousHash: "61ef5423cb43aab04245e02fa53e711cca4d0c9257b14bb5153f13a7ee1dbb18", Difficulty: InitialDifficulty, MerkleRoot: EmptyMerkleRoot, ChainWork: 2 * BlockWork(InitialDifficulty)},
 			},
-			expected: true,
+			expected: "",
 		},
 		{
 			name: "Invalid index",
 			chain: []Block{
-				{Index: 1, Timestamp: "2025-07-06T12:00:00Z", Proof: 1, PreviousHash: "0"},
-				{Index: 3, Timestamp: "2025-07-06T13:00:00Z", Proof: 52838, PreviousHash: "3a6cd2d1894f38cd050281be09ef785723fe45ddc291ed9f4b78d13f12571eb0"},
+				{Index: 1, Timestamp: "2025-07-06T12:00:00Z", Proof: 1, PreviousHash: "0", Difficulty: InitialDifficulty, MerkleRoot: EmptyMerkleRoot, ChainWork: BlockWork(InitialDifficulty)},
+				{Index: 3, Timestamp: "2025-07-06T13:00:00Z", Proof: 73529, PreviousHash: "61ef5423cb43aab04245e02fa53e711cca4d0c9257b14bb5153f13a7ee1dbb18", Difficulty: InitialDifficulty, MerkleRoot: EmptyMerkleRoot, ChainWork: 2 * BlockWork(InitialDifficulty)},
 			},
-			expected: false,
+			expected: RuleIndex,
 		},
 		{
 			name: "Invalid previous hash",
 			chain: []Block{
-				{Index: 1, Timestamp: "2025-07-06T12:00:00Z", Proof: 1, PreviousHash: "0"},
-				{Index: 2, Timestamp: "2025-07-06T13:00:00Z", Proof: 52838, PreviousHash: "invalid"},
+				{Index: 1, Timestamp: "2025-07-06T12:00:00Z", Proof: 1, PreviousHash: "0", Difficulty: InitialDifficulty, MerkleRoot: EmptyMerkleRoot, ChainWork: BlockWork(InitialDifficulty)},
+				{Index: 2, Timestamp: "2025-07-06T13:00:00Z", Proof: 73529, PreviousHash: "invalid", Difficulty: InitialDifficulty, MerkleRoot: EmptyMerkleRoot, ChainWork: 2 * BlockWork(InitialDifficulty)},
 			},
-			expected: false,
+			expected: RulePreviousHash,
 		},
 		{
 			name: "Invalid proof",
 			chain: []Block{
-				{Index: 1, Timestamp: "2025-07-06T12:00:00Z", Proof: 1, PreviousHash: "0"},
-				{Index: 2, Timestamp: "2025-07-06T13:00:00Z", Proof: 101, PreviousHash: "3a6cd2d1894f38cd050281be09ef785723fe45ddc291ed9f4b78d13f12571eb0"},
+				{Index: 1, Timestamp: "2025-07-06T12:00:00Z", Proof: 1, PreviousHash: "0", Difficulty: InitialDifficulty, MerkleRoot: EmptyMerkleRoot, ChainWork: BlockWork(InitialDifficulty)},
+				{Index: 2, Timestamp: "2025-07-06T13:00:00Z", Proof: 101, PreviousHash: "61ef5423cb43aab04245e02fa53e711cca4d0c9257b14bb5153f13a7ee1dbb18", Difficulty: InitialDifficulty, MerkleRoot: EmptyMerkleRoot, ChainWork: 2 * BlockWork(InitialDifficulty)},
 			},
-			expected: false,
+			expected: RuleProof,
 		},
 		{
 			name: "Invalid timestamp",
 			chain: []Block{
-				{Index: 1, Timestamp: "2025-07-06T13:00:00Z", Proof: 1, PreviousHash: "0"},
-				{Index: 2, Timestamp: "2025-07-06T12:00:00Z", Proof: 52838, PreviousHash: "3a6cd2d1894f38cd050281be09ef785723fe45ddc291ed9f4b78d13f12571eb0"},
+				{Index: 1, Timestamp: "2025-07-06T13:00:00Z", Proof: 1, PreviousHash: "0", Difficulty: InitialDifficulty, MerkleRoot: EmptyMerkleRoot, ChainWork: BlockWork(InitialDifficulty)},
+				{Index: 2, Timestamp: "2025-07-06T12:00:00Z", Proof: 23717, PreviousHash: "76cb0a2df7ae937ec1bde5cce07fc7dad472483098b520f851dcffc30ae0a2b2", Difficulty: InitialDifficulty, MerkleRoot: EmptyMerkleRoot, ChainWork: 2 * BlockWork(InitialDifficulty)},
+			},
+			expected: RuleTimestamp,
+		},
+		{
+			name: "Invalid difficulty",
+			chain: []Block{
+				{Index: 1, Timestamp: "2025-07-06T12:00:00Z", Proof: 1, PreviousHash: "0", Difficulty: InitialDifficulty, MerkleRoot: EmptyMerkleRoot, ChainWork: BlockWork(InitialDifficulty)},
+				{Index: 2, Timestamp: "2025-07-06T13:00:00Z", Proof: 73529, PreviousHash: "61ef5423cb43aab04245e02fa53e711cca4d0c9257b14bb5153f13a7ee1dbb18", Difficulty: InitialDifficulty - 1, MerkleRoot: EmptyMerkleRoot, ChainWork: BlockWork(InitialDifficulty) + BlockWork(InitialDifficulty-1)},
+			},
+			expected: RuleDifficulty,
+		},
+		{
+			name: "Tampered transactions",
+			chain: []Block{
+				{Index: 1, Timestamp: "2025-07-06T12:00:00Z", Proof: 1, PreviousHash: "0", Difficulty: InitialDifficulty, MerkleRoot: EmptyMerkleRoot, ChainWork: BlockWork(InitialDifficulty)},
+				{Index: 2, Timestamp: "2025-07-06T13:00:00Z", Transactions: []Transaction{{Sender: "0", Receiver: "thief", Amount: 1000}}, Proof: 73529, PreviousHash: "61ef5423cb43aab04245e02fa53e711cca4d0c9257b14bb5153f13a7ee1dbb18", Difficulty: InitialDifficulty, MerkleRoot: EmptyMerkleRoot, ChainWork: 2 * BlockWork(InitialDifficulty)},
+			},
+			expected: RuleMerkleRoot,
+		},
+		{
+			name: "Invalid chain work",
+			chain: []Block{
+				{Index: 1, Timestamp: "2025-07-06T12:00:00Z", Proof: 1, PreviousHash: "0", Difficulty: InitialDifficulty, MerkleRoot: EmptyMerkleRoot, ChainWork: BlockWork(InitialDifficulty)},
+				{Index: 2, Timestamp: "2025-07-06T13:00:00Z", Proof: 73529, PreviousHash: "61ef5423cb43aab04245e02fa53e711cca4d0c9257b14bb5153f13a7ee1dbb18", Difficulty: InitialDifficulty, MerkleRoot: EmptyMerkleRoot, ChainWork: 1 << 40},
 			},
-			expected: false,
+			expected: RuleChainWork,
 		},
 		{
 			name:     "Single block",
-			chain:    []Block{{Index: 1, Timestamp: "2025-07-06T12:00:00Z", Proof: 1, PreviousHash: "0"}},
-			expected: true,
+			chain:    []Block{{Index: 1, Timestamp: "2025-07-06T12:00:00Z", Proof: 1, PreviousHash: "0", Difficulty: InitialDifficulty, MerkleRoot: EmptyMerkleRoot, ChainWork: BlockWork(InitialDifficulty)}},
+			expected: "",
 		},
 	}
 
@@ -400,11 +545,14 @@ func TestIsChainValid(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			bc := &Blockchain{
 				Chain:               tt.chain,
-				CurrentTransactions: []Transaction{},
 			}
-			got := bc.IsChainValid()
-			if got != tt.expected {
-				t.Errorf("IsChainValid() = %v; want %v", got, tt.expected)
+			err := bc.IsChainValid()
+			var validationErr *ValidationError
+			switch {
+			case tt.expected == "" && err != nil:
+				t.Errorf("IsChainValid() = %v; want nil", err)
+			case tt.expected != "" && (!errors.As(err, &validationErr) || validationErr.Rule != tt.expected):
+				t.Errorf("IsChainValid() = %v; want a %q validation error", err, tt.expected)
 			}
 		})
 		fmt.Println("-----------------------------------")
@@ -461,7 +609,6 @@ func TestBlockchainPrint(t *testing.T) {
 			// Execute Print method.
 			bc := &Blockchain{
 				Chain:               tt.chain,
-				CurrentTransactions: []Transaction{},
 			}
 			bc.Print()
 
@@ -483,3 +630,33 @@ func TestBlockchainPrint(t *testing.T) {
 		})
 	}
 }
+
+// TestBlocksWhileMining reads the chain while blocks are added; run with -race.
+func TestBlocksWhileMining(t *testing.T) {
+	os.Remove(BlockchainFile)
+	defer os.Remove(BlockchainFile)
+
+	bc := NewBlockChain()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 3; i++ {
+			bc.CreateBlock("miner")
+		}
+	}()
+
+	for running := true; running; {
+		select {
+		case <-done:
+			running = false
+		default:
+		}
+		blocks := bc.Blocks()
+		if blocks[len(blocks)-1].Index != len(blocks) {
+			t.Fatalf("Blocks() returned a torn chain of %d blocks", len(blocks))
+		}
+	}
+	if got := len(bc.Blocks()); got != 4 {
+		t.Errorf("len(Blocks()) = %d; want 4", got)
+	}
+}
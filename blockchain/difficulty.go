@@ -0,0 +1,145 @@
+package blockchain
+
+import (
+	"fmt"
+	"math/big"
+	"math/bits"
+	"time"
+)
+
+// Difficulty is expressed as the number of leading zero bits a proof hash must have.
+const (
+	InitialDifficulty = 16 // equivalent to the original "0000" hex prefix
+	MinDifficulty     = 1
+	MaxDifficulty     = 48
+)
+
+// Retarget is the difficulty adjustment schedule, part of the genesis settings
+type Retarget struct {
+	TargetBlockTime time.Duration `json:"target_block_time"` // average time aimed for between two blocks
+	Interval        int           `json:"interval"`          // blocks after which the difficulty is adjusted
+}
+
+// DefaultRetarget returns the schedule used when nothing is configured: a block every 10
+// seconds, adjusted every 10 blocks
+func DefaultRetarget() Retarget {
+	return Retarget{TargetBlockTime: 10 * time.Second, Interval: 10}
+}
+
+// Validate checks that the schedule is usable
+func (r Retarget) Validate() error {
+	if r.TargetBlockTime <= 0 {
+		return fmt.Errorf("target block time must be positive, got %s", r.TargetBlockTime)
+	}
+	if r.Interval <= 0 {
+		return fmt.Errorf("retarget interval must be positive, got %d", r.Interval)
+	}
+	return nil
+}
+
+// NextDifficulty returns the difficulty required for the block that follows the given chain.
+// Every Interval blocks the time spent on the last window is compared with the
+// expected time: if blocks came more than twice as fast the difficulty goes up by one bit,
+// if they came more than twice as slow it goes down by one bit.
+// It fails when the timestamps of the window cannot be parsed.
+func (r Retarget) NextDifficulty(chain []Block) (int, error) {
+	if len(chain) == 0 {
+		return InitialDifficulty, nil
+	}
+
+	last := chain[len(chain)-1]
+	if r.Interval <= 0 || len(chain)%r.Interval != 0 {
+		return last.Difficulty, nil
+	}
+
+	firstIndex := len(chain) - 1 - r.Interval
+	if firstIndex < 0 {
+		firstIndex = 0
+	}
+	intervals := len(chain) - 1 - firstIndex
+	if intervals == 0 {
+		return last.Difficulty, nil
+	}
+
+	first := chain[firstIndex]
+	firstTime, err := parseTimestamp(first.Timestamp)
+	if err != nil {
+		return 0, fmt.Errorf("block %d: %w", first.Index, err)
+	}
+	lastTime, err := parseTimestamp(last.Timestamp)
+	if err != nil {
+		return 0, fmt.Errorf("block %d: %w", last.Index, err)
+	}
+
+	actual := lastTime.Sub(firstTime)
+	expected := r.TargetBlockTime * time.Duration(intervals)
+
+	difficulty := last.Difficulty
+	switch {
+	case actual < expected/2:
+		difficulty++
+	case actual > expected*2:
+		difficulty--
+	}
+
+	if difficulty < MinDifficulty {
+		difficulty = MinDifficulty
+	}
+	if difficulty > MaxDifficulty {
+		difficulty = MaxDifficulty
+	}
+	return difficulty, nil
+}
+
+// NextDifficulty returns the difficulty required for the next block of this blockchain.
+func (bc *Blockchain) NextDifficulty() (int, error) {
+	bc.mux.Lock()
+	defer bc.mux.Unlock()
+	return bc.retarget().NextDifficulty(bc.Chain)
+}
+
+// retarget returns the difficulty schedule of the chain, DefaultRetarget unless configured otherwise
+func (bc *Blockchain) retarget() Retarget {
+	if bc.Retarget == (Retarget{}) {
+		return DefaultRetarget()
+	}
+	return bc.Retarget
+}
+
+// BlockWork returns the expected number of hashes needed to mine a block at the given difficulty.
+func BlockWork(difficulty int) uint64 {
+	if difficulty < 0 {
+		return 0
+	}
+	return uint64(1) << uint(difficulty)
+}
+
+// ChainWork returns the cumulative work of a chain, which is the work stored on its tip.
+func ChainWork(chain []Block) uint64 {
+	if len(chain) == 0 {
+		return 0
+	}
+	return chain[len(chain)-1].ChainWork
+}
+
+// Target returns the largest header hash, as 64 hex digits, that meets the difficulty.
+// External miners compare their hashes against it.
+func Target(difficulty int) string {
+	target := new(big.Int).Lsh(big.NewInt(1), uint(256-min(max(difficulty, 0), 256)))
+	target.Sub(target, big.NewInt(1))
+	return fmt.Sprintf("%064x", target)
+}
+
+// meetsDifficulty reports whether the hash has at least difficulty leading zero bits.
+func meetsDifficulty(hash [32]byte, difficulty int) bool {
+	zeros := 0
+	for _, b := range hash {
+		if b == 0 {
+			zeros += 8
+			continue
+		}
+		zeros += bits.LeadingZeros8(b)
+		break
+	}
+	return zeros >= difficulty
+}
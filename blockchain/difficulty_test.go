@@ -0,0 +1,115 @@
+package blockchain
+
+import (
+	"errors"
+	"os"
+	"testing"
+	"time"
+)
+
+// spacedChain builds a chain of n blocks at the given difficulty, spaced by the given duration.
+func spacedChain(n, difficulty int, spacing time.Duration) []Block {
+	start := time.Date(2025, 7, 6, 12, 0, 0, 0, time.UTC)
+	chain := make([]Block, n)
+	for i := range chain {
+		chain[i] = Block{
+			Index:      i + 1,
+			Timestamp:  start.Add(time.Duration(i) * spacing).Format(time.RFC3339),
+			Difficulty: difficulty,
+		}
+	}
+	return chain
+}
+
+// TestNextDifficulty checks the retarget rule against chains mined too fast, too slow and on time.
+func TestNextDifficulty(t *testing.T) {
+	retarget := Retarget{TargetBlockTime: 10 * time.Second, Interval: 5}
+
+	tests := []struct {
+		name     string
+		chain    []Block
+		expected int
+	}{
+		{name: "Empty chain", chain: nil, expected: InitialDifficulty},
+		{name: "Between retargets", chain: spacedChain(3, 20, time.Second), expected: 20},
+		{name: "Too fast", chain: spacedChain(10, 20, time.Second), expected: 21},
+		{name: "Too slow", chain: spacedChain(10, 20, time.Minute), expected: 19},
+		{name: "On target", chain: spacedChain(10, 20, 10*time.Second), expected: 20},
+		{name: "Clamped at minimum", chain: spacedChain(10, MinDifficulty, time.Hour), expected: MinDifficulty},
+		{name: "Clamped at maximum", chain: spacedChain(10, MaxDifficulty, 0), expected: MaxDifficulty},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, err := retarget.NextDifficulty(tt.chain); err != nil || got != tt.expected {
+				t.Errorf("NextDifficulty() = %d, %v; want %d", got, err, tt.expected)
+			}
+		})
+	}
+
+	// The first block of the window has a timestamp that cannot be compared
+	chain := spacedChain(10, 20, time.Second)
+	chain[4].Timestamp = "yesterday"
+	if _, err := retarget.NextDifficulty(chain); !errors.Is(err, ErrInvalidTimestamp) {
+		t.Errorf("NextDifficulty() error = %v; want %v", err, ErrInvalidTimestamp)
+	}
+}
+
+// TestMeetsDifficulty checks the leading zero bit count on a few hand-made hashes.
+func TestMeetsDifficulty(t *testing.T) {
+	var hash [32]byte
+	hash[2] = 0x10 // 16 zero bits followed by 0001
+
+	if !meetsDifficulty(hash, 19) {
+		t.Error("meetsDifficulty(19) = false; want true")
+	}
+	if meetsDifficulty(hash, 20) {
+		t.Error("meetsDifficulty(20) = true; want false")
+	}
+}
+
+func TestTarget(t *testing.T) {
+	tests := []struct {
+		difficulty int
+		want       string
+	}{
+		{0, "ffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff"},
+		{16, "0000ffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff"},
+		{19, "00001fffffffffffffffffffffffffffffffffffffffffffffffffffffffffff"},
+		{256, "0000000000000000000000000000000000000000000000000000000000000000"},
+	}
+
+	for _, tt := range tests {
+		if got := Target(tt.difficulty); got != tt.want {
+			t.Errorf("Target(%d) = %s; want %s", tt.difficulty, got, tt.want)
+		}
+	}
+}
+
+// TestRetargetFromGenesis mines with the schedule of the genesis settings; a node using
+// another schedule refuses the chain.
+func TestRetargetFromGenesis(t *testing.T) {
+	os.Remove(BlockchainFile)
+	defer os.Remove(BlockchainFile)
+	genesisTime, _ := time.Parse(time.RFC3339, GenesisTimestamp)
+	defer mockTime(genesisTime.Add(time.Minute))() // a minute after the genesis block
+
+	bc := NewBlockChainWithGenesis(Genesis{Retarget: Retarget{TargetBlockTime: time.Hour, Interval: 2}})
+	block, err := bc.CreateBlock("miner")
+	if err != nil {
+		t.Fatalf("CreateBlock() error = %v", err)
+	}
+	if got, _ := bc.NextDifficulty(); got != block.Difficulty+1 {
+		t.Errorf("NextDifficulty() = %d; want %d after blocks far faster than an hour", got, block.Difficulty+1)
+	}
+	bc.CreateBlock("miner")
+	if err := bc.VerifyChain(); err != nil {
+		t.Errorf("VerifyChain() error = %v", err)
+	}
+
+	err = (&Blockchain{}).ValidChain(bc.Chain)
+	var validationErr *ValidationError
+	if !errors.As(err, &validationErr) || validationErr.Rule != RuleDifficulty || validationErr.BlockIndex != 3 {
+		t.Errorf("ValidChain() with the default schedule error = %v; want block 3 to break the difficulty rule", err)
+	}
+}
@@ -0,0 +1,125 @@
+package blockchain
+
+import "fmt"
+
+// Default reward schedule: 50 MaskedCoins halving every 210,000 blocks down to 0.01, with at
+// most 21 million MaskedCoins ever issued
+const (
+	DefaultHalvingInterval = 210_000
+	DefaultMinimumReward   = Coin / 100
+	DefaultMaxSupply       = 21_000_000 * Coin
+)
+
+// Emission is the schedule of block rewards, part of the genesis settings. The reward starts
+// at InitialReward and halves every HalvingInterval blocks, but never below MinimumReward.
+// Rewards stop once MaxSupply has been issued; the block reaching it gets what is left.
+type Emission struct {
+	InitialReward   Amount `json:"initial_reward"`
+	HalvingInterval int    `json:"halving_interval"` // blocks between two halvings
+	MinimumReward   Amount `json:"minimum_reward"`
+	MaxSupply       Amount `json:"max_supply"`
+}
+
+// DefaultEmission returns the reward schedule used when nothing is configured
+func DefaultEmission() Emission {
+	return Emission{
+		InitialReward:   MiningReward,
+		HalvingInterval: DefaultHalvingInterval,
+		MinimumReward:   DefaultMinimumReward,
+		MaxSupply:       DefaultMaxSupply,
+	}
+}
+
+// Validate checks that the schedule is usable
+func (e Emission) Validate() error {
+	switch {
+	case e.InitialReward < 0 || e.InitialReward > maxAmount:
+		return fmt.Errorf("initial reward %s out of range", e.InitialReward)
+	case e.HalvingInterval <= 0:
+		return fmt.Errorf("halving interval must be positive, got %d", e.HalvingInterval)
+	case e.MinimumReward < 0 || e.MinimumReward > e.InitialReward:
+		return fmt.Errorf("minimum reward %s must be between 0 and the initial reward", e.MinimumReward)
+	case e.MaxSupply <= 0 || e.MaxSupply > maxAmount:
+		return fmt.Errorf("max supply %s out of range", e.MaxSupply)
+	}
+	return nil
+}
+
+// baseReward returns the reward of the block with the given index, ignoring the supply cap:
+// the initial reward halved once for every HalvingInterval blocks after genesis, but not
+// below MinimumReward
+func (e Emission) baseReward(index int) Amount {
+	halvings := max(index-1, 0) / e.HalvingInterval
+	reward := Amount(0)
+	if halvings < 63 {
+		reward = e.InitialReward >> halvings
+	}
+	return max(reward, e.MinimumReward)
+}
+
+// Reward returns the most the coinbase of the block with the given index may create, before
+// fees, when issued coins have been created by the blocks before it
+func (e Emission) Reward(index int, issued Amount) Amount {
+	return max(min(e.baseReward(index), e.MaxSupply-issued), 0)
+}
+
+// NextHalving returns the index of the first block after the given one whose reward is
+// halved, or 0 when the reward has already reached MinimumReward
+func (e Emission) NextHalving(index int) int {
+	if e.baseReward(index) <= e.MinimumReward {
+		return 0
+	}
+	return (max(index-1, 0)/e.HalvingInterval+1)*e.HalvingInterval + 1
+}
+
+// issued returns the coins a block creates: what its coinbase pays beyond the fees of the
+// block. The genesis block creates none.
+func issued(block Block) Amount {
+	created := Amount(0)
+	for _, tx := range block.Transactions {
+		if !tx.IsCoinbase() {
+			created -= tx.Fee
+			continue
+		}
+		for _, output := range tx.outputs() {
+			created += output.Amount
+		}
+	}
+	return created
+}
+
+// Supply describes the coins issued so far and the reward schedule
+type Supply struct {
+	Height            int    `json:"height"`
+	Circulating       Amount `json:"circulating_supply"` // coins created by the coinbases of the chain
+	MaxSupply         Amount `json:"max_supply"`
+	CurrentReward     Amount `json:"current_reward"`                // reward of the next block, before fees
+	NextHalvingHeight int    `json:"next_halving_height,omitempty"` // first block with a halved reward; none once at the minimum
+	HalvingInterval   int    `json:"halving_interval"`
+}
+
+// GetSupply reports the circulating supply, the reward of the next block and when it halves
+func (bc *Blockchain) GetSupply() Supply {
+	bc.mux.Lock()
+	defer bc.mux.Unlock()
+
+	emission := bc.emission()
+	next := len(bc.Chain) + 1
+	circulating := bc.ledgerState().supply
+	return Supply{
+		Height:            len(bc.Chain),
+		Circulating:       circulating,
+		MaxSupply:         emission.MaxSupply,
+		CurrentReward:     emission.Reward(next, circulating),
+		NextHalvingHeight: emission.NextHalving(next),
+		HalvingInterval:   emission.HalvingInterval,
+	}
+}
+
+// emission returns the reward schedule of the chain, DefaultEmission unless configured otherwise
+func (bc *Blockchain) emission() Emission {
+	if bc.Emission == (Emission{}) {
+		return DefaultEmission()
+	}
+	return bc.Emission
+}
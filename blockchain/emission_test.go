@@ -0,0 +1,141 @@
+package blockchain
+
+import (
+	"errors"
+	"os"
+	"testing"
+)
+
+// testEmission halves 8 coins every 2 blocks down to 1 coin, with at most 20 coins issued
+var testEmission = Emission{InitialReward: 8 * Coin, HalvingInterval: 2, MinimumReward: Coin, MaxSupply: 20 * Coin}
+
+func TestEmissionReward(t *testing.T) {
+	tests := []struct {
+		name   string
+		index  int
+		issued Amount
+		want   Amount
+	}{
+		{"First era", 2, 0, 8 * Coin},
+		{"First halving", 3, 8 * Coin, 4 * Coin},
+		{"Second halving", 5, 16 * Coin, 2 * Coin},
+		{"Minimum reward", 9, 0, Coin},
+		{"Far future", 1000, 0, Coin},
+		{"Last coins before the cap", 6, 19 * Coin, Coin},
+		{"Capped", 7, 20 * Coin, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := testEmission.Reward(tt.index, tt.issued); got != tt.want {
+				t.Errorf("Reward(%d, %s) = %s; want %s", tt.index, tt.issued, got, tt.want)
+			}
+		})
+	}
+
+	if got := DefaultEmission().Reward(DefaultHalvingInterval+1, 0); got != MiningReward/2 {
+		t.Errorf("Default reward after the first halving = %s; want %s", got, MiningReward/2)
+	}
+}
+
+func TestEmissionNextHalving(t *testing.T) {
+	for index, want := range map[int]int{1: 3, 2: 3, 3: 5, 4: 5, 5: 7, 6: 7, 7: 0, 100: 0} {
+		if got := testEmission.NextHalving(index); got != want {
+			t.Errorf("NextHalving(%d) = %d; want %d", index, got, want)
+		}
+	}
+}
+
+func TestEmissionValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(*Emission)
+		valid  bool
+	}{
+		{"Default", func(e *Emission) {}, true},
+		{"No halving interval", func(e *Emission) { e.HalvingInterval = 0 }, false},
+		{"Minimum above the initial reward", func(e *Emission) { e.MinimumReward = e.InitialReward + 1 }, false},
+		{"No supply", func(e *Emission) { e.MaxSupply = 0 }, false},
+		{"Negative reward", func(e *Emission) { e.InitialReward, e.MinimumReward = -1, -1 }, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			emission := DefaultEmission()
+			tt.modify(&emission)
+			if err := emission.Validate(); (err == nil) != tt.valid {
+				t.Errorf("Validate() error = %v; want valid %v", err, tt.valid)
+			}
+		})
+	}
+}
+
+// TestEmissionSchedule mines past every halving and the supply cap, and refuses coinbases
+// paying more than the schedule allows.
+func TestEmissionSchedule(t *testing.T) {
+	os.Remove(BlockchainFile)
+	defer os.Remove(BlockchainFile)
+
+	bc := NewBlockChainWithGenesis(Genesis{ChainID: DefaultChainID, Ledger: AccountModel, Emission: testEmission})
+	if supply := bc.GetSupply(); supply.CurrentReward != 8*Coin || supply.NextHalvingHeight != 3 || supply.Circulating != 0 {
+		t.Errorf("GetSupply() = %+v; want a reward of 8 halving at block 3", supply)
+	}
+
+	// Paying the previous era's reward after a halving is refused
+	if _, err := bc.CreateBlock("miner"); err != nil {
+		t.Fatalf("CreateBlock() error = %v", err)
+	}
+	block := bc.NewBlockTemplate("miner")
+	block.Transactions[0].Amount = 8 * Coin
+	block.MerkleRoot = MerkleRoot(block.Transactions)
+	block.Proof = ProofOfWork(block.Header())
+	if err := bc.AddBlock(block); !errors.Is(err, ErrInvalidCoinbase) {
+		t.Errorf("AddBlock() with the unhalved reward error = %v; want %v", err, ErrInvalidCoinbase)
+	}
+
+	want := []Amount{8 * Coin, 4 * Coin, 4 * Coin, 2 * Coin, 2 * Coin, 0, 0}
+	for len(bc.Chain) <= len(want) {
+		if _, err := bc.CreateBlock("miner"); err != nil {
+			t.Fatalf("CreateBlock() error = %v", err)
+		}
+	}
+	for i, reward := range want {
+		if got := bc.Chain[i+1].Transactions[0].Amount; got != reward {
+			t.Errorf("Reward of block %d = %s; want %s", i+2, got, reward)
+		}
+	}
+
+	supply := bc.GetSupply()
+	if supply.Circulating != 20*Coin || supply.CurrentReward != 0 || supply.MaxSupply != 20*Coin || bc.GetBalance("miner") != 20*Coin {
+		t.Errorf("GetSupply() = %+v; want the 20 coins of the cap issued and no more reward", supply)
+	}
+	if err := bc.ValidChain(bc.Chain); err != nil {
+		t.Errorf("ValidChain() error = %v", err)
+	}
+
+	// A node with another schedule does not accept the chain
+	other := &Blockchain{Chain: bc.Chain, Nodes: make(map[string]bool), Emission: Emission{InitialReward: Coin, HalvingInterval: 2, MaxSupply: 20 * Coin}}
+	if err := other.ValidChain(bc.Chain); !errors.Is(err, ErrInvalidCoinbase) {
+		t.Errorf("ValidChain() with another schedule error = %v; want %v", err, ErrInvalidCoinbase)
+	}
+}
+
+// TestUTXOChainPastSupplyCap keeps mining a UTXO chain once the cap is reached and the
+// coinbase pays nothing
+func TestUTXOChainPastSupplyCap(t *testing.T) {
+	os.Remove(BlockchainFile)
+	defer os.Remove(BlockchainFile)
+
+	bc := NewBlockChainWithGenesis(Genesis{Ledger: UTXOModel, Emission: testEmission})
+	for len(bc.Chain) < 9 {
+		if _, err := bc.CreateBlock("miner"); err != nil {
+			t.Fatalf("CreateBlock() error = %v at height %d", err, len(bc.Chain))
+		}
+	}
+	if got := bc.GetSupply().Circulating; got != 20*Coin {
+		t.Errorf("Circulating supply = %s; want %s", got, 20*Coin)
+	}
+	if err := bc.ValidChain(bc.Chain); err != nil {
+		t.Errorf("ValidChain() error = %v", err)
+	}
+}
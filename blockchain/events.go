@@ -0,0 +1,91 @@
+package blockchain
+
+// OnBlock registers fn to be called for every block added on top of our chain by AddBlock
+// or CreateBlock. Listeners are called without the blockchain lock held, so they may use
+// the blockchain.
+func (bc *Blockchain) OnBlock(fn func(Block)) {
+	bc.mux.Lock()
+	defer bc.mux.Unlock()
+	bc.blockListeners = append(bc.blockListeners, fn)
+}
+
+// OnTransaction registers fn to be called for every transaction accepted by SubmitTransaction
+func (bc *Blockchain) OnTransaction(fn func(Transaction)) {
+	bc.mux.Lock()
+	defer bc.mux.Unlock()
+	bc.txListeners = append(bc.txListeners, fn)
+}
+
+// OnReorg registers fn to be called after every reorganization
+func (bc *Blockchain) OnReorg(fn func(ReorgEvent)) {
+	bc.mux.Lock()
+	defer bc.mux.Unlock()
+	bc.reorgListeners = append(bc.reorgListeners, fn)
+}
+
+// OnTipChanged registers fn to be called with the new tip whenever the tip of our chain
+// changes: when a block is added on top of it, and when we switch to another chain.
+// Miners use it to abandon work on a stale tip.
+func (bc *Blockchain) OnTipChanged(fn func(Block)) {
+	bc.mux.Lock()
+	defer bc.mux.Unlock()
+	bc.tipListeners = append(bc.tipListeners, fn)
+}
+
+// notifyBlock calls the OnBlock listeners. The caller must not hold bc.mux.
+func (bc *Blockchain) notifyBlock(block Block) {
+	bc.mux.Lock()
+	listeners := append([]func(Block){}, bc.blockListeners...)
+	bc.mux.Unlock()
+
+	for _, fn := range listeners {
+		fn(block)
+	}
+}
+
+// notifyTransaction calls the OnTransaction listeners. The caller must not hold bc.mux.
+func (bc *Blockchain) notifyTransaction(tx Transaction) {
+	bc.mux.Lock()
+	listeners := append([]func(Transaction){}, bc.txListeners...)
+	bc.mux.Unlock()
+
+	for _, fn := range listeners {
+		fn(tx)
+	}
+}
+
+// notifyReorg calls the OnReorg listeners. The caller must not hold bc.mux.
+func (bc *Blockchain) notifyReorg(event ReorgEvent) {
+	bc.mux.Lock()
+	listeners := append([]func(ReorgEvent){}, bc.reorgListeners...)
+	bc.mux.Unlock()
+
+	for _, fn := range listeners {
+		fn(event)
+	}
+}
+
+// notifyTipChanged calls the OnTipChanged listeners. The caller must not hold bc.mux.
+func (bc *Blockchain) notifyTipChanged(tip Block) {
+	bc.mux.Lock()
+	listeners := append([]func(Block){}, bc.tipListeners...)
+	if bc.tipChanged != nil {
+		close(bc.tipChanged)
+		bc.tipChanged = nil
+	}
+	bc.mux.Unlock()
+
+	for _, fn := range listeners {
+		fn(tip)
+	}
+}
+
+// tipWatch returns a channel that is closed the next time the tip changes
+func (bc *Blockchain) tipWatch() <-chan struct{} {
+	bc.mux.Lock()
+	defer bc.mux.Unlock()
+	if bc.tipChanged == nil {
+		bc.tipChanged = make(chan struct{})
+	}
+	return bc.tipChanged
+}
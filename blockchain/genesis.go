@@ -0,0 +1,97 @@
+package blockchain
+
+import (
+	"blocklite/utils"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+)
+
+// LedgerModel selects how balances are tracked on the chain
+type LedgerModel string
+
+const (
+	// AccountModel keeps a balance and a nonce per address
+	AccountModel LedgerModel = "account"
+	// UTXOModel tracks unspent transaction outputs that transactions consume as inputs
+	UTXOModel LedgerModel = "utxo"
+)
+
+// DefaultChainID names the network when nothing is configured
+const DefaultChainID = "blocklite"
+
+// GenesisTimestamp is the timestamp of every genesis block, so that nodes with the same
+// settings create the same genesis block
+const GenesisTimestamp = "2025-07-06T12:00:00Z"
+
+// Genesis holds the consensus settings a chain is created with.
+// Every node of a network must use the same settings.
+type Genesis struct {
+	ChainID          string      `json:"chain_id"` // name of the network; peers on another network are refused
+	Ledger           LedgerModel `json:"ledger"`
+	Emission         Emission    `json:"emission"`          // block reward schedule
+	CoinbaseMaturity int         `json:"coinbase_maturity"` // confirmations a mining reward needs before it can be spent
+	Retarget         Retarget    `json:"retarget"`          // difficulty adjustment schedule
+}
+
+// DefaultGenesis returns the settings used when nothing is configured
+func DefaultGenesis() Genesis {
+	return Genesis{
+		ChainID:          DefaultChainID,
+		Ledger:           AccountModel,
+		Emission:         DefaultEmission(),
+		CoinbaseMaturity: DefaultCoinbaseMaturity,
+		Retarget:         DefaultRetarget(),
+	}
+}
+
+// withDefaults returns the settings with the defaults filled in for the zero fields
+func (g Genesis) withDefaults() Genesis {
+	defaults := DefaultGenesis()
+	if g.ChainID == "" {
+		g.ChainID = defaults.ChainID
+	}
+	if g.Ledger == "" {
+		g.Ledger = defaults.Ledger
+	}
+	if g.Emission == (Emission{}) {
+		g.Emission = defaults.Emission
+	}
+	if g.CoinbaseMaturity == 0 {
+		g.CoinbaseMaturity = defaults.CoinbaseMaturity
+	}
+	if g.Retarget == (Retarget{}) {
+		g.Retarget = defaults.Retarget
+	}
+	return g
+}
+
+// Hash returns the hex encoded SHA-256 hash of the JSON encoding of the settings, with the
+// defaults filled in. The genesis block commits to it.
+func (g Genesis) Hash() string {
+	data, _ := json.Marshal(g.withDefaults())
+	hash := utils.SHA256(string(data))
+	return hex.EncodeToString(hash[:])
+}
+
+// Validate checks that the settings are usable
+func (g Genesis) Validate() error {
+	if g.ChainID == "" {
+		return fmt.Errorf("chain ID must not be empty")
+	}
+	switch g.Ledger {
+	case AccountModel, UTXOModel:
+	default:
+		return fmt.Errorf("unknown ledger model %q", g.Ledger)
+	}
+	if g.CoinbaseMaturity < 1 {
+		return fmt.Errorf("coinbase maturity must be at least 1, got %d", g.CoinbaseMaturity)
+	}
+	if err := g.Emission.Validate(); err != nil {
+		return fmt.Errorf("emission: %w", err)
+	}
+	if err := g.Retarget.Validate(); err != nil {
+		return fmt.Errorf("retarget: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,269 @@
+package blockchain
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// gossipSeenLimit is the number of announcements remembered to drop duplicates
+const gossipSeenLimit = 10000
+
+// GossipTimeout bounds every announcement sent to a peer
+var GossipTimeout = 5 * time.Second
+
+// GossipMessage is the body of a block or transaction announcement
+type GossipMessage struct {
+	From        string       `json:"from,omitempty"` // address of the announcing node
+	Block       *Block       `json:"block,omitempty"`
+	Transaction *Transaction `json:"transaction,omitempty"`
+}
+
+// Gossip pushes the blocks and transactions accepted by a blockchain to the registered nodes,
+// and handles the announcements of other nodes: what is valid is accepted and relayed in
+// turn. Every node remembers what it has seen, so announcements do not loop.
+type Gossip struct {
+	bc     *Blockchain
+	self   string
+	client *http.Client
+
+	mux     sync.Mutex
+	seen    map[string]bool
+	order   []string          // seen keys, oldest first
+	origin  map[string]string // key -> node it came from, which does not need it back
+	syncing map[string]bool   // nodes a sync started by an announcement is running with
+}
+
+// NewGossip starts announcing the blocks and transactions bc accepts. self is the address
+// other nodes reach us at; it is sent along so they can sync back from us.
+func NewGossip(bc *Blockchain, self string) *Gossip {
+	g := &Gossip{
+		bc:      bc,
+		self:    self,
+		client:  &http.Client{Timeout: GossipTimeout},
+		seen:    make(map[string]bool),
+		origin:  make(map[string]string),
+		syncing: make(map[string]bool),
+	}
+	bc.OnBlock(func(block Block) {
+		g.announce("block:"+block.CalculateHash(), "/api/gossip/blocks", GossipMessage{Block: &block})
+	})
+	bc.OnTransaction(func(tx Transaction) {
+		g.announce("tx:"+tx.Hash(), "/api/gossip/transactions", GossipMessage{Transaction: &tx})
+	})
+	return g
+}
+
+// HandleBlock processes a block announced by another node. A block extending our tip is
+// added, and thereby relayed. A block that does not fit on our tip but is higher, or on
+// another branch, makes us sync with the node that sent it, in the background, once its
+// header checked out and if the node is registered. remoteIP is the address the
+// announcement came in from; msg.From is only what the sender claims, so the sender is
+// known by PeerID(remoteIP, msg.From). Invalid blocks count against it, and announcements
+// of banned senders are refused.
+func (g *Gossip) HandleBlock(msg GossipMessage, remoteIP string) error {
+	if msg.Block == nil {
+		return fmt.Errorf("%w: announcement carries no block", ErrMalformed)
+	}
+	from := PeerID(remoteIP, msg.From)
+	if g.bc.peers.IsBanned(from) {
+		return ErrPeerBanned
+	}
+	key := "block:" + msg.Block.CalculateHash()
+	if g.known(key) {
+		return nil
+	}
+
+	// The block is only remembered once it checked out: a copy with mutated transactions
+	// has the same hash and must not shadow the real block. Relaying a valid block
+	// remembers it; the sender is noted first so it is not sent the block back.
+	g.mux.Lock()
+	g.origin[key] = from
+	g.mux.Unlock()
+
+	err := g.bc.AddBlock(*msg.Block)
+	if errors.Is(err, ErrStaleBlock) {
+		var worthSyncing bool
+		if worthSyncing, err = g.bc.checkStaleHeader(*msg.Block); err == nil {
+			g.remember(key, from)
+			if worthSyncing && g.bc.isNode(from) {
+				g.syncWith(from)
+			}
+			return nil
+		}
+	}
+	if err != nil {
+		g.mux.Lock()
+		if !g.seen[key] {
+			delete(g.origin, key)
+		}
+		g.mux.Unlock()
+	}
+	g.punish(from, err)
+	return err
+}
+
+// syncWith syncs with a node in the background, unless such a sync is running already
+func (g *Gossip) syncWith(node string) {
+	g.mux.Lock()
+	defer g.mux.Unlock()
+	if g.syncing[node] {
+		return
+	}
+	g.syncing[node] = true
+
+	go func() {
+		g.bc.SyncWithPeer(context.Background(), node)
+		g.mux.Lock()
+		delete(g.syncing, node)
+		g.mux.Unlock()
+	}()
+}
+
+// checkStaleHeader checks the header of an announced block that does not extend our tip,
+// before syncing for it, and reports whether it is worth syncing. A block whose parent we
+// have must follow it under the consensus rules. A block further ahead must at least carry
+// a proof of work at the lowest difficulty the retarget schedule allows at its height
+// coming from our tip; branches forking deeper are left to the periodic sync.
+func (bc *Blockchain) checkStaleHeader(block Block) (bool, error) {
+	bc.mux.Lock()
+	defer bc.mux.Unlock()
+
+	heights := bc.blockHeights()
+	if _, ok := heights[block.CalculateHash()]; ok {
+		return false, nil // we have it
+	}
+	if position, ok := heights[block.PreviousHash]; ok {
+		if err := checkLinkage(bc.Chain[:position+1], block, bc.retarget()); err != nil {
+			return false, err
+		}
+		return block.Index >= len(bc.Chain), nil
+	}
+	if len(bc.Chain) == 0 || block.Index <= len(bc.Chain) {
+		return false, nil
+	}
+
+	lowest := bc.Chain[len(bc.Chain)-1].Difficulty - (block.Index-len(bc.Chain))/bc.retarget().Interval - 1
+	if block.Difficulty < max(lowest, MinDifficulty) {
+		return false, ruleError(block, -1, RuleDifficulty, errors.New("difficulty is below what the retarget rule allows"), "at least "+strconv.Itoa(max(lowest, MinDifficulty)), block.Difficulty)
+	}
+	if valid, hashHex := VerifyProof(block.Header()); !valid {
+		return false, ruleError(block, -1, RuleProof, fmt.Errorf("proof hash %s does not meet difficulty %d", hashHex, block.Difficulty), nil, nil)
+	}
+	return true, nil
+}
+
+// HandleTransaction processes a transaction announced by another node. Valid transactions
+// enter the mempool and are relayed. Badly signed ones count against the sender, known by
+// PeerID(remoteIP, msg.From) as for blocks. Refused transactions are not remembered, so
+// they are looked at again when announced again.
+func (g *Gossip) HandleTransaction(msg GossipMessage, remoteIP string) error {
+	if msg.Transaction == nil {
+		return fmt.Errorf("%w: announcement carries no transaction", ErrMalformed)
+	}
+	from := PeerID(remoteIP, msg.From)
+	if g.bc.peers.IsBanned(from) {
+		return ErrPeerBanned
+	}
+	key := "tx:" + msg.Transaction.Hash()
+	if g.known(key) {
+		return nil
+	}
+
+	// As for blocks, the transaction is only remembered once it is accepted: one refused
+	// for now, such as a nonce that arrived ahead of its predecessor, may be announced
+	// again and fit later.
+	g.mux.Lock()
+	g.origin[key] = from
+	g.mux.Unlock()
+
+	_, err := g.bc.SubmitTransaction(*msg.Transaction)
+	if err != nil {
+		g.mux.Lock()
+		if !g.seen[key] {
+			delete(g.origin, key)
+		}
+		g.mux.Unlock()
+	}
+	g.punish(from, err)
+	return err
+}
+
+// punish penalizes the sender of an announcement for the error it caused
+func (g *Gossip) punish(from string, err error) {
+	if from != "" && g.bc.peers.Punish(from, err) {
+		fmt.Printf("Banned %s: %v\n", from, err)
+	}
+}
+
+// known reports whether an announcement was seen before
+func (g *Gossip) known(key string) bool {
+	g.mux.Lock()
+	defer g.mux.Unlock()
+	return g.seen[key]
+}
+
+// remember marks an announcement as seen and reports whether it is new
+func (g *Gossip) remember(key, from string) bool {
+	g.mux.Lock()
+	defer g.mux.Unlock()
+
+	if g.seen[key] {
+		return false
+	}
+	g.seen[key] = true
+	g.order = append(g.order, key)
+	if len(g.order) > gossipSeenLimit {
+		delete(g.seen, g.order[0])
+		delete(g.origin, g.order[0])
+		g.order = g.order[1:]
+	}
+	if from != "" {
+		g.origin[key] = from
+	}
+	return true
+}
+
+// announce sends a message to every registered node but the one it came from, in the background
+func (g *Gossip) announce(key, path string, msg GossipMessage) {
+	g.remember(key, "")
+	g.mux.Lock()
+	from := g.origin[key]
+	g.mux.Unlock()
+
+	msg.From = g.self
+	for _, node := range g.bc.GetNodes() {
+		if node == from || node == g.self {
+			continue
+		}
+		go func(node string) {
+			if err := g.post(node, path, msg); err != nil {
+				fmt.Printf("Gossip to %s failed: %v\n", node, err)
+			}
+		}(node)
+	}
+}
+
+// post delivers a message to a node
+func (g *Gossip) post(node, path string, msg GossipMessage) error {
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	resp, err := g.client.Post("http://"+node+path, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("POST %s: %s", path, resp.Status)
+	}
+	return nil
+}
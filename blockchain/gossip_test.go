@@ -0,0 +1,259 @@
+package blockchain
+
+import (
+	"blocklite/wallet"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// gossipNode serves the gossip endpoints of a blockchain
+func gossipNode(t *testing.T, bc *Blockchain) (*Gossip, string) {
+	t.Helper()
+	var g *Gossip
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/gossip/blocks", func(w http.ResponseWriter, r *http.Request) {
+		var msg GossipMessage
+		json.NewDecoder(r.Body).Decode(&msg)
+		if err := g.HandleBlock(msg, remoteHost(r)); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	})
+	mux.HandleFunc("/api/gossip/transactions", func(w http.ResponseWriter, r *http.Request) {
+		var msg GossipMessage
+		json.NewDecoder(r.Body).Decode(&msg)
+		if err := g.HandleTransaction(msg, remoteHost(r)); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	})
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	address := strings.TrimPrefix(server.URL, "http://")
+	g = NewGossip(bc, address)
+	return g, address
+}
+
+// remoteHost returns the host of the address a request came in from
+func remoteHost(r *http.Request) string {
+	host, _, _ := net.SplitHostPort(r.RemoteAddr)
+	return host
+}
+
+// eventually polls check until it holds or a few seconds have passed
+func eventually(t *testing.T, what string, check func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if check() {
+			return
+		}
+	}
+	t.Errorf("Timed out waiting for %s", what)
+}
+
+// TestGossipPropagates announces a transaction and a block on A and waits for them on C,
+// which only hears about them through B.
+func TestGossipPropagates(t *testing.T) {
+	os.Remove(BlockchainFile)
+	defer os.Remove(BlockchainFile)
+	defer mockMaturity(1)() // rewards are spent in the next block
+
+	a := NewBlockChain()
+	w := wallet.NewWallet()
+	a.CreateBlock(w.GetAddress())
+	b := &Blockchain{Chain: append([]Block{}, a.Chain...), Nodes: make(map[string]bool)}
+	c := &Blockchain{Chain: append([]Block{}, a.Chain...), Nodes: make(map[string]bool)}
+
+	_, addressA := gossipNode(t, a)
+	gossipB, addressB := gossipNode(t, b)
+	_, addressC := gossipNode(t, c)
+	a.RegisterNode(addressB)
+	b.RegisterNode(addressA)
+	b.RegisterNode(addressC)
+	c.RegisterNode(addressB)
+
+	tx := signedTransaction(t, w, "B", Coin, 0)
+	if _, err := a.SubmitTransaction(tx); err != nil {
+		t.Fatalf("SubmitTransaction() error = %v", err)
+	}
+	eventually(t, "the transaction to reach C", func() bool {
+		return c.GetTransactionStatus(tx.Hash()).Status == TxStatusPending
+	})
+
+	block, err := a.CreateBlock("miner")
+	if err != nil {
+		t.Fatalf("CreateBlock() error = %v", err)
+	}
+	eventually(t, "the block to reach C", func() bool {
+		latest := c.GetLatestBlock()
+		return latest.CalculateHash() == block.CalculateHash()
+	})
+	if c.GetTransactionStatus(tx.Hash()).Status != TxStatusConfirmed {
+		t.Error("Transaction is not confirmed on C")
+	}
+
+	// Announcements that come back are recognized and dropped
+	if gossipB.remember("block:"+block.CalculateHash(), addressC) {
+		t.Error("B did not remember the block it relayed")
+	}
+}
+
+// TestGossipBansInvalidBlocks refuses announcements from a node that sent an invalid block.
+func TestGossipBansInvalidBlocks(t *testing.T) {
+	os.Remove(BlockchainFile)
+	defer os.Remove(BlockchainFile)
+
+	bc := NewBlockChain()
+	g := NewGossip(bc, "self")
+
+	block := bc.NewBlockTemplate("miner")
+	block.Proof = ProofOfWork(block.Header()) + 1
+	for ok, _ := VerifyProof(block.Header()); ok; ok, _ = VerifyProof(block.Header()) {
+		block.Proof++
+	}
+	// The sender claims to be another node; the address it connected from is banned
+	if err := g.HandleBlock(GossipMessage{From: "honest", Block: &block}, "evil"); !errors.Is(err, ErrInvalidBlock) {
+		t.Fatalf("HandleBlock() error = %v; want %v", err, ErrInvalidBlock)
+	}
+	if !bc.PeerScores().IsBanned("evil") {
+		t.Fatal("Sender of an invalid block is not banned")
+	}
+	if bc.PeerScores().IsBanned("honest") {
+		t.Error("Node named in the announcement is banned instead of the sender")
+	}
+
+	block.Proof = ProofOfWork(block.Header())
+	if err := g.HandleBlock(GossipMessage{From: "evil", Block: &block}, "evil"); !errors.Is(err, ErrPeerBanned) {
+		t.Errorf("HandleBlock() from a banned node error = %v; want %v", err, ErrPeerBanned)
+	}
+	if bc.GetLength() != 1 {
+		t.Errorf("GetLength() = %d; want the block of a banned node ignored", bc.GetLength())
+	}
+}
+
+// TestGossipMutatedBlock sends a copy of a block with its last transaction repeated, which
+// has the same hash, before the real block. The copy must not make the real block look seen.
+func TestGossipMutatedBlock(t *testing.T) {
+	os.Remove(BlockchainFile)
+	defer os.Remove(BlockchainFile)
+	defer mockMaturity(1)() // rewards are spent in the next block
+
+	bc := NewBlockChain()
+	w := wallet.NewWallet()
+	bc.CreateBlock(w.GetAddress())
+	g := NewGossip(bc, "self")
+
+	block := mineBlock(t, bc, signedTransaction(t, w, "B", Coin, 0), signedTransaction(t, w, "C", Coin, 1))
+	mutated := block
+	mutated.Transactions = append(append([]Transaction{}, block.Transactions...), block.Transactions[2])
+	if mutated.CalculateHash() != block.CalculateHash() {
+		t.Fatal("Repeating the last transaction changed the block hash")
+	}
+
+	if err := g.HandleBlock(GossipMessage{From: "evil", Block: &mutated}, "evil"); !errors.Is(err, ErrDuplicateTransaction) {
+		t.Fatalf("HandleBlock() error = %v; want %v", err, ErrDuplicateTransaction)
+	}
+	if err := g.HandleBlock(GossipMessage{From: "honest", Block: &block}, "honest"); err != nil {
+		t.Fatalf("HandleBlock() error = %v for the real block", err)
+	}
+	if bc.GetLength() != 3 {
+		t.Errorf("GetLength() = %d; want the real block added", bc.GetLength())
+	}
+}
+
+// TestGossipChecksStaleBlocks only syncs for announced blocks whose header checks out, with
+// registered nodes, and with each of them once at a time.
+func TestGossipChecksStaleBlocks(t *testing.T) {
+	os.Remove(BlockchainFile)
+	defer os.Remove(BlockchainFile)
+
+	bc := NewBlockChain()
+	other := &Blockchain{Chain: append([]Block{}, bc.Chain...), Nodes: make(map[string]bool)}
+	bc.CreateBlock("miner")
+	for range 4 {
+		other.CreateBlock("other") // a competing branch
+	}
+	g := NewGossip(bc, "self")
+
+	var requests atomic.Int32
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		<-release
+		w.Write([]byte(`{"headers": []}`))
+	}))
+	defer server.Close()
+	peer := strings.TrimPrefix(server.URL, "http://")
+
+	// Junk far ahead of our tip is refused without syncing
+	junk := Block{Index: 50, Timestamp: other.Chain[4].Timestamp, PreviousHash: "unknown", Difficulty: 1}
+	if err := g.HandleBlock(GossipMessage{From: "evil", Block: &junk}, "evil"); !errors.Is(err, ErrInvalidBlock) {
+		t.Errorf("HandleBlock() error = %v; want %v", err, ErrInvalidBlock)
+	}
+
+	// Valid blocks from a node we do not know do not start a sync
+	if err := g.HandleBlock(GossipMessage{From: peer, Block: &other.Chain[4]}, "127.0.0.1"); err != nil {
+		t.Fatalf("HandleBlock() error = %v", err)
+	}
+	g.mux.Lock()
+	if g.syncing[peer] {
+		t.Error("Synced with an unregistered node")
+	}
+	g.mux.Unlock()
+
+	// A registered node is synced with once, however many blocks it announces
+	bc.RegisterNode(peer)
+	for _, block := range []Block{other.Chain[1], other.Chain[3]} {
+		if err := g.HandleBlock(GossipMessage{From: peer, Block: &block}, "127.0.0.1"); err != nil {
+			t.Fatalf("HandleBlock() error = %v", err)
+		}
+	}
+	eventually(t, "the sync to start", func() bool { return requests.Load() == 1 })
+	close(release)
+	eventually(t, "the sync to finish", func() bool {
+		g.mux.Lock()
+		defer g.mux.Unlock()
+		return !g.syncing[peer]
+	})
+	if got := requests.Load(); got != 1 {
+		t.Errorf("Header requests = %d; want 1", got)
+	}
+}
+
+// TestGossipOutOfOrderTransactions announces the transactions of a sender in the wrong
+// order. The one ahead of its predecessor is refused, but not forgotten: announced again
+// after the predecessor, it enters the mempool.
+func TestGossipOutOfOrderTransactions(t *testing.T) {
+	os.Remove(BlockchainFile)
+	defer os.Remove(BlockchainFile)
+	defer mockMaturity(1)() // rewards are spent in the next block
+
+	bc := NewBlockChain()
+	w := wallet.NewWallet()
+	bc.CreateBlock(w.GetAddress())
+	g := NewGossip(bc, "self")
+
+	first := signedTransaction(t, w, "B", 1, 0)
+	second := signedTransaction(t, w, "B", 1, 1)
+	if err := g.HandleTransaction(GossipMessage{From: "peer", Transaction: &second}, "peer"); !errors.Is(err, ErrNonceTooHigh) {
+		t.Fatalf("HandleTransaction() ahead of its predecessor error = %v; want %v", err, ErrNonceTooHigh)
+	}
+	for _, tx := range []Transaction{first, second} {
+		if err := g.HandleTransaction(GossipMessage{From: "peer", Transaction: &tx}, "peer"); err != nil {
+			t.Fatalf("HandleTransaction() with nonce %d error = %v", tx.Nonce, err)
+		}
+	}
+	if pending := bc.PendingTransactions(); len(pending) != 2 {
+		t.Errorf("PendingTransactions() = %d transactions; want 2", len(pending))
+	}
+	if bc.PeerScores().IsBanned("peer") {
+		t.Error("Sender of transactions out of order is banned")
+	}
+}
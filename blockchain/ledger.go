@@ -0,0 +1,164 @@
+package blockchain
+
+import "fmt"
+
+// ledgerState is the confirmed state of the ledger at the tip of a chain: balances and
+// nonces on an account chain, the unspent outputs on a UTXO chain
+type ledgerState struct {
+	model    LedgerModel
+	balances map[string]Amount // account model
+	nonces   map[string]uint64 // account model: next nonce expected from each sender
+	utxo     UTXOSet           // UTXO model
+	supply   Amount            // coins issued by the blocks so far
+
+	height    int                 // index of the last block applied
+	coinbases map[int]Transaction // block index -> its coinbase, for the maturity rule
+	maturity  int                 // confirmations a coinbase needs before it can be spent
+}
+
+// newLedgerState returns the state of an empty chain whose coinbases need maturity confirmations
+func newLedgerState(model LedgerModel, maturity int) *ledgerState {
+	if model == "" {
+		model = AccountModel
+	}
+	return &ledgerState{
+		model:    model,
+		balances: make(map[string]Amount),
+		nonces:   make(map[string]uint64),
+		utxo:     UTXOSet{},
+
+		coinbases: make(map[int]Transaction),
+		maturity:  maturity,
+	}
+}
+
+// balance returns the confirmed balance of an address
+func (s *ledgerState) balance(address string) Amount {
+	if s.model == UTXOModel {
+		return s.utxo.Balance(address)
+	}
+	return s.balances[address]
+}
+
+// nextNonce returns the nonce the next confirmed transaction from address must use
+func (s *ledgerState) nextNonce(address string) uint64 {
+	return s.nonces[address]
+}
+
+// connectBlock applies the transactions of a block. Every transfer must use the sender's
+// next nonce, be covered by its balance at that point of the block and leave the rewards
+// that are not mature yet alone. On a UTXO chain it returns the outputs the block spent,
+// which are needed to disconnect it again. If a transaction does not apply the state is
+// left untouched.
+func (s *ledgerState) connectBlock(block Block) ([]SpentOutput, error) {
+	immature := s.immatureCoinbases(block.Index)
+	if len(block.Transactions) > 0 && block.Transactions[0].IsCoinbase() && s.maturity > 0 {
+		immature = append(immature, block.Transactions[0])
+	}
+
+	var undo []SpentOutput
+	var err error
+	if s.model == UTXOModel {
+		undo, err = s.connectOutputs(block, immature)
+	} else {
+		err = s.connectAccounts(block, immature)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	s.supply += issued(block)
+	s.height = block.Index
+	if len(block.Transactions) > 0 && block.Transactions[0].IsCoinbase() {
+		s.coinbases[block.Index] = block.Transactions[0]
+	}
+	return undo, nil
+}
+
+// connectOutputs applies a block to the unspent outputs of a UTXO chain, refusing inputs
+// that spend one of the immature coinbases
+func (s *ledgerState) connectOutputs(block Block, immature []Transaction) ([]SpentOutput, error) {
+	for i, tx := range block.Transactions {
+		if input, ok := immatureInput(tx, immature); ok {
+			return nil, ruleError(block, i, RuleMaturity, fmt.Errorf("%w: %s:%d", ErrImmatureCoinbase, input.TxID, input.Index), nil, nil)
+		}
+	}
+	return s.utxo.ConnectBlock(block)
+}
+
+// connectAccounts applies a block to the balances and nonces of an account chain. Senders
+// cannot spend the rewards of the immature coinbases. Inputs and outputs are refused: the
+// ledger only moves Amount, so outputs would let a coinbase pay one thing and credit another.
+func (s *ledgerState) connectAccounts(block Block, immature []Transaction) error {
+	// Work on the accounts the block touches and commit them once the whole block applies
+	balances := make(map[string]Amount)
+	nonces := make(map[string]uint64)
+	balance := func(address string) Amount {
+		if amount, ok := balances[address]; ok {
+			return amount
+		}
+		return s.balances[address]
+	}
+
+	for i, tx := range block.Transactions {
+		if len(tx.Inputs) > 0 || len(tx.Outputs) > 0 {
+			return ruleError(block, i, RuleInputs, ErrInputsNotAllowed, nil, nil)
+		}
+		if tx.Amount < 0 || tx.Amount > maxAmount || tx.Fee < 0 || tx.Fee > maxAmount {
+			return ruleError(block, i, RuleAmount, ErrInvalidAmount, nil, nil)
+		}
+		if !tx.IsCoinbase() {
+			if tx.Amount == 0 {
+				return ruleError(block, i, RuleAmount, ErrInvalidAmount, nil, tx.Amount)
+			}
+			if _, ok := nonces[tx.Sender]; !ok {
+				nonces[tx.Sender] = s.nonces[tx.Sender]
+			}
+			expected := nonces[tx.Sender]
+			if err := checkNonce(nonces, tx); err != nil {
+				return ruleError(block, i, RuleNonce, err, expected, tx.Nonce)
+			}
+			if available := balance(tx.Sender); available < tx.Amount+tx.Fee {
+				return ruleError(block, i, RuleBalance, fmt.Errorf("%w: %s cannot pay %s", ErrInsufficientBalance, tx.Sender, tx.Amount+tx.Fee), tx.Amount+tx.Fee, available)
+			}
+			if spendable := balance(tx.Sender) - immatureAmount(immature, tx.Sender); spendable < tx.Amount+tx.Fee {
+				return ruleError(block, i, RuleMaturity, fmt.Errorf("%w: %s can only spend %s", ErrImmatureCoinbase, tx.Sender, spendable), tx.Amount+tx.Fee, spendable)
+			}
+			balances[tx.Sender] = balance(tx.Sender) - tx.Amount - tx.Fee
+		}
+		balances[tx.Receiver] = balance(tx.Receiver) + tx.Amount
+	}
+
+	for address, amount := range balances {
+		s.balances[address] = amount
+	}
+	for sender, nonce := range nonces {
+		s.nonces[sender] = nonce
+	}
+	return nil
+}
+
+// disconnectBlock undoes connectBlock for the block at the tip. undo holds the outputs the
+// block spent on a UTXO chain.
+func (s *ledgerState) disconnectBlock(block Block, undo []SpentOutput) {
+	s.supply -= issued(block)
+	s.height = block.Index - 1
+	delete(s.coinbases, block.Index)
+	if s.model == UTXOModel {
+		s.utxo.DisconnectBlock(block, undo)
+		return
+	}
+
+	for i := len(block.Transactions) - 1; i >= 0; i-- {
+		tx := block.Transactions[i]
+		s.balances[tx.Receiver] -= tx.Amount
+		if !tx.IsCoinbase() {
+			s.balances[tx.Sender] += tx.Amount + tx.Fee
+			if tx.Nonce == 0 {
+				delete(s.nonces, tx.Sender)
+			} else {
+				s.nonces[tx.Sender] = tx.Nonce
+			}
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package blockchain
+
+import "errors"
+
+// DefaultCoinbaseMaturity is the number of confirmations a coinbase needs before its outputs
+// can be spent, unless the genesis settings say otherwise: a reward mined in block i can be
+// spent from block i+maturity on, so a reorganization cannot erase coins that have already
+// been passed on.
+var DefaultCoinbaseMaturity = 10
+
+// ErrImmatureCoinbase is returned for transactions that spend a reward before it has
+// the confirmations the genesis settings require
+var ErrImmatureCoinbase = errors.New("coinbase is not mature")
+
+// immatureCoinbases returns the coinbases of the state that a transaction in block index
+// cannot spend yet
+func (s *ledgerState) immatureCoinbases(index int) []Transaction {
+	immature := []Transaction{}
+	for mined := max(index-s.maturity+1, 1); mined < index; mined++ {
+		if coinbase, ok := s.coinbases[mined]; ok {
+			immature = append(immature, coinbase)
+		}
+	}
+	return immature
+}
+
+// immatureBalance returns the part of the confirmed balance of an address that the next
+// block cannot spend because it was paid by immature coinbases
+func (s *ledgerState) immatureBalance(address string) Amount {
+	immature := s.immatureCoinbases(s.height + 1)
+	if s.model != UTXOModel {
+		return immatureAmount(immature, address)
+	}
+
+	// Only the reward outputs that are still unspent count
+	amount := Amount(0)
+	for _, coinbase := range immature {
+		id := coinbase.Hash()
+		for index, output := range coinbase.outputs() {
+			if unspent, ok := s.utxo[OutPoint{TxID: id, Index: index}]; ok && unspent.Address == address {
+				amount += output.Amount
+			}
+		}
+	}
+	return amount
+}
+
+// spendable returns the confirmed balance of an address the next block can spend
+func (s *ledgerState) spendable(address string) Amount {
+	return s.balance(address) - s.immatureBalance(address)
+}
+
+// immatureAmount returns what the coinbases pay to address
+func immatureAmount(coinbases []Transaction, address string) Amount {
+	amount := Amount(0)
+	for _, coinbase := range coinbases {
+		for _, output := range coinbase.outputs() {
+			if output.Address == address {
+				amount += output.Amount
+			}
+		}
+	}
+	return amount
+}
+
+// immatureInput returns the first input of tx that spends an output of one of the coinbases
+func immatureInput(tx Transaction, coinbases []Transaction) (TxInput, bool) {
+	if len(tx.Inputs) == 0 || len(coinbases) == 0 {
+		return TxInput{}, false
+	}
+	ids := make(map[string]bool, len(coinbases))
+	for _, coinbase := range coinbases {
+		ids[coinbase.Hash()] = true
+	}
+	for _, input := range tx.Inputs {
+		if ids[input.TxID] {
+			return input, true
+		}
+	}
+	return TxInput{}, false
+}
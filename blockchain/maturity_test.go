@@ -0,0 +1,112 @@
+package blockchain
+
+import (
+	"blocklite/wallet"
+	"errors"
+	"os"
+	"testing"
+)
+
+// mockMaturity is a helper to change DefaultCoinbaseMaturity for a test
+func mockMaturity(confirmations int) func() {
+	original := DefaultCoinbaseMaturity
+	DefaultCoinbaseMaturity = confirmations
+	return func() { DefaultCoinbaseMaturity = original }
+}
+
+// TestCoinbaseMaturity keeps a reward locked until it has the confirmations the genesis
+// settings require, both when it is submitted and when it is mined.
+func TestCoinbaseMaturity(t *testing.T) {
+	os.Remove(BlockchainFile)
+	defer os.Remove(BlockchainFile)
+
+	genesis := DefaultGenesis()
+	genesis.CoinbaseMaturity = 3
+	bc := NewBlockChainWithGenesis(genesis)
+	w := wallet.NewWallet()
+	bc.CreateBlock(w.GetAddress())
+	spend := signedTransaction(t, w, "bob", Coin, 0)
+
+	for _, height := range []int{2, 3} {
+		if _, err := bc.SubmitTransaction(spend); !errors.Is(err, ErrImmatureCoinbase) {
+			t.Errorf("SubmitTransaction() at height %d error = %v; want %v", height, err, ErrImmatureCoinbase)
+		}
+		if got := bc.GetImmatureBalance(w.GetAddress()); got != MiningReward || bc.GetBalance(w.GetAddress()) != MiningReward {
+			t.Errorf("GetImmatureBalance() at height %d = %s; want the whole reward %s", height, got, MiningReward)
+		}
+
+		// Miners cannot include it either
+		err := bc.AddBlock(mineBlock(t, bc, spend))
+		var validationErr *ValidationError
+		if !errors.As(err, &validationErr) || validationErr.Rule != RuleMaturity || !errors.Is(err, ErrImmatureCoinbase) {
+			t.Errorf("AddBlock() spending an immature reward error = %v; want rule %s", err, RuleMaturity)
+		}
+		bc.CreateBlock("miner")
+	}
+
+	// Three confirmations later the reward can be spent
+	if got := bc.GetImmatureBalance(w.GetAddress()); got != 0 {
+		t.Errorf("GetImmatureBalance() at height 4 = %s; want 0", got)
+	}
+	if _, err := bc.SubmitTransaction(spend); err != nil {
+		t.Fatalf("SubmitTransaction() of a mature reward error = %v", err)
+	}
+	bc.CreateBlock("miner")
+	if got := bc.GetBalance("bob"); got != Coin {
+		t.Errorf("GetBalance(bob) = %s; want %s", got, Coin)
+	}
+}
+
+// TestValidChainCoinbaseMaturity refuses a chain that spends a reward too early.
+func TestValidChainCoinbaseMaturity(t *testing.T) {
+	os.Remove(BlockchainFile)
+	defer os.Remove(BlockchainFile)
+
+	bc := NewBlockChainWithGenesis(Genesis{CoinbaseMaturity: 1})
+	w := wallet.NewWallet()
+	bc.CreateBlock(w.GetAddress())
+	if err := bc.AddBlock(mineBlock(t, bc, signedTransaction(t, w, "bob", Coin, 0))); err != nil {
+		t.Fatalf("AddBlock() error = %v", err)
+	}
+
+	// A node requiring the default 10 confirmations refuses the chain
+	err := (&Blockchain{}).ValidChain(bc.Chain)
+	var validationErr *ValidationError
+	if !errors.As(err, &validationErr) || validationErr.Rule != RuleMaturity || validationErr.BlockIndex != 3 || validationErr.TxIndex != 1 {
+		t.Errorf("ValidChain() error = %v; want transaction 1 of block 3 to break the maturity rule", err)
+	}
+}
+
+// TestUTXOCoinbaseMaturity locks the outputs of a coinbase on a UTXO chain.
+func TestUTXOCoinbaseMaturity(t *testing.T) {
+	os.Remove(BlockchainFile)
+	defer os.Remove(BlockchainFile)
+
+	bc := NewBlockChainWithGenesis(Genesis{Ledger: UTXOModel, CoinbaseMaturity: 2})
+	alice := wallet.NewWallet()
+	bc.CreateBlock(alice.GetAddress())
+	utxo := bc.GetUnspentOutputs(alice.GetAddress())[0]
+	spend := signTransaction(t, alice, Transaction{
+		Sender:  alice.GetAddress(),
+		Inputs:  []TxInput{{TxID: utxo.TxID, Index: utxo.Index}},
+		Outputs: []TxOutput{{Address: "bob", Amount: MiningReward}},
+	})
+
+	if _, err := bc.SubmitTransaction(spend); !errors.Is(err, ErrImmatureCoinbase) {
+		t.Errorf("SubmitTransaction() error = %v; want %v", err, ErrImmatureCoinbase)
+	}
+	if got := bc.GetImmatureBalance(alice.GetAddress()); got != MiningReward {
+		t.Errorf("GetImmatureBalance() = %s; want %s", got, MiningReward)
+	}
+	if err := bc.AddBlock(mineBlock(t, bc, spend)); !errors.Is(err, ErrImmatureCoinbase) {
+		t.Errorf("AddBlock() error = %v; want %v", err, ErrImmatureCoinbase)
+	}
+
+	bc.CreateBlock("miner")
+	if got := bc.GetImmatureBalance(alice.GetAddress()); got != 0 {
+		t.Errorf("GetImmatureBalance() after 2 confirmations = %s; want 0", got)
+	}
+	if _, err := bc.SubmitTransaction(spend); err != nil {
+		t.Errorf("SubmitTransaction() of a mature output error = %v", err)
+	}
+}
@@ -0,0 +1,141 @@
+package blockchain
+
+import (
+	"blocklite/wallet"
+	"errors"
+	"fmt"
+)
+
+// ErrMempoolConflict is returned for a transaction spending an output that a pending
+// transaction already spends
+var ErrMempoolConflict = errors.New("conflicts with a pending transaction")
+
+// Mempool holds the transactions waiting to be mined, in arrival order, together with what
+// they commit their senders to: the amount plus fee each sender has pending and the next
+// nonce on an account chain, the outputs they spend on a UTXO chain.
+// The zero value is an empty mempool. A Blockchain guards its mempool with its own mutex.
+type Mempool struct {
+	txs      []Transaction
+	outflows map[string]Amount   // sender -> amount plus fee of its pending transactions
+	nonces   map[string]uint64   // sender -> next nonce once its pending transactions are mined
+	spent    map[OutPoint]string // outpoint -> ID of the pending transaction spending it
+}
+
+// Transactions returns the pending transactions in arrival order
+func (m *Mempool) Transactions() []Transaction {
+	return append([]Transaction{}, m.txs...)
+}
+
+// Len returns the number of pending transactions
+func (m *Mempool) Len() int {
+	return len(m.txs)
+}
+
+// Outflow returns the amount plus fee of every pending transaction from sender
+func (m *Mempool) Outflow(sender string) Amount {
+	return m.outflows[sender]
+}
+
+// find returns the pending transaction with the given ID
+func (m *Mempool) find(id string) (Transaction, bool) {
+	for _, tx := range m.txs {
+		if tx.Hash() == id {
+			return tx, true
+		}
+	}
+	return Transaction{}, false
+}
+
+// nextNonce returns the nonce the next transaction from sender must use, counting the
+// pending ones
+func (m *Mempool) nextNonce(sender string, state *ledgerState) uint64 {
+	if nonce, ok := m.nonces[sender]; ok {
+		return nonce
+	}
+	return state.nextNonce(sender)
+}
+
+// add validates a transaction against the confirmed state and everything already pending,
+// then queues it
+func (m *Mempool) add(tx Transaction, state *ledgerState) error {
+	if tx.IsCoinbase() {
+		return ErrCoinbaseNotAllowed
+	}
+	if !wallet.Verify(tx.Sender, tx.SigningData(), tx.Signature) {
+		return ErrInvalidSignature
+	}
+
+	switch state.model {
+	case UTXOModel:
+		for _, input := range tx.Inputs {
+			if id, ok := m.spent[OutPoint(input)]; ok {
+				return fmt.Errorf("%w: %s:%d is already spent by %s", ErrMempoolConflict, input.TxID, input.Index, id)
+			}
+		}
+		if _, err := state.utxo.CheckTransaction(tx); err != nil {
+			return err
+		}
+		if input, ok := immatureInput(tx, state.immatureCoinbases(state.height+1)); ok {
+			return fmt.Errorf("%w: %s:%d", ErrImmatureCoinbase, input.TxID, input.Index)
+		}
+	default:
+		if len(tx.Inputs) > 0 || len(tx.Outputs) > 0 {
+			return ErrInputsNotAllowed
+		}
+		if tx.Amount <= 0 || tx.Amount > maxAmount || tx.Fee < 0 || tx.Fee > maxAmount {
+			return ErrInvalidAmount
+		}
+		available := state.balance(tx.Sender) - m.outflows[tx.Sender]
+		if available < tx.Amount+tx.Fee {
+			return fmt.Errorf("%w: %s available after pending transactions, %s needed", ErrInsufficientBalance, available, tx.Amount+tx.Fee)
+		}
+		if spendable := state.spendable(tx.Sender) - m.outflows[tx.Sender]; spendable < tx.Amount+tx.Fee {
+			return fmt.Errorf("%w: %s spendable after pending transactions, %s needed", ErrImmatureCoinbase, spendable, tx.Amount+tx.Fee)
+		}
+		nonces := map[string]uint64{tx.Sender: m.nextNonce(tx.Sender, state)}
+		if err := checkNonce(nonces, tx); err != nil {
+			return err
+		}
+	}
+
+	m.insert(tx, state)
+	return nil
+}
+
+// insert queues a transaction without validating it
+func (m *Mempool) insert(tx Transaction, state *ledgerState) {
+	if m.outflows == nil {
+		m.outflows = make(map[string]Amount)
+		m.nonces = make(map[string]uint64)
+		m.spent = make(map[OutPoint]string)
+	}
+
+	m.txs = append(m.txs, tx)
+	if tx.IsCoinbase() {
+		return
+	}
+	m.outflows[tx.Sender] += tx.Amount + tx.Fee
+	if next := m.nextNonce(tx.Sender, state); tx.Nonce >= next {
+		m.nonces[tx.Sender] = tx.Nonce + 1
+	}
+	id := tx.Hash()
+	for _, input := range tx.Inputs {
+		m.spent[OutPoint(input)] = id
+	}
+}
+
+// revalidate checks every pending transaction again, in arrival order, against the given
+// confirmed state. Transactions that were mined meanwhile or no longer apply are evicted
+// and returned.
+func (m *Mempool) revalidate(state *ledgerState) []Transaction {
+	pending := m.txs
+	*m = Mempool{}
+
+	evicted := []Transaction{}
+	for _, tx := range pending {
+		if err := m.add(tx, state); err != nil {
+			evicted = append(evicted, tx)
+		}
+	}
+	return evicted
+}
@@ -0,0 +1,77 @@
+package blockchain
+
+import (
+	"blocklite/wallet"
+	"errors"
+	"os"
+	"testing"
+)
+
+// TestMempoolRejectsPendingOverspend checks that pending outflows count against the balance.
+func TestMempoolRejectsPendingOverspend(t *testing.T) {
+	os.Remove(BlockchainFile)
+	defer os.Remove(BlockchainFile)
+	defer mockMaturity(1)() // rewards are spent in the next block
+
+	bc := NewBlockChain()
+	w := wallet.NewWallet()
+	bc.CreateBlock(w.GetAddress())
+
+	first := signTransaction(t, w, Transaction{Sender: w.GetAddress(), Receiver: "B", Amount: 30 * Coin, Fee: Coin})
+	if _, err := bc.SubmitTransaction(first); err != nil {
+		t.Fatalf("SubmitTransaction() error = %v", err)
+	}
+	if got := bc.mempool.Outflow(w.GetAddress()); got != 31*Coin {
+		t.Errorf("Outflow() = %s; want 31", got)
+	}
+
+	// Each transaction is covered by the confirmed balance, but not both together
+	second := signedTransaction(t, w, "C", 20*Coin, 1)
+	if _, err := bc.SubmitTransaction(second); !errors.Is(err, ErrInsufficientBalance) {
+		t.Errorf("SubmitTransaction() error = %v; want %v", err, ErrInsufficientBalance)
+	}
+
+	third := signedTransaction(t, w, "C", 19*Coin, 1)
+	if _, err := bc.SubmitTransaction(third); err != nil {
+		t.Errorf("SubmitTransaction() error = %v", err)
+	}
+
+	block, err := bc.CreateBlock("miner")
+	if err != nil {
+		t.Fatalf("CreateBlock() error = %v", err)
+	}
+	if len(block.Transactions) != 3 {
+		t.Errorf("Block has %d transactions; want the coinbase and 2 transfers", len(block.Transactions))
+	}
+	if got := bc.GetBalance(w.GetAddress()); got != 0 {
+		t.Errorf("GetBalance() = %s; want 0", got)
+	}
+	if got := bc.mempool.Outflow(w.GetAddress()); got != 0 {
+		t.Errorf("Outflow() after mining = %s; want 0", got)
+	}
+}
+
+// TestNewBlockTemplateDropsInvalidTransactions checks that the mempool is re-checked when a block is assembled.
+func TestNewBlockTemplateDropsInvalidTransactions(t *testing.T) {
+	os.Remove(BlockchainFile)
+	defer os.Remove(BlockchainFile)
+	defer mockMaturity(1)() // rewards are spent in the next block
+
+	bc := NewBlockChain()
+	w := wallet.NewWallet()
+	bc.CreateBlock(w.GetAddress())
+
+	valid := signedTransaction(t, w, "B", Coin, 0)
+	if _, err := bc.SubmitTransaction(valid); err != nil {
+		t.Fatalf("SubmitTransaction() error = %v", err)
+	}
+	bc.AddTransaction("A", "B", Coin, "sig") // unsigned and unfunded
+
+	block := bc.NewBlockTemplate("miner")
+	if len(block.Transactions) != 2 || block.Transactions[1].Hash() != valid.Hash() {
+		t.Errorf("Template transactions = %+v; want the coinbase and the valid transfer", block.Transactions)
+	}
+	if bc.mempool.Len() != 1 {
+		t.Errorf("Mempool has %d transactions after assembly; want 1", bc.mempool.Len())
+	}
+}
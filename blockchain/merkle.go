@@ -0,0 +1,113 @@
+package blockchain
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+)
+
+// EmptyMerkleRoot is the Merkle root of a block without transactions.
+var EmptyMerkleRoot = strings.Repeat("0", 64)
+
+// MerkleRoot computes the Merkle root of the given transactions.
+// Leaves are the transaction hashes; every level with an odd number of nodes
+// pairs its last node with itself, as Bitcoin does.
+func MerkleRoot(txs []Transaction) string {
+	if len(txs) == 0 {
+		return EmptyMerkleRoot
+	}
+
+	level := make([][32]byte, len(txs))
+	for i, tx := range txs {
+		level[i] = tx.hashBytes()
+	}
+
+	for len(level) > 1 {
+		level = merkleLevel(level)
+	}
+
+	return hex.EncodeToString(level[0][:])
+}
+
+// merkleLevel hashes a level of the tree pairwise and returns the level above it.
+func merkleLevel(level [][32]byte) [][32]byte {
+	next := make([][32]byte, 0, (len(level)+1)/2)
+	for i := 0; i < len(level); i += 2 {
+		right := level[i]
+		if i+1 < len(level) {
+			right = level[i+1]
+		}
+		next = append(next, merkleParent(level[i], right))
+	}
+	return next
+}
+
+// merkleParent hashes two child nodes into their parent.
+func merkleParent(left, right [32]byte) [32]byte {
+	return sha256.Sum256(append(left[:], right[:]...))
+}
+
+// MerkleStep is one node on the path from a transaction up to the Merkle root
+type MerkleStep struct {
+	Hash string `json:"hash"`
+	Left bool   `json:"left"` // true when this sibling sits on the left of the running hash
+}
+
+// MerkleProof returns the branch proving that the transaction at the given position
+// is part of the Merkle tree built from txs.
+func MerkleProof(txs []Transaction, position int) ([]MerkleStep, bool) {
+	if position < 0 || position >= len(txs) {
+		return nil, false
+	}
+
+	level := make([][32]byte, len(txs))
+	for i, tx := range txs {
+		level[i] = tx.hashBytes()
+	}
+
+	proof := []MerkleStep{}
+	for len(level) > 1 {
+		sibling := position ^ 1
+		if sibling >= len(level) {
+			sibling = position // odd node is paired with itself
+		}
+		proof = append(proof, MerkleStep{
+			Hash: hex.EncodeToString(level[sibling][:]),
+			Left: sibling < position,
+		})
+
+		level = merkleLevel(level)
+		position /= 2
+	}
+
+	return proof, true
+}
+
+// VerifyMerkleProof checks that a transaction hash and its branch lead to the given Merkle root.
+// Light clients can use it with a block header alone, without downloading the block.
+func VerifyMerkleProof(txHash string, proof []MerkleStep, root string) bool {
+	decoded, err := hex.DecodeString(txHash)
+	if err != nil || len(decoded) != 32 {
+		return false
+	}
+
+	var current [32]byte
+	copy(current[:], decoded)
+
+	for _, step := range proof {
+		decoded, err := hex.DecodeString(step.Hash)
+		if err != nil || len(decoded) != 32 {
+			return false
+		}
+		var sibling [32]byte
+		copy(sibling[:], decoded)
+
+		if step.Left {
+			current = merkleParent(sibling, current)
+		} else {
+			current = merkleParent(current, sibling)
+		}
+	}
+
+	return hex.EncodeToString(current[:]) == root
+}
@@ -0,0 +1,69 @@
+package blockchain
+
+import (
+	"encoding/hex"
+	"testing"
+)
+
+// TestMerkleRoot checks the root for empty, single, even and odd sets of transactions.
+func TestMerkleRoot(t *testing.T) {
+	a := Transaction{Sender: "A", Receiver: "B", Amount: 1}
+	b := Transaction{Sender: "B", Receiver: "C", Amount: 2}
+	c := Transaction{Sender: "C", Receiver: "A", Amount: 3}
+
+	ab := merkleParent(a.hashBytes(), b.hashBytes())
+	cc := merkleParent(c.hashBytes(), c.hashBytes())
+	abcc := merkleParent(ab, cc)
+
+	tests := []struct {
+		name     string
+		txs      []Transaction
+		expected string
+	}{
+		{name: "No transactions", txs: nil, expected: EmptyMerkleRoot},
+		{name: "Single transaction", txs: []Transaction{a}, expected: a.Hash()},
+		{name: "Two transactions", txs: []Transaction{a, b}, expected: hex.EncodeToString(ab[:])},
+		{name: "Odd number of transactions", txs: []Transaction{a, b, c}, expected: hex.EncodeToString(abcc[:])},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := MerkleRoot(tt.txs); got != tt.expected {
+				t.Errorf("MerkleRoot() = %q; want %q", got, tt.expected)
+			}
+		})
+	}
+}
+
+// TestMerkleProof checks that a proof for every position verifies against the root
+// and that proofs do not verify for the wrong transaction or root.
+func TestMerkleProof(t *testing.T) {
+	txs := []Transaction{
+		{Sender: "A", Receiver: "B", Amount: 1},
+		{Sender: "B", Receiver: "C", Amount: 2},
+		{Sender: "C", Receiver: "D", Amount: 3},
+		{Sender: "D", Receiver: "E", Amount: 4},
+		{Sender: "E", Receiver: "A", Amount: 5},
+	}
+	root := MerkleRoot(txs)
+
+	for i, tx := range txs {
+		proof, ok := MerkleProof(txs, i)
+		if !ok {
+			t.Fatalf("MerkleProof(%d) failed", i)
+		}
+		if !VerifyMerkleProof(tx.Hash(), proof, root) {
+			t.Errorf("VerifyMerkleProof rejected a valid proof for position %d", i)
+		}
+		if VerifyMerkleProof(txs[(i+1)%len(txs)].Hash(), proof, root) {
+			t.Errorf("VerifyMerkleProof accepted the wrong transaction for position %d", i)
+		}
+		if VerifyMerkleProof(tx.Hash(), proof, EmptyMerkleRoot) {
+			t.Errorf("VerifyMerkleProof accepted the wrong root for position %d", i)
+		}
+	}
+
+	if _, ok := MerkleProof(txs, len(txs)); ok {
+		t.Error("MerkleProof succeeded for an out of range position")
+	}
+}
@@ -1,8 +1,14 @@
 package blockchain
 
 import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestRegisterNode(t *testing.T) {
@@ -25,19 +31,192 @@ func TestValidChain(t *testing.T) {
 	bc := NewBlockChain()
 	
 	// Create some blocks
-	proof1 := ProofOfWork(bc.Chain[0].Proof)
-	bc.CreateBlock(proof1, bc.Chain[0].CalculateHash())
+	bc.CreateBlock("miner")
 	
-	proof2 := ProofOfWork(bc.Chain[1].Proof)
-	bc.CreateBlock(proof2, bc.Chain[1].CalculateHash())
+	bc.CreateBlock("miner")
 	
-	if !bc.ValidChain(bc.Chain) {
-		t.Error("ValidChain failed for a valid chain")
+	if err := bc.ValidChain(bc.Chain); err != nil {
+		t.Errorf("ValidChain failed for a valid chain: %v", err)
 	}
 	
+	// Tamper with a mined transaction without redoing the work
+	bc.Chain[2].Transactions[0].Amount = 5000
+	if bc.ValidChain(bc.Chain) == nil {
+		t.Error("ValidChain passed for a chain with tampered transactions")
+	}
+	bc.Chain[2].Transactions[0].Amount = MiningReward
+
 	// Corrupt the chain
 	bc.Chain[1].PreviousHash = "corrupted"
-	if bc.ValidChain(bc.Chain) {
+	if bc.ValidChain(bc.Chain) == nil {
 		t.Error("ValidChain passed for a corrupted chain")
 	}
 }
+
+func TestValidChainRejectsWrongDifficulty(t *testing.T) {
+	os.Remove(BlockchainFile)
+	defer os.Remove(BlockchainFile)
+
+	bc := NewBlockChain()
+
+	// Mine a block that is easier than the chain demands
+	block := bc.NewBlockTemplate("miner")
+	block.Difficulty = InitialDifficulty - 4
+	block.Proof = ProofOfWork(block.Header())
+
+	if err := bc.AddBlock(block); !errors.Is(err, ErrInvalidBlock) {
+		t.Errorf("AddBlock() error = %v; want %v", err, ErrInvalidBlock)
+	}
+
+	bc.Chain = append(bc.Chain, block)
+
+	if bc.ValidChain(bc.Chain) == nil {
+		t.Error("ValidChain passed for a block with the wrong difficulty")
+	}
+}
+
+func TestHasMoreWork(t *testing.T) {
+	tests := []struct {
+		name     string
+		work     uint64
+		tip      string
+		bestWork uint64
+		bestTip  string
+		expected bool
+	}{
+		{name: "More work wins", work: 300, tip: "ff", bestWork: 200, bestTip: "00", expected: true},
+		{name: "Less work loses", work: 100, tip: "00", bestWork: 200, bestTip: "ff", expected: false},
+		{name: "Tie broken by lower tip hash", work: 200, tip: "0a", bestWork: 200, bestTip: "0b", expected: true},
+		{name: "Tie with higher tip hash loses", work: 200, tip: "0b", bestWork: 200, bestTip: "0a", expected: false},
+		{name: "Same tip is not better", work: 200, tip: "0a", bestWork: 200, bestTip: "0a", expected: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := hasMoreWork(tt.work, tt.tip, tt.bestWork, tt.bestTip); got != tt.expected {
+				t.Errorf("hasMoreWork() = %v; want %v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestResolveConflictsAdoptsChainWithMoreWork(t *testing.T) {
+	os.Remove(BlockchainFile)
+	defer os.Remove(BlockchainFile)
+
+	peer := NewBlockChain()
+	peer.CreateBlock("miner")
+
+	server := syncServer(peer, nil)
+	defer server.Close()
+
+	bc := &Blockchain{
+		Chain:               []Block{peer.Chain[0]},
+		Nodes:               map[string]bool{strings.TrimPrefix(server.URL, "http://"): true},
+	}
+
+	result := bc.ResolveConflicts(context.Background())
+	if !result.Replaced {
+		t.Fatal("ResolveConflicts did not adopt the chain with more work")
+	}
+	if result.LocalWork != BlockWork(InitialDifficulty) || result.RemoteWork != 2*BlockWork(InitialDifficulty) {
+		t.Errorf("ResolveConflicts work = %d/%d; want %d/%d", result.LocalWork, result.RemoteWork, BlockWork(InitialDifficulty), 2*BlockWork(InitialDifficulty))
+	}
+	if len(bc.Chain) != 2 {
+		t.Errorf("Chain length = %d; want 2", len(bc.Chain))
+	}
+}
+
+// TestResolveConflictsQueriesPeersConcurrently checks that a hung peer neither blocks
+// consensus nor the other peers, and that every peer gets an outcome.
+func TestResolveConflictsQueriesPeersConcurrently(t *testing.T) {
+	os.Remove(BlockchainFile)
+	defer os.Remove(BlockchainFile)
+
+	defer func(timeout time.Duration) { SyncTimeout = timeout }(SyncTimeout)
+	SyncTimeout = 200 * time.Millisecond
+
+	bc := NewBlockChain()
+	heavier := &Blockchain{Chain: append([]Block{}, bc.Chain...), Nodes: make(map[string]bool)}
+	heavier.CreateBlock("miner")
+	same := &Blockchain{Chain: append([]Block{}, bc.Chain...), Nodes: make(map[string]bool)}
+
+	release := make(chan struct{})
+	hung := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer hung.Close()
+	defer close(release)
+	garbage := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("not json"))
+	}))
+	defer garbage.Close()
+	servers := map[string]*httptest.Server{
+		OutcomeAdopted: syncServer(heavier, nil),
+		OutcomeShorter: syncServer(same, nil),
+		OutcomeTimeout: hung,
+		OutcomeInvalid: garbage,
+	}
+	want := map[string]string{"127.0.0.1:1": OutcomeUnreachable}
+	for outcome, server := range servers {
+		defer server.Close()
+		node := strings.TrimPrefix(server.URL, "http://")
+		bc.RegisterNode(node)
+		want[node] = outcome
+	}
+	bc.RegisterNode("127.0.0.1:1")
+
+	start := time.Now()
+	result := bc.ResolveConflicts(context.Background())
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("ResolveConflicts() took %v with a hung peer", elapsed)
+	}
+
+	if !result.Replaced || bc.GetLength() != 2 {
+		t.Errorf("ResolveConflicts() replaced = %v, length %d; want the heavier chain adopted", result.Replaced, bc.GetLength())
+	}
+	if len(result.Peers) != len(want) {
+		t.Fatalf("Peers = %+v; want %d outcomes", result.Peers, len(want))
+	}
+	for i, peer := range result.Peers {
+		if peer.Outcome != want[peer.Node] {
+			t.Errorf("Outcome of %s = %q (%s); want %q", peer.Node, peer.Outcome, peer.Error, want[peer.Node])
+		}
+		if i > 0 && result.Peers[i-1].Node > peer.Node {
+			t.Error("Peers are not ordered by address")
+		}
+	}
+	if score, _ := scoreOf(bc, strings.TrimPrefix(hung.URL, "http://")); score.Score != PenaltyTimeout {
+		t.Errorf("Score of the hung peer = %+v; want %d", score, PenaltyTimeout)
+	}
+	if score, _ := scoreOf(bc, strings.TrimPrefix(garbage.URL, "http://")); score.Score != PenaltyMalformed {
+		t.Errorf("Score of the garbage peer = %+v; want %d", score, PenaltyMalformed)
+	}
+
+	// Peers still busy when the caller gives up are canceled, not penalized
+	bc.PeerScores().Unban(strings.TrimPrefix(hung.URL, "http://"))
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	result = bc.ResolveConflicts(ctx)
+	for _, peer := range result.Peers {
+		if peer.Node == strings.TrimPrefix(hung.URL, "http://") && peer.Outcome != OutcomeCanceled {
+			t.Errorf("Outcome of the hung peer = %q; want %q", peer.Outcome, OutcomeCanceled)
+		}
+	}
+	if _, ok := scoreOf(bc, strings.TrimPrefix(hung.URL, "http://")); ok {
+		t.Error("Hung peer was penalized for our own cancellation")
+	}
+}
+
+// scoreOf returns the misbehavior record of a node
+func scoreOf(bc *Blockchain, node string) (PeerScore, bool) {
+	for _, score := range bc.PeerScores().Scores() {
+		if score.Address == node {
+			return score, true
+		}
+	}
+	return PeerScore{}, false
+}
@@ -0,0 +1,178 @@
+package blockchain
+
+import (
+	"errors"
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+// BanThreshold is the misbehavior score at which a peer is banned
+const BanThreshold = 100
+
+// Misbehavior penalties. Invalid data is banned on the spot, since an honest node validates
+// what it sends; malformed messages and timeouts may be accidents and take several strikes.
+const (
+	PenaltyInvalidBlock     = 100
+	PenaltyInvalidSignature = 100
+	PenaltyMalformed        = 25
+	PenaltyTimeout          = 10
+	PenaltyFutureBlock      = 10 // our clock may be the one that is off
+)
+
+// BanDuration is how long a banned peer is ignored
+var BanDuration = 24 * time.Hour
+
+// Peer errors
+var (
+	ErrPeerBanned = errors.New("peer is banned")
+	ErrMalformed  = errors.New("malformed message")
+)
+
+// PeerScore is the misbehavior record of a peer
+type PeerScore struct {
+	Address     string    `json:"address"`
+	Score       int       `json:"score"`
+	LastReason  string    `json:"last_reason,omitempty"`
+	BannedUntil time.Time `json:"banned_until,omitempty"`
+}
+
+// PeerScores tracks the misbehavior of peers and bans the ones that cross BanThreshold.
+// The zero value is ready to use.
+type PeerScores struct {
+	mux    sync.Mutex
+	scores map[string]*PeerScore
+}
+
+// PeerID identifies a peer in the scores and bans on every path, gossip, sync and the peer
+// protocol alike: the IP address its connection comes from, with the port of the address it
+// announces, which for a node with an HTTP API is the port of the API. The announced host
+// is not trusted. Without an announced port the IP address alone is used.
+func PeerID(remoteIP, announced string) string {
+	_, port, err := net.SplitHostPort(announced)
+	if err != nil || port == "" {
+		return remoteIP
+	}
+	return net.JoinHostPort(remoteIP, port)
+}
+
+// Penalty returns the misbehavior points an error caused by a peer is worth, and a reason.
+// Errors that are no fault of the peer, like a stale block or a refused connection, are worth 0.
+func Penalty(err error) (int, string) {
+	var netErr net.Error
+	switch {
+	case err == nil:
+		return 0, ""
+	case errors.Is(err, ErrFutureTimestamp):
+		return PenaltyFutureBlock, "block from the future"
+	case errors.Is(err, ErrInvalidSignature):
+		return PenaltyInvalidSignature, "invalid signature"
+	case errors.Is(err, ErrInvalidBlock), errors.Is(err, ErrInvalidHeaders):
+		return PenaltyInvalidBlock, "invalid block"
+	case errors.Is(err, ErrMalformed):
+		return PenaltyMalformed, "malformed message"
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return PenaltyTimeout, "timeout"
+	}
+	return 0, ""
+}
+
+// Punish adds the penalty of err to the score of a peer and reports whether the peer is banned
+func (s *PeerScores) Punish(address string, err error) bool {
+	points, reason := Penalty(err)
+	return s.Penalize(address, points, reason)
+}
+
+// Penalize adds points to the score of a peer. A peer reaching BanThreshold is banned for
+// BanDuration and starts from a clean score afterwards. It reports whether the peer is banned.
+func (s *PeerScores) Penalize(address string, points int, reason string) bool {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	if points <= 0 {
+		return s.banned(address)
+	}
+	if s.scores == nil {
+		s.scores = make(map[string]*PeerScore)
+	}
+	score, ok := s.scores[address]
+	if !ok || !score.BannedUntil.IsZero() && !timeNow().Before(score.BannedUntil) {
+		score = &PeerScore{Address: address}
+		s.scores[address] = score
+	}
+
+	score.Score += points
+	score.LastReason = reason
+	if score.Score >= BanThreshold && score.BannedUntil.IsZero() {
+		score.BannedUntil = timeNow().Add(BanDuration)
+	}
+	return s.banned(address)
+}
+
+// IsBanned reports whether a peer is currently banned
+func (s *PeerScores) IsBanned(address string) bool {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return s.banned(address)
+}
+
+// Scores returns the peers that misbehaved, ordered by address. Expired bans are dropped.
+func (s *PeerScores) Scores() []PeerScore {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	scores := []PeerScore{}
+	for address, score := range s.scores {
+		if !score.BannedUntil.IsZero() && !s.banned(address) {
+			delete(s.scores, address)
+			continue
+		}
+		scores = append(scores, *score)
+	}
+	sort.Slice(scores, func(i, j int) bool { return scores[i].Address < scores[j].Address })
+	return scores
+}
+
+// Bans returns the currently banned peers, ordered by address
+func (s *PeerScores) Bans() []PeerScore {
+	bans := []PeerScore{}
+	for _, score := range s.Scores() {
+		if !score.BannedUntil.IsZero() {
+			bans = append(bans, score)
+		}
+	}
+	return bans
+}
+
+// Unban clears the ban and the score of a peer and reports whether it was banned
+func (s *PeerScores) Unban(address string) bool {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	banned := s.banned(address)
+	delete(s.scores, address)
+	return banned
+}
+
+// ClearBans lifts every ban and returns how many there were
+func (s *PeerScores) ClearBans() int {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	cleared := 0
+	for address := range s.scores {
+		if s.banned(address) {
+			cleared++
+		}
+		if !s.scores[address].BannedUntil.IsZero() {
+			delete(s.scores, address)
+		}
+	}
+	return cleared
+}
+
+// banned reports whether a peer is banned right now. The caller must hold s.mux.
+func (s *PeerScores) banned(address string) bool {
+	score, ok := s.scores[address]
+	return ok && timeNow().Before(score.BannedUntil)
+}
@@ -0,0 +1,160 @@
+package blockchain
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// timeoutError is a network error that timed out
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestPenalty(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{"None", nil, 0},
+		{"Invalid block", &ValidationError{Rule: RuleProof, Err: errors.New("bad proof")}, PenaltyInvalidBlock},
+		{"Invalid headers", fmt.Errorf("%w: headers do not connect", ErrInvalidHeaders), PenaltyInvalidBlock},
+		{"Future block", &ValidationError{Rule: RuleTimestamp, Err: ErrFutureTimestamp}, PenaltyFutureBlock},
+		{"Bad signature", fmt.Errorf("transaction: %w", ErrInvalidSignature), PenaltyInvalidSignature},
+		{"Malformed", fmt.Errorf("%w: unexpected EOF", ErrMalformed), PenaltyMalformed},
+		{"Timeout", fmt.Errorf("GET: %w", timeoutError{}), PenaltyTimeout},
+		{"Stale block", ErrStaleBlock, 0},
+		{"Nonce too low", ErrNonceTooLow, 0},
+		{"Connection refused", errors.New("connection refused"), 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, _ := Penalty(tt.err); got != tt.want {
+				t.Errorf("Penalty(%v) = %d; want %d", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestPeerScoresBan bans a peer once it crosses the threshold, until the ban expires or is lifted.
+func TestPeerScoresBan(t *testing.T) {
+	now := time.Date(2025, 7, 6, 12, 0, 0, 0, time.UTC)
+	defer mockTime(now)()
+
+	var scores PeerScores
+	for i := 1; i < BanThreshold/PenaltyMalformed; i++ {
+		if scores.Punish("peer", ErrMalformed) {
+			t.Fatalf("Peer banned after %d malformed messages", i)
+		}
+	}
+	if !scores.Punish("peer", ErrMalformed) {
+		t.Fatalf("Peer not banned with score %d", BanThreshold)
+	}
+	if scores.Punish("other", ErrStaleBlock) || len(scores.Scores()) != 1 {
+		t.Errorf("Scores() = %+v; want only the misbehaving peer", scores.Scores())
+	}
+	if bans := scores.Bans(); len(bans) != 1 || !bans[0].BannedUntil.Equal(now.Add(BanDuration)) {
+		t.Errorf("Bans() = %+v; want peer banned for %v", bans, BanDuration)
+	}
+
+	// The ban expires and the peer starts over
+	defer mockTime(now.Add(BanDuration))()
+	if scores.IsBanned("peer") || len(scores.Bans()) != 0 {
+		t.Error("Ban did not expire")
+	}
+	scores.Punish("peer", timeoutError{})
+	if got := scores.Scores(); len(got) != 1 || got[0].Score != PenaltyTimeout {
+		t.Errorf("Scores() after the ban = %+v; want a fresh score of %d", got, PenaltyTimeout)
+	}
+
+	// Admins can lift bans
+	scores.Punish("a", ErrInvalidBlock)
+	scores.Punish("b", ErrInvalidSignature)
+	if !scores.Unban("a") || scores.Unban("a") || scores.IsBanned("a") {
+		t.Error("Unban() did not lift the ban of a")
+	}
+	if n := scores.ClearBans(); n != 1 || scores.IsBanned("b") {
+		t.Errorf("ClearBans() = %d; want 1", n)
+	}
+}
+
+// TestResolveConflictsBansGarbage stops asking a peer that keeps sending garbage.
+func TestResolveConflictsBansGarbage(t *testing.T) {
+	os.Remove(BlockchainFile)
+	defer os.Remove(BlockchainFile)
+
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Write([]byte(`{"headers": [`))
+	}))
+	defer server.Close()
+	node := strings.TrimPrefix(server.URL, "http://")
+
+	bc := NewBlockChain()
+	bc.RegisterNode(node)
+	for i := 0; i < BanThreshold/PenaltyMalformed+2; i++ {
+		bc.ResolveConflicts(context.Background())
+	}
+
+	if requests != BanThreshold/PenaltyMalformed {
+		t.Errorf("Peer was asked %d times; want %d before the ban", requests, BanThreshold/PenaltyMalformed)
+	}
+	if bans := bc.PeerScores().Bans(); len(bans) != 1 || bans[0].Address != node || bans[0].LastReason != "malformed message" {
+		t.Errorf("Bans() = %+v; want %s banned for malformed messages", bans, node)
+	}
+	if _, err := bc.SyncWithPeer(context.Background(), node); !errors.Is(err, ErrPeerBanned) {
+		t.Errorf("SyncWithPeer() error = %v; want %v", err, ErrPeerBanned)
+	}
+}
+
+func TestPeerID(t *testing.T) {
+	tests := []struct {
+		remoteIP, announced, want string
+	}{
+		{"10.0.0.1", "10.0.0.1:8080", "10.0.0.1:8080"},
+		{"10.0.0.1", "victim.example:8080", "10.0.0.1:8080"}, // the announced host is not trusted
+		{"127.0.0.1", "localhost:8081", "127.0.0.1:8081"},
+		{"::1", "localhost:8081", "[::1]:8081"},
+		{"10.0.0.1", "", "10.0.0.1"},
+	}
+
+	for _, tt := range tests {
+		if got := PeerID(tt.remoteIP, tt.announced); got != tt.want {
+			t.Errorf("PeerID(%q, %q) = %q; want %q", tt.remoteIP, tt.announced, got, tt.want)
+		}
+	}
+}
+
+// TestGossipBanAppliesToSync bans a node for a bad announcement; the ban keeps it out of the
+// nodes we sync and gossip with, while another node on the same host is unaffected
+func TestGossipBanAppliesToSync(t *testing.T) {
+	os.Remove(BlockchainFile)
+	defer os.Remove(BlockchainFile)
+
+	bc := NewBlockChain()
+	bc.RegisterNode("127.0.0.1:5001")
+	bc.RegisterNode("127.0.0.1:5002")
+	g := NewGossip(bc, "self")
+
+	block := bc.NewBlockTemplate("miner")
+	if err := g.HandleBlock(GossipMessage{From: "localhost:5001", Block: &block}, "127.0.0.1"); !errors.Is(err, ErrInvalidBlock) {
+		t.Fatalf("HandleBlock() error = %v; want %v", err, ErrInvalidBlock)
+	}
+	if nodes := bc.GetNodes(); len(nodes) != 1 || nodes[0] != "127.0.0.1:5002" {
+		t.Errorf("GetNodes() = %v; want only the other node on the host", nodes)
+	}
+	if _, err := bc.SyncWithPeer(context.Background(), "127.0.0.1:5001"); !errors.Is(err, ErrPeerBanned) {
+		t.Errorf("SyncWithPeer() error = %v; want %v", err, ErrPeerBanned)
+	}
+}
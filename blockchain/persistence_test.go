@@ -1,20 +1,25 @@
 package blockchain
 
 import (
+	"blocklite/wallet"
+	"errors"
 	"os"
 	"testing"
+	"time"
 )
 
 func TestPersistence(t *testing.T) {
 	filename := "test_blockchain.json"
 	defer os.Remove(filename)
+	defer mockMaturity(1)() // rewards are spent in the next block
 
 	bc := &Blockchain{
 		Chain: []Block{},
 	}
-	bc.CreateBlock(1, "0")
-	bc.AddTransaction("sender", "receiver", 100.0, "sig")
-	bc.CreateBlock(2, bc.Chain[0].CalculateHash())
+	w := wallet.NewWallet()
+	bc.CreateBlock(w.GetAddress())
+	bc.SubmitTransaction(signedTransaction(t, w, "receiver", 100, 0))
+	bc.CreateBlock("miner")
 
 	err := bc.Save(filename)
 	if err != nil {
@@ -31,7 +36,135 @@ func TestPersistence(t *testing.T) {
 		t.Errorf("Chain length mismatch: got %d, want %d", len(bc2.Chain), len(bc.Chain))
 	}
 
-	if bc2.Chain[1].Transactions[0].Amount != 100.0 {
+	if bc2.Chain[1].Transactions[1].Amount != 100.0 {
 		t.Errorf("Transaction data mismatch after loading")
 	}
 }
+
+// TestLoadFromFileRefusesLegacyFormat refuses a version 1 file without touching it, and a
+// node does not start from it.
+func TestLoadFromFileRefusesLegacyFormat(t *testing.T) {
+	os.Remove(BlockchainFile)
+	defer os.Remove(BlockchainFile)
+	defer os.Remove(BlockchainFile + ".v1.bak")
+
+	legacy := `[
+  {"Index": 1, "Timestamp": "2025-07-06T12:00:00Z", "Transactions": [], "Proof": 1, "PreviousHash": "0"},
+  {"Index": 2, "Timestamp": "2025-07-06T13:00:00Z", "Transactions": [
+    {"sender": "0", "receiver": "miner", "amount": 50},
+    {"sender": "A", "receiver": "B", "amount": 10.5}
+  ], "Proof": 93711, "PreviousHash": "abc"}
+]`
+	if err := os.WriteFile(BlockchainFile, []byte(legacy), 0644); err != nil {
+		t.Fatalf("Failed to write legacy file: %v", err)
+	}
+
+	bc := &Blockchain{}
+	if err := bc.LoadFromFile(BlockchainFile); !errors.Is(err, ErrLegacyChain) {
+		t.Errorf("LoadFromFile() error = %v; want %v", err, ErrLegacyChain)
+	}
+	if _, err := OpenBlockChain(DefaultGenesis()); !errors.Is(err, ErrLegacyChain) {
+		t.Errorf("OpenBlockChain() error = %v; want %v", err, ErrLegacyChain)
+	}
+
+	if data, err := os.ReadFile(BlockchainFile); err != nil || string(data) != legacy {
+		t.Errorf("Legacy file was rewritten: %v", err)
+	}
+	if _, err := os.Stat(BlockchainFile + ".v1.bak"); !os.IsNotExist(err) {
+		t.Errorf("Legacy backup written: %v", err)
+	}
+
+	// Once the file is removed, the node starts a new chain
+	os.Remove(BlockchainFile)
+	if bc, err := OpenBlockChain(DefaultGenesis()); err != nil || bc.GetLength() != 1 {
+		t.Errorf("OpenBlockChain() after removing the file error = %v; want a new chain", err)
+	}
+}
+
+// TestOpenBlockChainValidates refuses a saved chain that was tampered with
+func TestOpenBlockChainValidates(t *testing.T) {
+	os.Remove(BlockchainFile)
+	defer os.Remove(BlockchainFile)
+
+	bc, err := OpenBlockChain(DefaultGenesis())
+	if err != nil {
+		t.Fatalf("OpenBlockChain() error = %v without a file", err)
+	}
+	bc.CreateBlock("miner")
+	if _, err := OpenBlockChain(DefaultGenesis()); err != nil {
+		t.Fatalf("OpenBlockChain() error = %v for a valid file", err)
+	}
+
+	// Raise the reward of the saved coinbase
+	bc.Chain[1].Transactions[0].Amount *= 2
+	if err := bc.Save(BlockchainFile); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+	if _, err := OpenBlockChain(DefaultGenesis()); !errors.Is(err, ErrInvalidBlock) {
+		t.Errorf("OpenBlockChain() error = %v; want %v", err, ErrInvalidBlock)
+	}
+	if got := NewBlockChain().GetLength(); got != 1 {
+		t.Errorf("NewBlockChain() length = %d; want a new chain instead of the tampered one", got)
+	}
+}
+
+// TestGenesisIsDeterministic builds the same genesis block from the same settings on every
+// node, a different one from other settings, and refuses a saved chain of other settings
+func TestGenesisIsDeterministic(t *testing.T) {
+	os.Remove(BlockchainFile)
+	defer os.Remove(BlockchainFile)
+
+	first := newGenesisBlock(DefaultGenesis())
+	defer mockTime(time.Now().Add(time.Hour))()
+	if second := newGenesisBlock(Genesis{}); second.CalculateHash() != first.CalculateHash() {
+		t.Errorf("Genesis hashes differ for the same settings: %s and %s", first.CalculateHash(), second.CalculateHash())
+	}
+	other := newGenesisBlock(Genesis{ChainID: "other"})
+	if other.CalculateHash() == first.CalculateHash() {
+		t.Error("Genesis hash does not cover the genesis settings")
+	}
+
+	if _, err := OpenBlockChain(Genesis{ChainID: "other"}); err != nil {
+		t.Fatalf("OpenBlockChain() error = %v", err)
+	}
+	if _, err := OpenBlockChain(DefaultGenesis()); !errors.Is(err, ErrGenesisMismatch) {
+		t.Errorf("OpenBlockChain() error = %v; want %v", err, ErrGenesisMismatch)
+	}
+}
+
+// TestSaveWhileMining saves the chain while blocks are added and saved; the file always holds
+// a complete chain
+func TestSaveWhileMining(t *testing.T) {
+	os.Remove(BlockchainFile)
+	defer os.Remove(BlockchainFile)
+
+	bc := NewBlockChain()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for range 3 {
+			bc.CreateBlock("miner")
+		}
+	}()
+	for saving := true; saving; {
+		select {
+		case <-done:
+			saving = false
+		default:
+			if err := bc.Save(BlockchainFile); err != nil {
+				t.Fatalf("Save() error = %v", err)
+			}
+		}
+	}
+
+	loaded, err := OpenBlockChain(DefaultGenesis())
+	if err != nil {
+		t.Fatalf("OpenBlockChain() error = %v", err)
+	}
+	if loaded.GetLength() != 4 {
+		t.Errorf("Loaded length = %d; want 4", loaded.GetLength())
+	}
+	if _, err := os.Stat(BlockchainFile + ".tmp"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Temporary file left behind: %v", err)
+	}
+}
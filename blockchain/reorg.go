@@ -0,0 +1,123 @@
+package blockchain
+
+// ReorgEvent describes a switch of our chain to a competing branch
+type ReorgEvent struct {
+	Depth     int    `json:"depth"`      // number of our blocks that were disconnected
+	ForkIndex int    `json:"fork_index"` // index of the last block both branches share, 0 for none
+	OldTip    string `json:"old_tip"`
+	NewTip    string `json:"new_tip"`
+	Orphaned  int    `json:"orphaned"` // transactions of disconnected blocks returned to the mempool
+}
+
+// adoptChain reorganizes onto newChain if it still has more work than our chain, saves the
+// chain, notifies the OnTipChanged listeners, and the OnReorg listeners when blocks were
+// disconnected. It returns ErrStaleBlock when our chain has caught up meanwhile.
+func (bc *Blockchain) adoptChain(newChain []Block) (ReorgEvent, error) {
+	bc.mux.Lock()
+	if len(newChain) == 0 || len(bc.Chain) > 0 && !hasMoreWork(ChainWork(newChain), newChain[len(newChain)-1].CalculateHash(),
+		ChainWork(bc.Chain), bc.Chain[len(bc.Chain)-1].CalculateHash()) {
+		bc.mux.Unlock()
+		return ReorgEvent{}, ErrStaleBlock
+	}
+	event, err := bc.reorganize(newChain)
+	if err == nil {
+		bc.persist()
+	}
+	bc.mux.Unlock()
+	if err != nil {
+		return ReorgEvent{}, err
+	}
+
+	bc.notifyTipChanged(newChain[len(newChain)-1])
+	if event.Depth > 0 {
+		bc.notifyReorg(event)
+	}
+	return event, nil
+}
+
+// forkPoint returns the number of leading blocks two chains have in common
+func forkPoint(a, b []Block) int {
+	n := 0
+	for n < len(a) && n < len(b) && a[n].CalculateHash() == b[n].CalculateHash() {
+		n++
+	}
+	return n
+}
+
+// reorganize switches our chain to newChain. Our blocks past the fork point are disconnected
+// from the ledger, newest first, and the new ones are validated and connected. Transactions
+// of the disconnected blocks go back to the mempool in front of the pending ones, then the
+// whole mempool is checked again, which drops what the new blocks confirmed or invalidated.
+// The returned event has Depth 0 when newChain simply extends ours. If a new block is
+// invalid, or newChain starts from another genesis block, our chain is left unchanged.
+// The caller must hold bc.mux.
+func (bc *Blockchain) reorganize(newChain []Block) (ReorgEvent, error) {
+	state := bc.ledgerState()
+	fork := forkPoint(bc.Chain, newChain)
+	if fork == 0 && len(bc.Chain) > 0 {
+		return ReorgEvent{}, ErrGenesisMismatch
+	}
+
+	event := ReorgEvent{Depth: len(bc.Chain) - fork}
+	if fork > 0 {
+		event.ForkIndex = newChain[fork-1].Index
+	}
+	if len(bc.Chain) > 0 {
+		event.OldTip = bc.Chain[len(bc.Chain)-1].CalculateHash()
+	}
+	if len(newChain) > 0 {
+		event.NewTip = newChain[len(newChain)-1].CalculateHash()
+	}
+
+	orphaned := []Transaction{}
+	for _, block := range bc.Chain[fork:] {
+		for _, tx := range block.Transactions {
+			if !tx.IsCoinbase() {
+				orphaned = append(orphaned, tx)
+			}
+		}
+	}
+
+	for i := len(bc.Chain) - 1; i >= fork; i-- {
+		block := bc.Chain[i]
+		hash := block.CalculateHash()
+		state.disconnectBlock(block, bc.undo[hash])
+		delete(bc.undo, hash)
+		delete(bc.heights, hash)
+		for _, tx := range block.Transactions {
+			delete(bc.txIndex, tx.Hash())
+		}
+	}
+
+	for i := fork; i < len(newChain); i++ {
+		block := newChain[i]
+		var undo []SpentOutput
+		var err error
+		if i == 0 {
+			if err = checkGenesis(block); err == nil {
+				undo, err = state.connectBlock(block)
+			}
+		} else {
+			undo, err = checkBlock(newChain[:i], block, state, bc.emission(), bc.retarget())
+		}
+		if err != nil {
+			bc.rebuild() // back to our own chain
+			return ReorgEvent{}, err
+		}
+		if undo != nil {
+			bc.undo[block.CalculateHash()] = undo
+		}
+		bc.indexBlock(block)
+	}
+	bc.Chain = newChain
+
+	bc.mempool.txs = append(orphaned, bc.mempool.txs...)
+	bc.mempool.revalidate(state)
+	for _, tx := range orphaned {
+		if _, ok := bc.mempool.find(tx.Hash()); ok {
+			event.Orphaned++
+		}
+	}
+
+	return event, nil
+}
@@ -0,0 +1,84 @@
+package blockchain
+
+import (
+	"blocklite/wallet"
+	"errors"
+	"os"
+	"reflect"
+	"testing"
+)
+
+// TestReorganize switches to a heavier branch and checks the ledger, the index and the mempool.
+func TestReorganize(t *testing.T) {
+	os.Remove(BlockchainFile)
+	defer os.Remove(BlockchainFile)
+	defer mockMaturity(1)() // rewards are spent in the next block
+
+	bc := NewBlockChain()
+	w, v := wallet.NewWallet(), wallet.NewWallet()
+	bc.CreateBlock(w.GetAddress())
+	bc.CreateBlock(v.GetAddress())
+	other := &Blockchain{Chain: append([]Block{}, bc.Chain...), Nodes: make(map[string]bool)}
+
+	// Our branch confirms tx1 while tx2 is still pending
+	tx1 := signedTransaction(t, w, "X", 10*Coin, 0)
+	tx2 := signedTransaction(t, v, "Y", 20*Coin, 0)
+	bc.SubmitTransaction(tx1)
+	bc.CreateBlock("miner")
+	bc.SubmitTransaction(tx2)
+
+	// The competing branch confirms tx2 and is one block longer
+	other.SubmitTransaction(tx2)
+	other.CreateBlock("other-miner")
+	other.CreateBlock("other-miner")
+
+	var events []ReorgEvent
+	bc.OnReorg(func(event ReorgEvent) { events = append(events, event) })
+
+	event, err := bc.adoptChain(other.Chain)
+	if err != nil {
+		t.Fatalf("adoptChain() error = %v", err)
+	}
+
+	if event.Depth != 1 || event.ForkIndex != 3 || event.Orphaned != 1 {
+		t.Errorf("Reorg = %+v; want depth 1 from block 3 with 1 orphan", event)
+	}
+	if event.NewTip != other.Chain[len(other.Chain)-1].CalculateHash() {
+		t.Errorf("NewTip = %s; want the tip of the other branch", event.NewTip)
+	}
+	if _, err := bc.adoptChain(other.Chain[:len(other.Chain)-1]); !errors.Is(err, ErrStaleBlock) {
+		t.Errorf("adoptChain() of a lighter chain error = %v; want %v", err, ErrStaleBlock)
+	}
+	if len(events) != 1 || events[0] != event {
+		t.Errorf("OnReorg listener got %+v; want one %+v", events, event)
+	}
+
+	if status := bc.GetTransactionStatus(tx1.Hash()); status.Status != TxStatusPending {
+		t.Errorf("Orphaned transaction status = %q; want %q", status.Status, TxStatusPending)
+	}
+	if status := bc.GetTransactionStatus(tx2.Hash()); status.Status != TxStatusConfirmed {
+		t.Errorf("Confirmed transaction status = %q; want %q", status.Status, TxStatusConfirmed)
+	}
+	if n := len(bc.PendingTransactions()); n != 1 {
+		t.Errorf("Pending transactions = %d; want 1", n)
+	}
+	if got := bc.GetBalance("X"); got != 0 {
+		t.Errorf("GetBalance(X) = %s; want 0", got)
+	}
+	if got := bc.GetBalance("Y"); got != 20*Coin {
+		t.Errorf("GetBalance(Y) = %s; want 20", got)
+	}
+
+	// The incrementally updated state must match a replay from genesis
+	state := bc.state
+	bc.reindex()
+	if !reflect.DeepEqual(state.nonces, bc.state.nonces) || bc.balance(w.GetAddress()) != state.balance(w.GetAddress()) {
+		t.Error("Ledger state after reorg differs from a full replay")
+	}
+
+	// tx1 is still valid on the new branch and gets mined again
+	block, err := bc.CreateBlock("miner")
+	if err != nil || len(block.Transactions) != 2 || block.Transactions[1].Hash() != tx1.Hash() {
+		t.Errorf("CreateBlock() = %+v, %v; want the orphaned transaction mined", block.Transactions, err)
+	}
+}
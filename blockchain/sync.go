@@ -0,0 +1,346 @@
+package blockchain
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Headers-first sync limits
+var (
+	MaxHeadersPerRequest = 500
+	MaxBlocksPerRequest  = 50
+	MaxSyncResponseSize  = int64(16 << 20) // bytes read from a single response
+)
+
+// Sync bounds. MaxSyncHeaders is what a sync can download well within SyncDeadline: four
+// pages of headers and forty batches of blocks, each allowed SyncTimeout.
+var (
+	SyncTimeout    = 10 * time.Second // bound on every request to a peer during sync
+	SyncDeadline   = 2 * time.Minute  // bound on a whole sync with a peer
+	MaxSyncHeaders = 2_000            // headers taken from a peer in one sync; the rest waits for the next one
+)
+
+// ErrInvalidHeaders is returned when a peer sends headers that do not form a valid chain
+// from a block we know
+var ErrInvalidHeaders = errors.New("invalid headers")
+
+// ErrPeerChainChanged is returned when a peer switched branches while we synced with it: a
+// later page of headers no longer follows the previous one, or blocks we saw headers of are
+// gone. An honest peer can do so, so it is not penalized.
+var ErrPeerChainChanged = errors.New("peer chain changed during sync")
+
+// SyncResult describes the outcome of SyncWithPeer
+type SyncResult struct {
+	Headers      int         `json:"headers"`       // headers received
+	Blocks       int         `json:"blocks"`        // block bodies downloaded
+	RemoteWork   uint64      `json:"remote_work"`   // work of the peer's chain, 0 when it had nothing new
+	RemoteHeight int         `json:"remote_height"` // height of the peer's chain, 0 when it had nothing new
+	Adopted      bool        `json:"adopted"`
+	Reorg        *ReorgEvent `json:"reorg,omitempty"`
+}
+
+// BlockLocator returns hashes of our chain from the tip backwards: the last ten blocks, then
+// exponentially sparser ones, always ending with the genesis block. A peer looks for the
+// first hash it knows to find our common ancestor.
+func (bc *Blockchain) BlockLocator() []string {
+	bc.mux.Lock()
+	defer bc.mux.Unlock()
+	return blockLocator(bc.Chain)
+}
+
+// blockLocator builds the locator of a chain
+func blockLocator(chain []Block) []string {
+	locator := []string{}
+	step := 1
+	i := len(chain) - 1
+	for ; i > 0; i -= step {
+		locator = append(locator, chain[i].CalculateHash())
+		if len(locator) >= 10 {
+			step *= 2
+		}
+	}
+	if len(chain) > 0 {
+		locator = append(locator, chain[0].CalculateHash())
+	}
+	return locator
+}
+
+// HeadersAfter returns up to max headers following the first block of locator we know.
+// When we know none of them the headers start at our genesis block.
+func (bc *Blockchain) HeadersAfter(locator []string, max int) []BlockHeader {
+	bc.mux.Lock()
+	defer bc.mux.Unlock()
+
+	start := 0
+	for _, hash := range locator {
+		if position, ok := bc.blockHeights()[hash]; ok {
+			start = position + 1
+			break
+		}
+	}
+
+	headers := []BlockHeader{}
+	for i := start; i < len(bc.Chain) && len(headers) < max; i++ {
+		headers = append(headers, bc.Chain[i].Header())
+	}
+	return headers
+}
+
+// GetBlocksByHash returns the blocks of our chain with the given hashes, in the same order.
+// Unknown hashes are skipped.
+func (bc *Blockchain) GetBlocksByHash(hashes []string) []Block {
+	bc.mux.Lock()
+	defer bc.mux.Unlock()
+
+	blocks := []Block{}
+	for _, hash := range hashes {
+		if position, ok := bc.blockHeights()[hash]; ok {
+			blocks = append(blocks, bc.Chain[position])
+		}
+	}
+	return blocks
+}
+
+// blockHeights returns the block hash index. The caller must hold bc.mux.
+func (bc *Blockchain) blockHeights() map[string]int {
+	if bc.heights == nil {
+		bc.rebuild()
+	}
+	return bc.heights
+}
+
+// SyncWithPeer catches up with a peer headers first: it sends our block locator, checks the
+// headers the peer returns after our common ancestor, and only if they carry more work than
+// our chain downloads the missing blocks in batches, reorganizing onto them as soon as they
+// carry more work. At most MaxSyncHeaders headers are taken, a longer chain is caught up
+// with over several syncs. Every request must be answered within SyncTimeout and the whole
+// sync stops after SyncDeadline, or when ctx is done, keeping the blocks adopted so far.
+// A peer sending invalid or malformed data, or timing out on a request, is penalized; one
+// that switched branches during the sync, or was still busy at the deadline, is not.
+// Banned peers are not contacted.
+func (bc *Blockchain) SyncWithPeer(ctx context.Context, node string) (SyncResult, error) {
+	if bc.peers.IsBanned(node) {
+		return SyncResult{}, ErrPeerBanned
+	}
+	deadline, cancel := context.WithTimeout(ctx, SyncDeadline)
+	defer cancel()
+	result, err := bc.syncWithPeer(deadline, node)
+	if deadline.Err() == nil {
+		bc.peers.Punish(node, err) // not when we gave up ourselves
+	}
+	return result, err
+}
+
+// syncWithPeer runs the headers-first sync with a peer
+func (bc *Blockchain) syncWithPeer(ctx context.Context, node string) (SyncResult, error) {
+	bc.mux.Lock()
+	ours := append([]Block{}, bc.Chain...)
+	heights := make(map[string]int, len(bc.blockHeights()))
+	for hash, position := range bc.blockHeights() {
+		heights[hash] = position
+	}
+	bc.mux.Unlock()
+
+	result := SyncResult{}
+
+	// Step 1 and 2: exchange the locator and collect the headers after the common ancestor
+	headers := []BlockHeader{}
+	locator := blockLocator(ours)
+	for {
+		page, err := fetchHeaders(ctx, node, locator)
+		if err != nil {
+			return result, err
+		}
+		if len(headers) > 0 && len(page) > 0 && page[0].PreviousHash != locator[0] {
+			return result, fmt.Errorf("%w: headers no longer follow block %s", ErrPeerChainChanged, locator[0])
+		}
+		headers = append(headers, page...)
+		if len(headers) >= MaxSyncHeaders {
+			headers = headers[:MaxSyncHeaders]
+			break
+		}
+		if len(page) < MaxHeadersPerRequest {
+			break
+		}
+		last := page[len(page)-1].Hash()
+		locator = []string{hex.EncodeToString(last[:])}
+	}
+	result.Headers = len(headers)
+	if len(headers) == 0 {
+		return result, nil
+	}
+
+	// Our locator ends with our genesis block, so headers from the start mean another genesis
+	if headers[0].Index == 1 && len(ours) > 0 {
+		return result, fmt.Errorf("%w: the peer has another genesis block", ErrGenesisMismatch)
+	}
+	fork := 0
+	if headers[0].Index > 1 {
+		position, ok := heights[headers[0].PreviousHash]
+		if !ok {
+			return result, fmt.Errorf("%w: headers do not connect to our chain", ErrInvalidHeaders)
+		}
+		fork = position + 1
+	}
+	candidate, err := connectHeaders(ours[:fork], headers, bc.retarget())
+	if err != nil {
+		return result, err
+	}
+	result.RemoteWork = ChainWork(candidate)
+	result.RemoteHeight = len(candidate)
+
+	tip, ourTip := candidate[len(candidate)-1].CalculateHash(), ""
+	if len(ours) > 0 {
+		ourTip = ours[len(ours)-1].CalculateHash()
+	}
+	if !hasMoreWork(result.RemoteWork, tip, ChainWork(ours), ourTip) {
+		return result, nil
+	}
+
+	// Step 3: download the bodies we are missing
+	newChain := append([]Block{}, ours[:fork]...)
+	for start := fork; start < len(candidate); start += MaxBlocksPerRequest {
+		end := min(start+MaxBlocksPerRequest, len(candidate))
+		hashes := make([]string, 0, end-start)
+		for _, stub := range candidate[start:end] {
+			hashes = append(hashes, stub.CalculateHash())
+		}
+
+		blocks, err := fetchBlocks(ctx, node, hashes)
+		if err != nil {
+			return result, err
+		}
+		// The peer leaves out the blocks it no longer has, the others must match their headers
+		next := 0
+		for _, block := range blocks {
+			for next < len(hashes) && hashes[next] != block.CalculateHash() {
+				next++
+			}
+			if next == len(hashes) {
+				return result, fmt.Errorf("%w: block %d does not match a header we asked for", ErrInvalidHeaders, block.Index)
+			}
+			next++
+		}
+		if len(blocks) < len(hashes) {
+			return result, fmt.Errorf("%w: asked for %d blocks, got %d", ErrPeerChainChanged, len(hashes), len(blocks))
+		}
+		newChain = append(newChain, blocks...)
+		result.Blocks += len(blocks)
+
+		// Adopting every batch that carries more work keeps the progress of a sync cut
+		// short. The new blocks are fully validated while we reorganize onto them.
+		event, err := bc.adoptChain(newChain)
+		switch {
+		case errors.Is(err, ErrStaleBlock) && (end < len(candidate) || result.Adopted):
+			continue // not enough work yet, or our chain moved on meanwhile
+		case err != nil:
+			return result, err
+		}
+		result.Adopted = true
+		if event.Depth > 0 {
+			result.Reorg = &event
+		}
+	}
+	return result, nil
+}
+
+// connectHeaders checks that headers follow prefix: indices, hashes, difficulty under the
+// retarget schedule and proof of work. It returns prefix followed by header-only blocks
+// carrying their chain work.
+func connectHeaders(prefix []Block, headers []BlockHeader, retarget Retarget) ([]Block, error) {
+	chain := append([]Block{}, prefix...)
+	for _, header := range headers {
+		block := Block{
+			Index:        header.Index,
+			Timestamp:    header.Timestamp,
+			Proof:        header.Nonce,
+			PreviousHash: header.PreviousHash,
+			Difficulty:   header.Difficulty,
+			MerkleRoot:   header.MerkleRoot,
+		}
+		block.ChainWork = ChainWork(chain) + BlockWork(block.Difficulty)
+
+		var err error
+		if len(chain) == 0 {
+			err = checkGenesis(block)
+		} else {
+			err = checkLinkage(chain, block, retarget)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrInvalidHeaders, err)
+		}
+		chain = append(chain, block)
+	}
+	return chain, nil
+}
+
+// fetchHeaders asks a peer for the headers after our locator
+func fetchHeaders(ctx context.Context, node string, locator []string) ([]BlockHeader, error) {
+	query := url.Values{}
+	query.Set("locator", strings.Join(locator, ","))
+	query.Set("max", strconv.Itoa(MaxHeadersPerRequest))
+
+	var response struct {
+		Headers []BlockHeader `json:"headers"`
+	}
+	if err := getJSON(ctx, "http://"+node+"/api/sync/headers?"+query.Encode(), &response); err != nil {
+		return nil, err
+	}
+	if len(response.Headers) > MaxHeadersPerRequest {
+		return nil, fmt.Errorf("%w: %d headers in one response", ErrInvalidHeaders, len(response.Headers))
+	}
+	return response.Headers, nil
+}
+
+// fetchBlocks asks a peer for the blocks with the given hashes
+func fetchBlocks(ctx context.Context, node string, hashes []string) ([]Block, error) {
+	query := url.Values{}
+	query.Set("hashes", strings.Join(hashes, ","))
+
+	var response struct {
+		Blocks []Block `json:"blocks"`
+	}
+	if err := getJSON(ctx, "http://"+node+"/api/sync/blocks?"+query.Encode(), &response); err != nil {
+		return nil, err
+	}
+	return response.Blocks, nil
+}
+
+// getJSON fetches a URL and decodes its JSON body, of at most MaxSyncResponseSize bytes,
+// into v within SyncTimeout
+func getJSON(ctx context.Context, address string, v any) error {
+	ctx, cancel := context.WithTimeout(ctx, SyncTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, address, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", address, resp.Status)
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, MaxSyncResponseSize)).Decode(v); err != nil {
+		var netErr net.Error
+		if errors.As(err, &netErr) || ctx.Err() != nil {
+			return err // the connection failed or timed out, not the peer's encoding
+		}
+		return fmt.Errorf("%w: GET %s: %w", ErrMalformed, address, err)
+	}
+	return nil
+}
@@ -0,0 +1,233 @@
+package blockchain
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// syncServer serves the headers-first sync endpoints of peer and counts the blocks it sends
+func syncServer(peer *Blockchain, blocksServed *int) *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/sync/headers", func(w http.ResponseWriter, r *http.Request) {
+		locator := strings.Split(r.URL.Query().Get("locator"), ",")
+		json.NewEncoder(w).Encode(map[string]interface{}{"headers": peer.HeadersAfter(locator, MaxHeadersPerRequest)})
+	})
+	mux.HandleFunc("/api/sync/blocks", func(w http.ResponseWriter, r *http.Request) {
+		blocks := peer.GetBlocksByHash(strings.Split(r.URL.Query().Get("hashes"), ","))
+		if blocksServed != nil {
+			*blocksServed += len(blocks)
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"blocks": blocks})
+	})
+	return httptest.NewServer(mux)
+}
+
+// TestBlockLocator checks that the locator starts at the tip, thins out and ends at genesis.
+func TestBlockLocator(t *testing.T) {
+	chain := spacedChain(100, InitialDifficulty, time.Second)
+	locator := blockLocator(chain)
+
+	if locator[0] != chain[99].CalculateHash() || locator[len(locator)-1] != chain[0].CalculateHash() {
+		t.Error("Locator does not run from the tip to the genesis block")
+	}
+	if locator[9] != chain[90].CalculateHash() {
+		t.Error("Locator does not hold the last ten blocks")
+	}
+	if len(locator) > 20 {
+		t.Errorf("Locator has %d hashes for 100 blocks; want it to thin out", len(locator))
+	}
+}
+
+// TestSyncWithPeerDownloadsOnlyMissingBlocks syncs from a peer that is two blocks ahead.
+func TestSyncWithPeerDownloadsOnlyMissingBlocks(t *testing.T) {
+	os.Remove(BlockchainFile)
+	defer os.Remove(BlockchainFile)
+
+	peer := NewBlockChain()
+	peer.CreateBlock("miner")
+	peer.CreateBlock("miner")
+	bc := &Blockchain{Chain: append([]Block{}, peer.Chain...), Nodes: make(map[string]bool)}
+	peer.CreateBlock("miner")
+	peer.CreateBlock("miner")
+
+	served := 0
+	server := syncServer(peer, &served)
+	defer server.Close()
+
+	result, err := bc.SyncWithPeer(context.Background(), strings.TrimPrefix(server.URL, "http://"))
+	if err != nil {
+		t.Fatalf("SyncWithPeer() error = %v", err)
+	}
+	if !result.Adopted || result.Headers != 2 || result.Blocks != 2 || served != 2 {
+		t.Errorf("SyncWithPeer() = %+v with %d blocks served; want 2 headers and 2 blocks", result, served)
+	}
+	if bc.GetLength() != 5 || bc.Chain[4].CalculateHash() != peer.Chain[4].CalculateHash() {
+		t.Errorf("Chain length = %d; want the 5 blocks of the peer", bc.GetLength())
+	}
+
+	// Once in sync there is nothing left to download
+	result, err = bc.SyncWithPeer(context.Background(), strings.TrimPrefix(server.URL, "http://"))
+	if err != nil || result.Headers != 0 || result.Adopted {
+		t.Errorf("SyncWithPeer() when in sync = %+v, %v; want nothing to do", result, err)
+	}
+}
+
+// TestSyncWithPeerThatReorgs syncs with peers that switch branches between two pages of
+// headers, and between the headers and the blocks. The sync fails without a penalty.
+func TestSyncWithPeerThatReorgs(t *testing.T) {
+	os.Remove(BlockchainFile)
+	defer os.Remove(BlockchainFile)
+
+	peer := NewBlockChain()
+	bc := &Blockchain{Chain: append([]Block{}, peer.Chain...), Nodes: make(map[string]bool)}
+	reorged := &Blockchain{Chain: append([]Block{}, peer.Chain...), Nodes: make(map[string]bool)}
+	peer.CreateBlock("miner")
+	peer.CreateBlock("miner")
+	reorged.CreateBlock("other")
+
+	// A full first page of headers, then a second one from another branch
+	page := make([]BlockHeader, MaxHeadersPerRequest)
+	for i := range page {
+		page[i] = BlockHeader{Index: i + 2, PreviousHash: "some block"}
+	}
+	last := page[len(page)-1].Hash()
+	paging := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		headers := page
+		if r.URL.Query().Get("locator") == hex.EncodeToString(last[:]) {
+			headers = []BlockHeader{{Index: 502, PreviousHash: "another block"}}
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"headers": headers})
+	}))
+	defer paging.Close()
+
+	// The headers of one branch, then the blocks of another
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/sync/headers", func(w http.ResponseWriter, r *http.Request) {
+		locator := strings.Split(r.URL.Query().Get("locator"), ",")
+		json.NewEncoder(w).Encode(map[string]interface{}{"headers": peer.HeadersAfter(locator, MaxHeadersPerRequest)})
+	})
+	mux.HandleFunc("/api/sync/blocks", func(w http.ResponseWriter, r *http.Request) {
+		blocks := reorged.GetBlocksByHash(strings.Split(r.URL.Query().Get("hashes"), ","))
+		json.NewEncoder(w).Encode(map[string]interface{}{"blocks": blocks})
+	})
+	bodies := httptest.NewServer(mux)
+	defer bodies.Close()
+
+	for _, server := range []*httptest.Server{paging, bodies} {
+		node := strings.TrimPrefix(server.URL, "http://")
+		if _, err := bc.SyncWithPeer(context.Background(), node); !errors.Is(err, ErrPeerChainChanged) {
+			t.Errorf("SyncWithPeer() error = %v; want %v", err, ErrPeerChainChanged)
+		}
+		if scores := bc.PeerScores().Scores(); len(scores) != 0 {
+			t.Errorf("Scores() = %+v; want no penalty for a peer that switched branches", scores)
+		}
+	}
+	if bc.GetLength() != 1 {
+		t.Errorf("Chain length = %d; want our chain unchanged", bc.GetLength())
+	}
+}
+
+// TestSyncWithPeerDeadline gives up on a peer that takes longer than SyncDeadline in total,
+// even though it answers every request in time. The deadline is ours, the peer is not penalized.
+func TestSyncWithPeerDeadline(t *testing.T) {
+	os.Remove(BlockchainFile)
+	defer os.Remove(BlockchainFile)
+	defer func(deadline time.Duration) { SyncDeadline = deadline }(SyncDeadline)
+	SyncDeadline = 100 * time.Millisecond
+
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(time.Second):
+		}
+	}))
+	defer slow.Close()
+	node := strings.TrimPrefix(slow.URL, "http://")
+
+	bc := NewBlockChain()
+	start := time.Now()
+	if _, err := bc.SyncWithPeer(context.Background(), node); err == nil {
+		t.Fatal("SyncWithPeer() error = nil for a slow peer")
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("SyncWithPeer() took %s; want it to stop at the deadline", elapsed)
+	}
+	if scores := bc.PeerScores().Scores(); len(scores) != 0 {
+		t.Errorf("Scores() = %+v; want no penalty for our own deadline", scores)
+	}
+}
+
+// TestSyncWithPeerKeepsProgress adopts the blocks downloaded before the deadline.
+func TestSyncWithPeerKeepsProgress(t *testing.T) {
+	os.Remove(BlockchainFile)
+	defer os.Remove(BlockchainFile)
+	defer func(deadline time.Duration, batch int) {
+		SyncDeadline, MaxBlocksPerRequest = deadline, batch
+	}(SyncDeadline, MaxBlocksPerRequest)
+	SyncDeadline, MaxBlocksPerRequest = 200*time.Millisecond, 1
+
+	peer := NewBlockChain()
+	bc := &Blockchain{Chain: append([]Block{}, peer.Chain...), Nodes: make(map[string]bool)}
+	for range 3 {
+		peer.CreateBlock("miner")
+	}
+
+	// The peer serves the first batch of blocks, then stalls
+	batches := 0
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/sync/headers", func(w http.ResponseWriter, r *http.Request) {
+		locator := strings.Split(r.URL.Query().Get("locator"), ",")
+		json.NewEncoder(w).Encode(map[string]interface{}{"headers": peer.HeadersAfter(locator, MaxHeadersPerRequest)})
+	})
+	mux.HandleFunc("/api/sync/blocks", func(w http.ResponseWriter, r *http.Request) {
+		if batches++; batches > 1 {
+			<-r.Context().Done()
+			return
+		}
+		blocks := peer.GetBlocksByHash(strings.Split(r.URL.Query().Get("hashes"), ","))
+		json.NewEncoder(w).Encode(map[string]interface{}{"blocks": blocks})
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	result, err := bc.SyncWithPeer(context.Background(), strings.TrimPrefix(server.URL, "http://"))
+	if err == nil {
+		t.Fatal("SyncWithPeer() error = nil for a stalled peer")
+	}
+	if !result.Adopted || bc.GetLength() != 2 {
+		t.Errorf("SyncWithPeer() = %+v with length %d; want the first block adopted", result, bc.GetLength())
+	}
+}
+
+// TestSyncWithPeerCapsHeaders catches up with a long chain over several syncs.
+func TestSyncWithPeerCapsHeaders(t *testing.T) {
+	os.Remove(BlockchainFile)
+	defer os.Remove(BlockchainFile)
+	defer func(limit int) { MaxSyncHeaders = limit }(MaxSyncHeaders)
+	MaxSyncHeaders = 2
+
+	peer := NewBlockChain()
+	bc := &Blockchain{Chain: append([]Block{}, peer.Chain...), Nodes: make(map[string]bool)}
+	for range 3 {
+		peer.CreateBlock("miner")
+	}
+	server := syncServer(peer, nil)
+	defer server.Close()
+	node := strings.TrimPrefix(server.URL, "http://")
+
+	// Two of the three new headers, then the last one
+	for _, want := range []struct{ headers, length int }{{2, 3}, {1, 4}} {
+		result, err := bc.SyncWithPeer(context.Background(), node)
+		if err != nil || result.Headers != want.headers || bc.GetLength() != want.length {
+			t.Errorf("SyncWithPeer() = %+v, %v with length %d; want %d headers and length %d", result, err, bc.GetLength(), want.headers, want.length)
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package blockchain
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// SyncStatus describes the background sync
+type SyncStatus struct {
+	Running        bool      `json:"running"`
+	Syncing        bool      `json:"syncing"`          // a round is in progress
+	Height         int       `json:"height"`           // height of our chain
+	BestPeerHeight int       `json:"best_peer_height"` // highest chain a peer reported, at least our own
+	Progress       float64   `json:"progress"`         // Height / BestPeerHeight, 1 when caught up
+	Peers          int       `json:"peers"`            // peers that answered the last round
+	Rounds         int       `json:"rounds"`
+	Failures       int       `json:"failures"` // rounds in a row in which no peer answered
+	LastSync       time.Time `json:"last_sync,omitempty"`
+	NextSync       time.Time `json:"next_sync,omitempty"`
+	LastError      string    `json:"last_error,omitempty"`
+}
+
+// Syncer keeps a blockchain at the tip of the network by running ResolveConflicts in the
+// background every interval. When no peer answers, it waits twice as long before the next
+// round, up to maxBackoff.
+type Syncer struct {
+	bc         *Blockchain
+	interval   time.Duration
+	maxBackoff time.Duration
+
+	mux    sync.Mutex
+	status SyncStatus
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// NewSyncer creates a syncer for bc; Start runs it
+func NewSyncer(bc *Blockchain, interval, maxBackoff time.Duration) *Syncer {
+	if maxBackoff < interval {
+		maxBackoff = interval
+	}
+	return &Syncer{bc: bc, interval: interval, maxBackoff: maxBackoff}
+}
+
+// Start runs the sync loop in the background until Stop is called. The first round starts right away.
+func (s *Syncer) Start() {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if s.cancel != nil {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s.cancel, s.done = cancel, make(chan struct{})
+	s.status.Running = true
+	go s.run(ctx, s.done)
+}
+
+// Stop ends the sync loop, cancelling a round in progress, and waits for it to return
+func (s *Syncer) Stop() {
+	s.mux.Lock()
+	cancel, done := s.cancel, s.done
+	s.cancel = nil
+	s.mux.Unlock()
+	if cancel == nil {
+		return
+	}
+
+	cancel()
+	<-done
+
+	s.mux.Lock()
+	s.status.Running, s.status.Syncing, s.status.NextSync = false, false, time.Time{}
+	s.mux.Unlock()
+}
+
+// Status returns the state of the background sync
+func (s *Syncer) Status() SyncStatus {
+	s.mux.Lock()
+	status := s.status
+	s.mux.Unlock()
+
+	status.Height = s.bc.GetLength()
+	status.BestPeerHeight = max(status.BestPeerHeight, status.Height)
+	status.Progress = float64(status.Height) / float64(status.BestPeerHeight)
+	return status
+}
+
+// run syncs every interval, backing off while no peer answers
+func (s *Syncer) run(ctx context.Context, done chan struct{}) {
+	defer close(done)
+
+	for {
+		wait := s.round(ctx)
+
+		s.mux.Lock()
+		s.status.NextSync = time.Now().Add(wait)
+		s.mux.Unlock()
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+	}
+}
+
+// round runs ResolveConflicts once and returns how long to wait before the next round
+func (s *Syncer) round(ctx context.Context) time.Duration {
+	s.mux.Lock()
+	s.status.Syncing = true
+	s.mux.Unlock()
+
+	result := s.bc.ResolveConflicts(ctx)
+
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.status.Syncing = false
+	s.status.Rounds++
+	s.status.LastSync = time.Now()
+	s.status.Peers, s.status.BestPeerHeight, s.status.LastError = 0, 0, ""
+	for _, peer := range result.Peers {
+		switch peer.Outcome {
+		case OutcomeAdopted, OutcomeShorter:
+			s.status.Peers++
+			s.status.BestPeerHeight = max(s.status.BestPeerHeight, peer.Height)
+		default:
+			s.status.LastError = peer.Node + ": " + peer.Outcome
+			if peer.Error != "" {
+				s.status.LastError += ": " + peer.Error
+			}
+		}
+	}
+
+	if len(result.Peers) == 0 || s.status.Peers > 0 {
+		s.status.Failures = 0
+		return s.interval
+	}
+	s.status.Failures++
+	wait := s.interval
+	for i := 0; i < s.status.Failures && wait < s.maxBackoff; i++ {
+		wait *= 2
+	}
+	return min(wait, s.maxBackoff)
+}
@@ -0,0 +1,110 @@
+package blockchain
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestSyncerCatchesUp starts the background sync against a peer that is ahead of us.
+func TestSyncerCatchesUp(t *testing.T) {
+	os.Remove(BlockchainFile)
+	defer os.Remove(BlockchainFile)
+
+	peer := NewBlockChain()
+	bc := &Blockchain{Chain: append([]Block{}, peer.Chain...), Nodes: make(map[string]bool)}
+	peer.CreateBlock("miner")
+	peer.CreateBlock("miner")
+	server := syncServer(peer, nil)
+	defer server.Close()
+	bc.RegisterNode(strings.TrimPrefix(server.URL, "http://"))
+
+	syncer := NewSyncer(bc, 10*time.Millisecond, time.Second)
+	syncer.Start()
+	defer syncer.Stop()
+
+	// The round that fetched the blocks may still be finishing once they are in
+	caughtUp := func() bool {
+		status := syncer.Status()
+		return bc.GetLength() >= 3 && status.Rounds > 0
+	}
+	for deadline := time.Now().Add(5 * time.Second); !caughtUp() && time.Now().Before(deadline); {
+		time.Sleep(5 * time.Millisecond)
+	}
+	status := syncer.Status()
+	if !status.Running || status.Height != 3 || status.Progress != 1 || status.Rounds == 0 {
+		t.Errorf("Status() = %+v; want a running sync at height 3 fully caught up", status)
+	}
+
+	// The peer moves on and the syncer follows it
+	peer.CreateBlock("miner")
+	for deadline := time.Now().Add(5 * time.Second); bc.GetLength() < 4 && time.Now().Before(deadline); {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if bc.GetLength() != 4 {
+		t.Errorf("GetLength() = %d; want 4 after the peer mined another block", bc.GetLength())
+	}
+}
+
+// TestSyncerBacksOff waits longer between rounds while no peer answers.
+func TestSyncerBacksOff(t *testing.T) {
+	os.Remove(BlockchainFile)
+	defer os.Remove(BlockchainFile)
+
+	bc := NewBlockChain()
+	syncer := NewSyncer(bc, time.Second, 5*time.Second)
+
+	// Without peers there is nothing to back off from
+	if wait := syncer.round(t.Context()); wait != time.Second {
+		t.Errorf("round() without peers = %v; want %v", wait, time.Second)
+	}
+
+	bc.RegisterNode("127.0.0.1:1")
+	for _, want := range []time.Duration{2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second} {
+		if wait := syncer.round(t.Context()); wait != want {
+			t.Errorf("round() with no answering peer = %v; want %v", wait, want)
+		}
+	}
+	if status := syncer.Status(); status.Failures != 4 || status.LastError == "" {
+		t.Errorf("Status() = %+v; want 4 failures and the last error", status)
+	}
+}
+
+// TestSyncerStopCancelsRound stops the syncer while a peer does not answer.
+func TestSyncerStopCancelsRound(t *testing.T) {
+	os.Remove(BlockchainFile)
+	defer os.Remove(BlockchainFile)
+
+	release := make(chan struct{})
+	hung := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer hung.Close()
+	defer close(release)
+
+	bc := NewBlockChain()
+	bc.RegisterNode(strings.TrimPrefix(hung.URL, "http://"))
+	syncer := NewSyncer(bc, time.Minute, time.Minute)
+	syncer.Start()
+	for deadline := time.Now().Add(time.Second); !syncer.Status().Syncing && time.Now().Before(deadline); {
+		time.Sleep(time.Millisecond)
+	}
+
+	start := time.Now()
+	syncer.Stop()
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Stop() took %v", elapsed)
+	}
+	if status := syncer.Status(); status.Running || status.Syncing {
+		t.Errorf("Status() after Stop() = %+v; want stopped", status)
+	}
+	if bc.PeerScores().IsBanned(strings.TrimPrefix(hung.URL, "http://")) || len(bc.PeerScores().Scores()) != 0 {
+		t.Errorf("Scores() = %+v; want no penalty for a round we cancelled", bc.PeerScores().Scores())
+	}
+}
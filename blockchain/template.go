@@ -0,0 +1,162 @@
+package blockchain
+
+import (
+	"container/heap"
+	"errors"
+	"fmt"
+	"math/bits"
+	"time"
+)
+
+// Block limits, the coinbase included
+const (
+	MaxBlockTransactions = 100
+	MaxBlockSize         = 64 * 1024 // bytes of canonically encoded transactions
+)
+
+// ErrBlockTooLarge is returned for blocks over MaxBlockTransactions or MaxBlockSize
+var ErrBlockTooLarge = errors.New("block exceeds size limits")
+
+// NewBlockTemplate builds the next block on top of the current tip. Pending transactions
+// are validated again, then picked by fee rate, highest first, until the block is full,
+// and a coinbase paying the block reward plus their fees to miner is put in front of them.
+// The returned block still needs a valid Proof before it can be added.
+func (bc *Blockchain) NewBlockTemplate(miner string) Block {
+	bc.mux.Lock()
+	defer bc.mux.Unlock()
+
+	previousHash := "0"
+	if len(bc.Chain) > 0 {
+		previousHash = bc.Chain[len(bc.Chain)-1].CalculateHash()
+	}
+
+	// Everything pending is checked again against the tip; what no longer applies is dropped
+	bc.mempool.revalidate(bc.ledgerState())
+
+	coinbase := Transaction{Sender: "0", Receiver: miner, Nonce: uint64(len(bc.Chain) + 1)}
+	transactions := selectTransactions(bc.mempool.txs, MaxBlockTransactions-1, MaxBlockSize-coinbaseSize(coinbase))
+
+	coinbase.Amount = bc.emission().Reward(len(bc.Chain)+1, bc.ledgerState().supply)
+	for _, tx := range transactions {
+		coinbase.Amount += tx.Fee
+	}
+	transactions = append([]Transaction{coinbase}, transactions...)
+
+	// Our chain passed checkLinkage block by block, so its timestamps parse
+	difficulty, _ := bc.retarget().NextDifficulty(bc.Chain)
+
+	// Timestamps must be after the median time past, also for blocks mined within the same second
+	timestamp := timeNow().UTC().Truncate(time.Second)
+	if len(bc.Chain) > 0 {
+		if median, err := medianTimePast(bc.Chain); err == nil && !timestamp.After(median) {
+			timestamp = median.Add(time.Second)
+		}
+	}
+
+	return Block{
+		Index: len(bc.Chain) + 1,
+		// Timestamp: time.Now().String(),
+		Timestamp:    timestamp.Format(time.RFC3339),
+		Transactions: transactions,
+		PreviousHash: previousHash,
+		Difficulty:   difficulty,
+		MerkleRoot:   MerkleRoot(transactions),
+		ChainWork:    ChainWork(bc.Chain) + BlockWork(difficulty),
+	}
+}
+
+// coinbaseSize returns an upper bound for the encoded size of the coinbase once its amount is known
+func coinbaseSize(coinbase Transaction) int {
+	coinbase.Amount = maxAmount
+	return coinbase.Size()
+}
+
+// selectTransactions picks pending transactions by fee rate within the given limits.
+// Transactions from the same sender keep their order, so nonces are never skipped: only
+// the first remaining transaction of each sender competes at any time.
+func selectTransactions(pending []Transaction, maxCount, maxSize int) []Transaction {
+	queues := make(map[string][]Transaction)
+	senders := []string{}
+	for _, tx := range pending {
+		if tx.IsCoinbase() {
+			continue // coinbase transactions are only created by the template
+		}
+		if _, ok := queues[tx.Sender]; !ok {
+			senders = append(senders, tx.Sender)
+		}
+		queues[tx.Sender] = append(queues[tx.Sender], tx)
+	}
+
+	candidates := &feeHeap{}
+	for order, sender := range senders {
+		heap.Push(candidates, feeCandidate{tx: queues[sender][0], order: order})
+		queues[sender] = queues[sender][1:]
+	}
+
+	selected := []Transaction{}
+	size := 0
+	for candidates.Len() > 0 && len(selected) < maxCount {
+		best := heap.Pop(candidates).(feeCandidate)
+		if size+best.tx.Size() > maxSize {
+			continue // the rest of this sender's transactions depend on this one
+		}
+		selected = append(selected, best.tx)
+		size += best.tx.Size()
+
+		if next := queues[best.tx.Sender]; len(next) > 0 {
+			heap.Push(candidates, feeCandidate{tx: next[0], order: best.order})
+			queues[best.tx.Sender] = next[1:]
+		}
+	}
+	return selected
+}
+
+// checkBlockLimits verifies the transaction count and size limits of a block
+func checkBlockLimits(block Block) error {
+	if len(block.Transactions) > MaxBlockTransactions {
+		return fmt.Errorf("%w: %d transactions, at most %d", ErrBlockTooLarge, len(block.Transactions), MaxBlockTransactions)
+	}
+	size := 0
+	for _, tx := range block.Transactions {
+		size += tx.Size()
+	}
+	if size > MaxBlockSize {
+		return fmt.Errorf("%w: %d bytes, at most %d", ErrBlockTooLarge, size, MaxBlockSize)
+	}
+	return nil
+}
+
+// feeCandidate is a transaction waiting in the fee priority queue
+type feeCandidate struct {
+	tx    Transaction
+	order int // arrival order of the sender, used to break ties
+}
+
+// feeHeap orders candidates by fee rate, highest first
+type feeHeap []feeCandidate
+
+func (h feeHeap) Len() int { return len(h) }
+
+func (h feeHeap) Less(i, j int) bool {
+	// Compare fee_i/size_i with fee_j/size_j without dividing, on 128 bit products
+	leftHi, leftLo := bits.Mul64(uint64(h[i].tx.Fee), uint64(h[j].tx.Size()))
+	rightHi, rightLo := bits.Mul64(uint64(h[j].tx.Fee), uint64(h[i].tx.Size()))
+	if leftHi != rightHi {
+		return leftHi > rightHi
+	}
+	if leftLo != rightLo {
+		return leftLo > rightLo
+	}
+	return h[i].order < h[j].order
+}
+
+func (h feeHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+
+func (h *feeHeap) Push(x any) { *h = append(*h, x.(feeCandidate)) }
+
+func (h *feeHeap) Pop() any {
+	old := *h
+	last := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return last
+}
@@ -0,0 +1,75 @@
+package blockchain
+
+import (
+	"blocklite/wallet"
+	"os"
+	"testing"
+)
+
+// TestSelectTransactions checks fee rate ordering, per-sender nonce order and the count limit.
+func TestSelectTransactions(t *testing.T) {
+	pending := []Transaction{
+		{Sender: "A", Receiver: "X", Amount: 1, Fee: 10, Nonce: 0},
+		{Sender: "A", Receiver: "X", Amount: 1, Fee: 5000, Nonce: 1},
+		{Sender: "B", Receiver: "X", Amount: 1, Fee: 3000, Nonce: 0},
+		{Sender: "C", Receiver: "X", Amount: 1, Fee: 0, Nonce: 0},
+		{Sender: "0", Receiver: "X", Amount: 1},
+	}
+
+	got := selectTransactions(pending, 10, MaxBlockSize)
+	order := []string{}
+	for _, tx := range got {
+		order = append(order, tx.Sender+string(rune('0'+tx.Nonce)))
+	}
+
+	// B pays the best rate available at first; A's rich second transaction has to wait for A's first
+	expected := []string{"B0", "A0", "A1", "C0"}
+	if len(order) != len(expected) {
+		t.Fatalf("selectTransactions() = %v; want %v", order, expected)
+	}
+	for i := range expected {
+		if order[i] != expected[i] {
+			t.Fatalf("selectTransactions() = %v; want %v", order, expected)
+		}
+	}
+
+	if got := selectTransactions(pending, 2, MaxBlockSize); len(got) != 2 {
+		t.Errorf("selectTransactions() with a limit of 2 = %d transactions", len(got))
+	}
+}
+
+// TestCreateBlockPaysFeesToMiner checks that the coinbase collects the reward plus the fees.
+func TestCreateBlockPaysFeesToMiner(t *testing.T) {
+	os.Remove(BlockchainFile)
+	defer os.Remove(BlockchainFile)
+	defer mockMaturity(1)() // rewards are spent in the next block
+
+	bc := NewBlockChain()
+	a, c := wallet.NewWallet(), wallet.NewWallet()
+	bc.CreateBlock(a.GetAddress())
+	bc.CreateBlock(c.GetAddress())
+
+	for _, tx := range []Transaction{
+		signTransaction(t, a, Transaction{Sender: a.GetAddress(), Receiver: "B", Amount: Coin, Fee: 3 * Coin / 100}),
+		signTransaction(t, c, Transaction{Sender: c.GetAddress(), Receiver: "D", Amount: Coin, Fee: 2 * Coin / 100}),
+	} {
+		if _, err := bc.SubmitTransaction(tx); err != nil {
+			t.Fatalf("SubmitTransaction() error = %v", err)
+		}
+	}
+
+	block, err := bc.CreateBlock("miner")
+	if err != nil {
+		t.Fatalf("CreateBlock() error = %v", err)
+	}
+
+	if fees := block.TotalFees(); fees != 5*Coin/100 {
+		t.Errorf("TotalFees() = %s; want 0.05", fees)
+	}
+	if reward := block.Transactions[0].Amount; reward != MiningReward+5*Coin/100 {
+		t.Errorf("Coinbase amount = %s; want 50.05", reward)
+	}
+	if balance := bc.GetBalance(a.GetAddress()); balance != MiningReward-(Coin+3*Coin/100) {
+		t.Errorf("GetBalance(A) = %s; want 48.97", balance)
+	}
+}
@@ -0,0 +1,141 @@
+package blockchain
+
+import (
+	"blocklite/utils"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+// Transaction statuses reported by GetTransactionStatus
+const (
+	TxStatusPending   = "pending"
+	TxStatusConfirmed = "confirmed"
+	TxStatusUnknown   = "unknown"
+)
+
+// Errors returned when a transaction is rejected
+var (
+	ErrCoinbaseNotAllowed  = errors.New("coinbase transactions are created by miners")
+	ErrInvalidSignature    = errors.New("invalid signature")
+	ErrInsufficientBalance = errors.New("insufficient balance")
+	ErrNonceTooLow         = errors.New("nonce already used")
+	ErrNonceTooHigh        = errors.New("nonce out of order")
+	ErrInputsNotAllowed    = errors.New("inputs and outputs are only allowed on a UTXO chain")
+)
+
+// Transaction represents a transfer of value.
+// Nonce counts the transactions sent by Sender, starting at 0; it is part of the signed
+// data so that a signed transaction cannot be replayed.
+// On a UTXO chain a transaction spends Inputs and creates Outputs instead; Receiver and
+// Amount, when set without Outputs, stand for a single output.
+// Fee is paid by the sender on top of Amount (account model) or is the difference between
+// inputs and outputs (UTXO model), and goes to the miner of the block.
+type Transaction struct {
+	Sender    string     `json:"sender"`
+	Receiver  string     `json:"receiver"`
+	Amount    Amount     `json:"amount"`
+	Fee       Amount     `json:"fee"`
+	Nonce     uint64     `json:"nonce"`
+	Inputs    []TxInput  `json:"inputs,omitempty"`
+	Outputs   []TxOutput `json:"outputs,omitempty"`
+	Signature string     `json:"signature,omitempty"`
+}
+
+// TxLocation points at a confirmed transaction: the index of its block and its position in it
+type TxLocation struct {
+	BlockIndex int `json:"block_index"`
+	Position   int `json:"position"`
+}
+
+// TransactionStatus is what a node knows about a transaction ID
+type TransactionStatus struct {
+	ID            string       `json:"id"`
+	Status        string       `json:"status"`
+	Location      *TxLocation  `json:"location,omitempty"`
+	Confirmations int          `json:"confirmations,omitempty"`
+	Transaction   *Transaction `json:"transaction,omitempty"`
+}
+
+// Hash Return the transaction ID, the hex encoded SHA-256 hash of its canonical encoding
+func (tx Transaction) Hash() string {
+	hashedData := tx.hashBytes()
+	return hex.EncodeToString(hashedData[:])
+}
+
+func (tx Transaction) hashBytes() [32]byte {
+	return utils.SHA256(tx.canonical())
+}
+
+// SigningData Return the data the sender signs: a JSON array of the sender, receiver,
+// amount, fee and nonce, e.g. ["<sender>","<receiver>","10.5","0.001","0"]. UTXO transactions append
+// the JSON encoding of their inputs and outputs as two more strings.
+func (tx Transaction) SigningData() string {
+	data, _ := json.Marshal(tx.signedFields())
+	return string(data)
+}
+
+// Size Return the size in bytes of the canonical encoding, used for fee rates and block limits
+func (tx Transaction) Size() int {
+	return len(tx.canonical())
+}
+
+// IsCoinbase reports whether the transaction mints new coins
+func (tx Transaction) IsCoinbase() bool {
+	return tx.Sender == "0"
+}
+
+// canonical returns an unambiguous encoding of the transaction. Plain concatenation would let
+// "ab"+"c" and "a"+"bc" collide, so every field is encoded as a separate JSON string.
+func (tx Transaction) canonical() string {
+	data, _ := json.Marshal(append(tx.signedFields(), tx.Signature))
+	return string(data)
+}
+
+func (tx Transaction) signedFields() []string {
+	fields := []string{
+		tx.Sender,
+		tx.Receiver,
+		tx.Amount.String(),
+		tx.Fee.String(),
+		strconv.FormatUint(tx.Nonce, 10),
+	}
+	if len(tx.Inputs) > 0 || len(tx.Outputs) > 0 {
+		inputs, _ := json.Marshal(tx.Inputs)
+		outputs, _ := json.Marshal(tx.Outputs)
+		fields = append(fields, string(inputs), string(outputs))
+	}
+	return fields
+}
+
+// outputs returns the outputs created by the transaction on a UTXO chain. A coinbase paying
+// nothing, once the supply is exhausted and its block has no fees, creates none.
+func (tx Transaction) outputs() []TxOutput {
+	if len(tx.Outputs) == 0 && tx.Receiver != "" {
+		if tx.IsCoinbase() && tx.Amount == 0 {
+			return nil
+		}
+		return []TxOutput{{Address: tx.Receiver, Amount: tx.Amount}}
+	}
+	return tx.Outputs
+}
+
+// checkNonce verifies that the transaction uses the sender's next nonce and advances it
+func checkNonce(nonces map[string]uint64, tx Transaction) error {
+	if tx.IsCoinbase() {
+		return nil
+	}
+
+	expected := nonces[tx.Sender]
+	switch {
+	case tx.Nonce < expected:
+		return fmt.Errorf("%w: nonce %d, next nonce for %s is %d", ErrNonceTooLow, tx.Nonce, tx.Sender, expected)
+	case tx.Nonce > expected:
+		return fmt.Errorf("%w: nonce %d, next nonce for %s is %d", ErrNonceTooHigh, tx.Nonce, tx.Sender, expected)
+	}
+
+	nonces[tx.Sender] = expected + 1
+	return nil
+}
@@ -1,6 +1,8 @@
 package blockchain
 
 import (
+	"blocklite/wallet"
+	"errors"
 	"os"
 	"reflect"
 	"testing"
@@ -15,7 +17,7 @@ func TestAddTransaction(t *testing.T) {
 	
 	sender := "address1"
 	receiver := "address2"
-	amount := 50.0
+	amount := 50 * Coin
 	signature := "dummy_signature"
 
 	index := bc.AddTransaction(sender, receiver, amount, signature)
@@ -24,8 +26,8 @@ func TestAddTransaction(t *testing.T) {
 		t.Errorf("Expected next block index to be 2, got %d", index)
 	}
 
-	if len(bc.CurrentTransactions) != 1 {
-		t.Errorf("Expected 1 pending transaction, got %d", len(bc.CurrentTransactions))
+	if bc.mempool.Len() != 1 {
+		t.Errorf("Expected 1 pending transaction, got %d", bc.mempool.Len())
 	}
 
 	expectedTx := Transaction{
@@ -35,8 +37,8 @@ func TestAddTransaction(t *testing.T) {
 		Signature: signature,
 	}
 
-	if !reflect.DeepEqual(bc.CurrentTransactions[0], expectedTx) {
-		t.Errorf("Transaction mismatch. Got %+v, want %+v", bc.CurrentTransactions[0], expectedTx)
+	if !reflect.DeepEqual(bc.PendingTransactions()[0], expectedTx) {
+		t.Errorf("Transaction mismatch. Got %+v, want %+v", bc.PendingTransactions()[0], expectedTx)
 	}
 }
 
@@ -44,27 +46,189 @@ func TestMinedTransactions(t *testing.T) {
 	// Clean up before and after test
 	os.Remove(BlockchainFile)
 	defer os.Remove(BlockchainFile)
+	defer mockMaturity(1)() // rewards are spent in the next block
 	
 	bc := NewBlockChain()
+	a, c := wallet.NewWallet(), wallet.NewWallet()
+	bc.CreateBlock(a.GetAddress())
+	bc.CreateBlock(c.GetAddress())
+
+	for _, tx := range []Transaction{signedTransaction(t, a, "B", 10*Coin, 0), signedTransaction(t, c, "D", 20*Coin, 0)} {
+		if _, err := bc.SubmitTransaction(tx); err != nil {
+			t.Fatalf("SubmitTransaction() error = %v", err)
+		}
+	}
 	
-	bc.AddTransaction("A", "B", 10.0, "sig1")
-	bc.AddTransaction("C", "D", 20.0, "sig2")
-	
-	latestBlock := bc.GetLatestBlock()
-	proof := ProofOfWork(latestBlock.Proof)
-	previousHash := latestBlock.CalculateHash()
-	
-	newBlock := bc.CreateBlock(proof, previousHash)
+	newBlock, _ := bc.CreateBlock("miner")
 	
-	if len(newBlock.Transactions) != 2 {
-		t.Errorf("Expected 2 transactions in the new block, got %d", len(newBlock.Transactions))
+	if len(newBlock.Transactions) != 3 {
+		t.Errorf("Expected a coinbase and 2 transactions in the new block, got %d", len(newBlock.Transactions))
 	}
 	
-	if len(bc.CurrentTransactions) != 0 {
-		t.Errorf("Expected mempool to be empty after mining, got %d", len(bc.CurrentTransactions))
+	if bc.mempool.Len() != 0 {
+		t.Errorf("Expected mempool to be empty after mining, got %d", bc.mempool.Len())
 	}
 	
-	if newBlock.Transactions[0].Sender != "A" || newBlock.Transactions[1].Sender != "C" {
+	if newBlock.Transactions[1].Sender != a.GetAddress() || newBlock.Transactions[2].Sender != c.GetAddress() {
 		t.Errorf("Transactions order or data mismatch in block")
 	}
 }
+
+func TestTransactionHashIsUnambiguous(t *testing.T) {
+	a := Transaction{Sender: "ab", Receiver: "c", Amount: 1}
+	b := Transaction{Sender: "a", Receiver: "bc", Amount: 1}
+
+	if a.Hash() == b.Hash() {
+		t.Error("Transactions with shifted field boundaries share the same hash")
+	}
+}
+
+func TestGetTransactionStatus(t *testing.T) {
+	os.Remove(BlockchainFile)
+	defer os.Remove(BlockchainFile)
+	defer mockMaturity(1)() // rewards are spent in the next block
+
+	bc := NewBlockChain()
+	w := wallet.NewWallet()
+	bc.CreateBlock(w.GetAddress())
+
+	first := signedTransaction(t, w, "B", 10*Coin, 0)
+	bc.SubmitTransaction(first)
+	confirmed := first.Hash()
+	bc.CreateBlock("miner")
+	bc.CreateBlock("miner")
+
+	second := signedTransaction(t, w, "D", 20*Coin, 1)
+	bc.SubmitTransaction(second)
+	pending := second.Hash()
+
+	status := bc.GetTransactionStatus(confirmed)
+	if status.Status != TxStatusConfirmed {
+		t.Fatalf("Status = %q; want %q", status.Status, TxStatusConfirmed)
+	}
+	if status.Location.BlockIndex != 3 || status.Location.Position != 1 {
+		t.Errorf("Location = %+v; want block 3, position 1", *status.Location)
+	}
+	if status.Confirmations != 2 {
+		t.Errorf("Confirmations = %d; want 2", status.Confirmations)
+	}
+
+	if status := bc.GetTransactionStatus(pending); status.Status != TxStatusPending {
+		t.Errorf("Status = %q; want %q", status.Status, TxStatusPending)
+	}
+
+	if status := bc.GetTransactionStatus("missing"); status.Status != TxStatusUnknown {
+		t.Errorf("Status = %q; want %q", status.Status, TxStatusUnknown)
+	}
+}
+
+// signedTransaction builds a transaction from w signed with its private key
+func signedTransaction(t *testing.T, w *wallet.Wallet, receiver string, amount Amount, nonce uint64) Transaction {
+	t.Helper()
+	return signTransaction(t, w, Transaction{Sender: w.GetAddress(), Receiver: receiver, Amount: amount, Nonce: nonce})
+}
+
+// signTransaction signs tx with the private key of w
+func signTransaction(t *testing.T, w *wallet.Wallet, tx Transaction) Transaction {
+	t.Helper()
+	signature, err := wallet.Sign(w.PrivateKey, tx.SigningData())
+	if err != nil {
+		t.Fatalf("Failed to sign: %v", err)
+	}
+	tx.Signature = signature
+	return tx
+}
+
+// mineBlock mines the next block of bc with the given transactions behind the coinbase,
+// bypassing the mempool, without adding it
+func mineBlock(t *testing.T, bc *Blockchain, txs ...Transaction) Block {
+	t.Helper()
+	block := bc.NewBlockTemplate("miner")
+	coinbase := block.Transactions[0]
+	for _, tx := range txs {
+		coinbase.Amount += tx.Fee
+	}
+	block.Transactions = append([]Transaction{coinbase}, txs...)
+	block.MerkleRoot = MerkleRoot(block.Transactions)
+	block.Proof = ProofOfWork(block.Header())
+	return block
+}
+
+func TestSubmitTransactionNonces(t *testing.T) {
+	os.Remove(BlockchainFile)
+	defer os.Remove(BlockchainFile)
+	defer mockMaturity(1)() // rewards are spent in the next block
+
+	bc := NewBlockChain()
+	w := wallet.NewWallet()
+	bc.CreateBlock(w.GetAddress())
+
+	first := signedTransaction(t, w, "B", 1, 0)
+	if _, err := bc.SubmitTransaction(first); err != nil {
+		t.Fatalf("SubmitTransaction() error = %v", err)
+	}
+
+	// Resubmitting the same signed transaction is a replay
+	if _, err := bc.SubmitTransaction(first); !errors.Is(err, ErrNonceTooLow) {
+		t.Errorf("Replay error = %v; want %v", err, ErrNonceTooLow)
+	}
+
+	// Skipping a nonce is out of order
+	if _, err := bc.SubmitTransaction(signedTransaction(t, w, "B", 1, 2)); !errors.Is(err, ErrNonceTooHigh) {
+		t.Errorf("Gap error = %v; want %v", err, ErrNonceTooHigh)
+	}
+
+	// Tampering with the nonce breaks the signature
+	tampered := first
+	tampered.Nonce = 1
+	if _, err := bc.SubmitTransaction(tampered); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("Tampered error = %v; want %v", err, ErrInvalidSignature)
+	}
+
+	// Outputs mean nothing on an account chain
+	withOutputs := signTransaction(t, w, Transaction{Sender: w.GetAddress(), Receiver: "B", Amount: 1, Nonce: 1, Outputs: []TxOutput{{Address: "B", Amount: Coin}}})
+	if _, err := bc.SubmitTransaction(withOutputs); !errors.Is(err, ErrInputsNotAllowed) {
+		t.Errorf("Outputs error = %v; want %v", err, ErrInputsNotAllowed)
+	}
+
+	if _, err := bc.SubmitTransaction(signedTransaction(t, w, "B", 1, 1)); err != nil {
+		t.Errorf("SubmitTransaction() error = %v", err)
+	}
+
+	bc.CreateBlock("miner")
+	if got := bc.NextNonce(w.GetAddress()); got != 2 {
+		t.Errorf("NextNonce() = %d; want 2", got)
+	}
+
+	// A mined transaction cannot be replayed either
+	if _, err := bc.SubmitTransaction(first); !errors.Is(err, ErrNonceTooLow) {
+		t.Errorf("Replay after mining error = %v; want %v", err, ErrNonceTooLow)
+	}
+}
+
+func TestValidChainRejectsReplayedTransaction(t *testing.T) {
+	os.Remove(BlockchainFile)
+	defer os.Remove(BlockchainFile)
+	defer mockMaturity(1)() // rewards are spent in the next block
+
+	bc := NewBlockChain()
+	w := wallet.NewWallet()
+	bc.CreateBlock(w.GetAddress())
+
+	tx := signedTransaction(t, w, "B", 1, 0)
+	if _, err := bc.SubmitTransaction(tx); err != nil {
+		t.Fatalf("SubmitTransaction() error = %v", err)
+	}
+	bc.CreateBlock("miner")
+
+	// Sneak the same transaction into another block
+	block := mineBlock(t, bc, tx)
+	if err := bc.AddBlock(block); !errors.Is(err, ErrNonceTooLow) {
+		t.Errorf("AddBlock() error = %v; want %v", err, ErrNonceTooLow)
+	}
+
+	bc.Chain = append(bc.Chain, block)
+	if bc.ValidChain(bc.Chain) == nil {
+		t.Error("ValidChain passed for a chain with a replayed transaction")
+	}
+}
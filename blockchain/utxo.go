@@ -0,0 +1,177 @@
+package blockchain
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// Errors returned when a transaction spends outputs it cannot spend
+var (
+	ErrMissingInput   = errors.New("input does not reference an unspent output")
+	ErrInputNotOwned  = errors.New("input is not locked to the sender")
+	ErrNoInputs       = errors.New("transaction has no inputs")
+	ErrInvalidOutputs = errors.New("invalid outputs")
+)
+
+// TxInput references an output of an earlier transaction
+type TxInput struct {
+	TxID  string `json:"tx_id"`
+	Index int    `json:"index"`
+}
+
+// TxOutput locks an amount to an address
+type TxOutput struct {
+	Address string `json:"address"`
+	Amount  Amount `json:"amount"`
+}
+
+// OutPoint identifies a transaction output
+type OutPoint struct {
+	TxID  string `json:"tx_id"`
+	Index int    `json:"index"`
+}
+
+// UTXO is an unspent output together with where it lives
+type UTXO struct {
+	OutPoint
+	TxOutput
+}
+
+// SpentOutput records an output consumed by a block so it can be restored on disconnect
+type SpentOutput struct {
+	OutPoint OutPoint
+	Output   TxOutput
+}
+
+// UTXOSet holds every unspent output of the chain
+type UTXOSet map[OutPoint]TxOutput
+
+// ConnectBlock spends the inputs and adds the outputs of every transaction in the block.
+// It returns the spent outputs needed to disconnect the block again. If a transaction is
+// invalid the set is left untouched.
+func (u UTXOSet) ConnectBlock(block Block) ([]SpentOutput, error) {
+	undo := []SpentOutput{}
+	for i, tx := range block.Transactions {
+		spent, err := u.connectTransaction(tx)
+		if err != nil {
+			u.DisconnectBlock(Block{Transactions: block.Transactions[:i]}, undo)
+			return nil, ruleError(block, i, RuleInputs, err, nil, nil)
+		}
+		undo = append(undo, spent...)
+	}
+	return undo, nil
+}
+
+// DisconnectBlock removes the outputs created by the block and restores the outputs it spent
+func (u UTXOSet) DisconnectBlock(block Block, undo []SpentOutput) {
+	for i := len(block.Transactions) - 1; i >= 0; i-- {
+		tx := block.Transactions[i]
+		id := tx.Hash()
+		for index := range tx.outputs() {
+			delete(u, OutPoint{TxID: id, Index: index})
+		}
+	}
+	for _, spent := range undo {
+		u[spent.OutPoint] = spent.Output
+	}
+}
+
+// CheckTransaction validates the inputs and outputs of a transaction against the set
+// without changing it, and returns the total value of its inputs
+func (u UTXOSet) CheckTransaction(tx Transaction) (Amount, error) {
+	outputs := tx.outputs()
+	outputTotal := Amount(0)
+	for _, output := range outputs {
+		if output.Amount <= 0 || output.Amount > maxAmount || output.Address == "" {
+			return 0, ErrInvalidOutputs
+		}
+		outputTotal += output.Amount
+	}
+
+	if tx.IsCoinbase() {
+		if len(tx.Inputs) > 0 || tx.Fee != 0 {
+			return 0, fmt.Errorf("%w: coinbase cannot spend inputs or pay fees", ErrInvalidOutputs)
+		}
+		return 0, nil
+	}
+	if tx.Fee < 0 || tx.Fee > maxAmount {
+		return 0, fmt.Errorf("%w: fee %s", ErrInvalidAmount, tx.Fee)
+	}
+
+	if len(tx.Inputs) == 0 {
+		return 0, ErrNoInputs
+	}
+
+	inputTotal := Amount(0)
+	seen := make(map[OutPoint]bool, len(tx.Inputs))
+	for _, input := range tx.Inputs {
+		outPoint := OutPoint(input)
+		output, ok := u[outPoint]
+		if !ok || seen[outPoint] {
+			return 0, fmt.Errorf("%w: %s:%d", ErrMissingInput, input.TxID, input.Index)
+		}
+		if output.Address != tx.Sender {
+			return 0, fmt.Errorf("%w: %s:%d", ErrInputNotOwned, input.TxID, input.Index)
+		}
+		seen[outPoint] = true
+		inputTotal += output.Amount
+	}
+
+	// Whatever the outputs do not claim is the fee, and it has to match the signed Fee
+	if inputTotal < outputTotal+tx.Fee {
+		return 0, fmt.Errorf("%w: inputs %s, outputs %s, fee %s", ErrInsufficientBalance, inputTotal, outputTotal, tx.Fee)
+	}
+	if inputTotal > outputTotal+tx.Fee {
+		return 0, fmt.Errorf("%w: inputs %s exceed outputs %s plus fee %s", ErrInvalidOutputs, inputTotal, outputTotal, tx.Fee)
+	}
+	return inputTotal, nil
+}
+
+// connectTransaction applies a single transaction and returns the outputs it spent
+func (u UTXOSet) connectTransaction(tx Transaction) ([]SpentOutput, error) {
+	if _, err := u.CheckTransaction(tx); err != nil {
+		return nil, err
+	}
+
+	spent := make([]SpentOutput, 0, len(tx.Inputs))
+	for _, input := range tx.Inputs {
+		outPoint := OutPoint(input)
+		spent = append(spent, SpentOutput{OutPoint: outPoint, Output: u[outPoint]})
+		delete(u, outPoint)
+	}
+
+	id := tx.Hash()
+	for index, output := range tx.outputs() {
+		u[OutPoint{TxID: id, Index: index}] = output
+	}
+	return spent, nil
+}
+
+// Balance returns the total value of the outputs locked to an address
+func (u UTXOSet) Balance(address string) Amount {
+	balance := Amount(0)
+	for _, output := range u {
+		if output.Address == address {
+			balance += output.Amount
+		}
+	}
+	return balance
+}
+
+// Unspent returns the outputs locked to an address, sorted by outpoint
+func (u UTXOSet) Unspent(address string) []UTXO {
+	utxos := []UTXO{}
+	for outPoint, output := range u {
+		if output.Address == address {
+			utxos = append(utxos, UTXO{OutPoint: outPoint, TxOutput: output})
+		}
+	}
+	sort.Slice(utxos, func(i, j int) bool {
+		if utxos[i].TxID != utxos[j].TxID {
+			return utxos[i].TxID < utxos[j].TxID
+		}
+		return utxos[i].Index < utxos[j].Index
+	})
+	return utxos
+}
@@ -0,0 +1,161 @@
+package blockchain
+
+import (
+	"blocklite/wallet"
+	"errors"
+	"os"
+	"reflect"
+	"testing"
+)
+
+// TestUTXOSetConnectDisconnect checks that disconnecting a block restores the set it was connected to.
+func TestUTXOSetConnectDisconnect(t *testing.T) {
+	coinbase := Transaction{Sender: "0", Receiver: "alice", Amount: 50, Nonce: 1}
+	utxo := UTXOSet{}
+	if _, err := utxo.ConnectBlock(Block{Transactions: []Transaction{coinbase}}); err != nil {
+		t.Fatalf("ConnectBlock() error = %v", err)
+	}
+
+	before := UTXOSet{}
+	for outPoint, output := range utxo {
+		before[outPoint] = output
+	}
+
+	spend := Transaction{
+		Sender:  "alice",
+		Inputs:  []TxInput{{TxID: coinbase.Hash(), Index: 0}},
+		Outputs: []TxOutput{{Address: "bob", Amount: 30}, {Address: "alice", Amount: 20}},
+	}
+	block := Block{Transactions: []Transaction{spend}}
+
+	undo, err := utxo.ConnectBlock(block)
+	if err != nil {
+		t.Fatalf("ConnectBlock() error = %v", err)
+	}
+	if got := utxo.Balance("bob"); got != 30 {
+		t.Errorf("Balance(bob) = %v; want 30", got)
+	}
+	if got := utxo.Balance("alice"); got != 20 {
+		t.Errorf("Balance(alice) = %v; want 20", got)
+	}
+
+	utxo.DisconnectBlock(block, undo)
+	if !reflect.DeepEqual(utxo, before) {
+		t.Errorf("UTXO set after disconnect = %v; want %v", utxo, before)
+	}
+}
+
+// TestUTXOSetRejectsInvalidSpends covers double spends, foreign inputs and overspending.
+func TestUTXOSetRejectsInvalidSpends(t *testing.T) {
+	coinbase := Transaction{Sender: "0", Receiver: "alice", Amount: 50, Nonce: 1}
+	input := TxInput{TxID: coinbase.Hash(), Index: 0}
+
+	tests := []struct {
+		name     string
+		txs      []Transaction
+		expected error
+	}{
+		{
+			name: "Double spend in one block",
+			txs: []Transaction{
+				{Sender: "alice", Inputs: []TxInput{input}, Outputs: []TxOutput{{Address: "bob", Amount: 50}}},
+				{Sender: "alice", Inputs: []TxInput{input}, Outputs: []TxOutput{{Address: "carol", Amount: 50}}},
+			},
+			expected: ErrMissingInput,
+		},
+		{
+			name:     "Spending someone else's output",
+			txs:      []Transaction{{Sender: "bob", Inputs: []TxInput{input}, Outputs: []TxOutput{{Address: "bob", Amount: 50}}}},
+			expected: ErrInputNotOwned,
+		},
+		{
+			name:     "Overspending",
+			txs:      []Transaction{{Sender: "alice", Inputs: []TxInput{input}, Outputs: []TxOutput{{Address: "bob", Amount: 60}}}},
+			expected: ErrInsufficientBalance,
+		},
+		{
+			name:     "No inputs",
+			txs:      []Transaction{{Sender: "alice", Outputs: []TxOutput{{Address: "bob", Amount: 1}}}},
+			expected: ErrNoInputs,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			utxo := UTXOSet{}
+			if _, err := utxo.ConnectBlock(Block{Transactions: []Transaction{coinbase}}); err != nil {
+				t.Fatalf("ConnectBlock() error = %v", err)
+			}
+
+			_, err := utxo.ConnectBlock(Block{Transactions: tt.txs})
+			if !errors.Is(err, tt.expected) {
+				t.Errorf("ConnectBlock() error = %v; want %v", err, tt.expected)
+			}
+			if got := utxo.Balance("alice"); got != 50 {
+				t.Errorf("Balance(alice) after failed block = %v; want 50", got)
+			}
+		})
+	}
+}
+
+// TestUTXOBlockchain runs a spend through the mempool, mining and ValidChain on a UTXO chain.
+func TestUTXOBlockchain(t *testing.T) {
+	os.Remove(BlockchainFile)
+	defer os.Remove(BlockchainFile)
+	defer mockMaturity(1)() // rewards are spent in the next block
+
+	bc := NewBlockChainWithGenesis(Genesis{Ledger: UTXOModel})
+	alice := wallet.NewWallet()
+	bc.CreateBlock(alice.GetAddress())
+
+	utxos := bc.GetUnspentOutputs(alice.GetAddress())
+	if len(utxos) != 1 {
+		t.Fatalf("GetUnspentOutputs() = %d outputs; want 1", len(utxos))
+	}
+
+	spend := Transaction{
+		Sender:  alice.GetAddress(),
+		Inputs:  []TxInput{{TxID: utxos[0].TxID, Index: utxos[0].Index}},
+		Outputs: []TxOutput{{Address: "bob", Amount: 10 * Coin}, {Address: alice.GetAddress(), Amount: 39 * Coin}},
+		Fee:     Coin,
+	}
+	signature, err := wallet.Sign(alice.PrivateKey, spend.SigningData())
+	if err != nil {
+		t.Fatalf("Failed to sign: %v", err)
+	}
+	spend.Signature = signature
+
+	if _, err := bc.SubmitTransaction(spend); err != nil {
+		t.Fatalf("SubmitTransaction() error = %v", err)
+	}
+
+	// The same output cannot be spent twice while the first spend is pending
+	if _, err := bc.SubmitTransaction(spend); !errors.Is(err, ErrMempoolConflict) {
+		t.Errorf("Double spend error = %v; want %v", err, ErrMempoolConflict)
+	}
+
+	bc.CreateBlock("miner")
+
+	if got := bc.GetBalance("bob"); got != 10*Coin {
+		t.Errorf("GetBalance(bob) = %v; want 10", got)
+	}
+	if got := bc.GetBalance(alice.GetAddress()); got != 39*Coin {
+		t.Errorf("GetBalance(alice) = %v; want 39", got)
+	}
+	if got := bc.GetBalance("miner"); got != MiningReward+Coin {
+		t.Errorf("GetBalance(miner) = %v; want reward plus 1 coin of fees", got)
+	}
+	if err := bc.ValidChain(bc.Chain); err != nil {
+		t.Errorf("ValidChain failed for a valid UTXO chain: %v", err)
+	}
+
+	// A block spending the same output again is rejected
+	block := mineBlock(t, bc, spend)
+	if err := bc.AddBlock(block); !errors.Is(err, ErrMissingInput) {
+		t.Errorf("AddBlock() error = %v; want %v", err, ErrMissingInput)
+	}
+	bc.Chain = append(bc.Chain, block)
+	if bc.ValidChain(bc.Chain) == nil {
+		t.Error("ValidChain passed for a UTXO chain with a double spend")
+	}
+}
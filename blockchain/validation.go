@@ -0,0 +1,278 @@
+package blockchain
+
+import (
+	"blocklite/wallet"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// ErrInvalidCoinbase is returned for blocks without exactly one coinbase in front, or whose
+// coinbase pays more than the block reward plus the fees of the block
+var ErrInvalidCoinbase = errors.New("invalid coinbase")
+
+// ErrDuplicateTransaction is returned for blocks that contain the same transaction twice
+var ErrDuplicateTransaction = errors.New("duplicate transaction")
+
+// ErrInvalidTimestamp is returned for block timestamps that are not RFC 3339 UTC times with
+// whole seconds
+var ErrInvalidTimestamp = errors.New("invalid timestamp")
+
+// ErrFutureTimestamp is returned for blocks dated more than MaxFutureBlockTime ahead of our
+// clock. Such a block may become valid later, unlike those breaking other rules.
+var ErrFutureTimestamp = errors.New("timestamp is too far in the future")
+
+// MaxFutureBlockTime is how far ahead of our clock a block timestamp may be, as in Bitcoin
+var MaxFutureBlockTime = 2 * time.Hour
+
+// MedianTimeSpan is the number of previous blocks whose median timestamp a block must be
+// dated after, as in Bitcoin
+const MedianTimeSpan = 11
+
+// Consensus rules a block or transaction can break, as reported in ValidationError.Rule
+const (
+	RuleGenesis      = "genesis"
+	RuleIndex        = "index"
+	RulePreviousHash = "previous_hash"
+	RuleDifficulty   = "difficulty"
+	RuleChainWork    = "chain_work"
+	RuleMerkleRoot   = "merkle_root"
+	RuleDuplicate    = "duplicate_transaction"
+	RuleBlockLimits  = "block_limits"
+	RuleProof        = "proof"
+	RuleTimestamp    = "timestamp"
+	RuleSignature    = "signature"
+	RuleCoinbase     = "coinbase"
+	RuleAmount       = "amount"
+	RuleNonce        = "nonce"
+	RuleBalance      = "balance"
+	RuleInputs       = "inputs"
+	RuleMaturity     = "maturity"
+)
+
+// ValidationError reports the block, and the transaction if any, that broke a consensus rule,
+// with the expected and actual values when the rule compares one.
+// It matches both ErrInvalidBlock and the error of the broken rule with errors.Is.
+type ValidationError struct {
+	BlockIndex int    `json:"block_index"`
+	TxIndex    int    `json:"tx_index"` // -1 when the block itself is invalid
+	Rule       string `json:"rule"`
+	Expected   string `json:"expected,omitempty"`
+	Actual     string `json:"actual,omitempty"`
+	Err        error  `json:"-"`
+}
+
+// ruleError builds a ValidationError. expected and actual are left out when both are nil.
+func ruleError(block Block, txIndex int, rule string, err error, expected, actual any) *ValidationError {
+	e := &ValidationError{BlockIndex: block.Index, TxIndex: txIndex, Rule: rule, Err: err}
+	if expected != nil || actual != nil {
+		e.Expected, e.Actual = fmt.Sprint(expected), fmt.Sprint(actual)
+	}
+	return e
+}
+
+func (e *ValidationError) Error() string {
+	location := fmt.Sprintf("invalid block %d", e.BlockIndex)
+	if e.TxIndex >= 0 {
+		location += fmt.Sprintf(", transaction %d", e.TxIndex)
+	}
+	message := fmt.Sprintf("%s: %s: %v", location, e.Rule, e.Err)
+	if e.Expected != "" || e.Actual != "" {
+		message += fmt.Sprintf(" (expected %s, got %s)", e.Expected, e.Actual)
+	}
+	return message
+}
+
+func (e *ValidationError) Unwrap() []error {
+	return []error{ErrInvalidBlock, e.Err}
+}
+
+// parseTimestamp parses a block timestamp. Only the form the template writes is accepted,
+// e.g. 2025-07-06T12:00:00Z, so a header cannot be re-encoded.
+func parseTimestamp(timestamp string) (time.Time, error) {
+	parsed, err := time.Parse(time.RFC3339, timestamp)
+	if err != nil || parsed.UTC().Format(time.RFC3339) != timestamp {
+		return time.Time{}, fmt.Errorf("%w: %q", ErrInvalidTimestamp, timestamp)
+	}
+	return parsed, nil
+}
+
+// checkGenesis checks that the first block of a chain fixes the starting difficulty
+func checkGenesis(genesis Block) error {
+	if _, err := parseTimestamp(genesis.Timestamp); err != nil {
+		return ruleError(genesis, -1, RuleTimestamp, err, nil, nil)
+	}
+	if genesis.Difficulty != InitialDifficulty {
+		return ruleError(genesis, -1, RuleGenesis, errors.New("genesis difficulty does not match"), InitialDifficulty, genesis.Difficulty)
+	}
+	if genesis.ChainWork != BlockWork(genesis.Difficulty) {
+		return ruleError(genesis, -1, RuleGenesis, errors.New("genesis chain work does not match"), BlockWork(genesis.Difficulty), genesis.ChainWork)
+	}
+	return nil
+}
+
+// checkHeader checks that a block links to the tip of chain, was mined at the difficulty
+// the retarget schedule demands and commits to its transactions.
+// The Merkle tree pairs an odd last node with itself, so repeating the last transactions
+// of a block keeps its root and hash (CVE-2012-2459). Blocks with duplicate transactions
+// are refused, otherwise such a copy could pass for the real block.
+func checkHeader(chain []Block, block Block, retarget Retarget) error {
+	if err := checkLinkage(chain, block, retarget); err != nil {
+		return err
+	}
+	seen := make(map[string]bool, len(block.Transactions))
+	for i, tx := range block.Transactions {
+		id := tx.Hash()
+		if seen[id] {
+			return ruleError(block, i, RuleDuplicate, fmt.Errorf("%w: %s", ErrDuplicateTransaction, id), nil, nil)
+		}
+		seen[id] = true
+	}
+	if expected := MerkleRoot(block.Transactions); block.MerkleRoot != expected {
+		return ruleError(block, -1, RuleMerkleRoot, errors.New("merkle root does not match transactions"), expected, block.MerkleRoot)
+	}
+	if err := checkBlockLimits(block); err != nil {
+		return ruleError(block, -1, RuleBlockLimits, err, nil, nil)
+	}
+	return nil
+}
+
+// checkLinkage checks the rules a header can be checked against without the transactions:
+// index, previous hash, timestamp, difficulty under the retarget schedule, chain work and
+// proof of work. Timestamps must be after the median time past of the previous blocks and at
+// most MaxFutureBlockTime ahead of our clock.
+func checkLinkage(chain []Block, block Block, retarget Retarget) error {
+	previousHash := "0"
+	if len(chain) > 0 {
+		previousHash = chain[len(chain)-1].CalculateHash()
+	}
+	if block.Index != len(chain)+1 {
+		return ruleError(block, -1, RuleIndex, errors.New("index does not follow the previous block"), len(chain)+1, block.Index)
+	}
+	if block.PreviousHash != previousHash {
+		return ruleError(block, -1, RulePreviousHash, errors.New("previous hash does not match"), previousHash, block.PreviousHash)
+	}
+	if err := checkTimestamp(chain, block); err != nil {
+		return err
+	}
+	expected, err := retarget.NextDifficulty(chain)
+	if err != nil {
+		return ruleError(block, -1, RuleDifficulty, err, nil, nil)
+	}
+	if block.Difficulty != expected {
+		return ruleError(block, -1, RuleDifficulty, errors.New("difficulty does not follow the retarget rule"), expected, block.Difficulty)
+	}
+	if expected := ChainWork(chain) + BlockWork(block.Difficulty); block.ChainWork != expected {
+		return ruleError(block, -1, RuleChainWork, errors.New("chain work does not add up"), expected, block.ChainWork)
+	}
+	if valid, hashHex := VerifyProof(block.Header()); !valid {
+		return ruleError(block, -1, RuleProof, fmt.Errorf("proof hash %s does not meet difficulty %d", hashHex, block.Difficulty), nil, nil)
+	}
+	return nil
+}
+
+// checkTimestamp checks that the timestamp of a block is well formed, after the median time
+// past of the previous blocks and not too far in the future. Unlike a rule that timestamps
+// increase, the median lets a block be dated before its parent, so one block dated ahead
+// does not drag the timestamps of every block after it forward.
+func checkTimestamp(chain []Block, block Block) error {
+	timestamp, err := parseTimestamp(block.Timestamp)
+	if err != nil {
+		return ruleError(block, -1, RuleTimestamp, err, nil, nil)
+	}
+	if len(chain) > 0 {
+		median, err := medianTimePast(chain)
+		if err != nil {
+			return ruleError(block, -1, RuleTimestamp, fmt.Errorf("previous block: %w", err), nil, nil)
+		}
+		if !timestamp.After(median) {
+			return ruleError(block, -1, RuleTimestamp, errors.New("timestamp is not after the median time past"), "after "+median.Format(time.RFC3339), block.Timestamp)
+		}
+	}
+	if limit := timeNow().Add(MaxFutureBlockTime); timestamp.After(limit) {
+		return ruleError(block, -1, RuleTimestamp, ErrFutureTimestamp, "at most "+limit.UTC().Format(time.RFC3339), block.Timestamp)
+	}
+	return nil
+}
+
+// medianTimePast returns the median timestamp of the last MedianTimeSpan blocks of chain,
+// which must not be empty. With an even number of blocks, the later of the middle two wins.
+func medianTimePast(chain []Block) (time.Time, error) {
+	recent := chain[max(len(chain)-MedianTimeSpan, 0):]
+	times := make([]time.Time, len(recent))
+	for i, block := range recent {
+		timestamp, err := parseTimestamp(block.Timestamp)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("block %d: %w", block.Index, err)
+		}
+		times[i] = timestamp
+	}
+	sort.Slice(times, func(i, j int) bool { return times[i].Before(times[j]) })
+	return times[len(times)/2], nil
+}
+
+// checkBlock validates a block that follows chain and applies it to state, which must be
+// the ledger state at the tip of chain. The difficulty must follow retarget and the coinbase
+// may pay what emission allows. It returns the outputs the block spent on a UTXO chain. On
+// error the state is left untouched.
+func checkBlock(chain []Block, block Block, state *ledgerState, emission Emission, retarget Retarget) ([]SpentOutput, error) {
+	if err := checkHeader(chain, block, retarget); err != nil {
+		return nil, err
+	}
+
+	for i, tx := range block.Transactions {
+		if !tx.IsCoinbase() && !wallet.Verify(tx.Sender, tx.SigningData(), tx.Signature) {
+			return nil, ruleError(block, i, RuleSignature, ErrInvalidSignature, nil, nil)
+		}
+	}
+	if err := checkCoinbase(block, emission.Reward(block.Index, state.supply)); err != nil {
+		return nil, err
+	}
+
+	return state.connectBlock(block)
+}
+
+// checkCoinbase checks that the first transaction of a block is its only coinbase, that it
+// carries the block index as its nonce and pays at most reward plus the fees of the block
+func checkCoinbase(block Block, reward Amount) error {
+	invalid := func(i int, reason string, expected, actual any) error {
+		return ruleError(block, i, RuleCoinbase, fmt.Errorf("%w: %s", ErrInvalidCoinbase, reason), expected, actual)
+	}
+
+	if len(block.Transactions) == 0 || !block.Transactions[0].IsCoinbase() {
+		return invalid(-1, "block does not start with a coinbase", nil, nil)
+	}
+
+	allowed := reward
+	for i, tx := range block.Transactions[1:] {
+		if tx.IsCoinbase() {
+			return invalid(i+1, "second coinbase", nil, nil)
+		}
+		if tx.Fee < 0 || tx.Fee > maxAmount-allowed {
+			return ruleError(block, i+1, RuleAmount, fmt.Errorf("%w: fee %s", ErrInvalidAmount, tx.Fee), nil, nil)
+		}
+		allowed += tx.Fee
+	}
+
+	coinbase := block.Transactions[0]
+	if coinbase.Nonce != uint64(block.Index) {
+		return invalid(0, "nonce must be the block index", block.Index, coinbase.Nonce)
+	}
+	if coinbase.Fee != 0 {
+		return invalid(0, "coinbase cannot pay a fee", Amount(0), coinbase.Fee)
+	}
+	paid := Amount(0)
+	for _, output := range coinbase.outputs() {
+		if output.Amount < 0 || output.Amount > maxAmount {
+			return ruleError(block, 0, RuleAmount, ErrInvalidAmount, nil, output.Amount)
+		}
+		if paid <= maxAmount {
+			paid += output.Amount // cannot overflow, and past maxAmount the total is too high anyway
+		}
+	}
+	if paid > allowed {
+		return invalid(0, "pays more than the reward plus fees", allowed, paid)
+	}
+	return nil
+}
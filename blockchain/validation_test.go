@@ -0,0 +1,242 @@
+package blockchain
+
+import (
+	"blocklite/wallet"
+	"errors"
+	"os"
+	"testing"
+	"time"
+)
+
+// remine recomputes the Merkle root and the proof of a block after its transactions changed
+func remine(block Block) Block {
+	block.MerkleRoot = MerkleRoot(block.Transactions)
+	block.Proof = ProofOfWork(block.Header())
+	return block
+}
+
+// TestValidChainLedgerRules checks the coinbase and balance rules and where the error points.
+func TestValidChainLedgerRules(t *testing.T) {
+	os.Remove(BlockchainFile)
+	defer os.Remove(BlockchainFile)
+	defer mockMaturity(1)() // rewards are spent in the next block
+
+	bc := NewBlockChain()
+	w := wallet.NewWallet()
+	bc.CreateBlock(w.GetAddress())
+
+	tests := []struct {
+		name     string
+		block    func() Block
+		txIndex  int
+		rule     string
+		expected error
+	}{
+		{
+			name: "Coinbase pays more than the reward",
+			block: func() Block {
+				block := mineBlock(t, bc)
+				block.Transactions[0].Amount = MiningReward + 1
+				return remine(block)
+			},
+			txIndex:  0,
+			rule:     RuleCoinbase,
+			expected: ErrInvalidCoinbase,
+		},
+		{
+			name: "No coinbase",
+			block: func() Block {
+				block := mineBlock(t, bc, signedTransaction(t, w, "B", Coin, 0))
+				block.Transactions = block.Transactions[1:]
+				return remine(block)
+			},
+			txIndex:  -1,
+			rule:     RuleCoinbase,
+			expected: ErrInvalidCoinbase,
+		},
+		{
+			name: "Second coinbase",
+			block: func() Block {
+				block := mineBlock(t, bc)
+				second := block.Transactions[0]
+				second.Receiver = "other"
+				block.Transactions = append(block.Transactions, second)
+				return remine(block)
+			},
+			txIndex:  1,
+			rule:     RuleCoinbase,
+			expected: ErrInvalidCoinbase,
+		},
+		{
+			name: "Coinbase credits more than its outputs pay",
+			block: func() Block {
+				block := mineBlock(t, bc)
+				block.Transactions[0].Outputs = []TxOutput{{Address: "miner", Amount: 1}}
+				block.Transactions[0].Amount = 1000 * MiningReward
+				return remine(block)
+			},
+			txIndex:  0,
+			rule:     RuleInputs,
+			expected: ErrInputsNotAllowed,
+		},
+		{
+			name: "Transfer with outputs",
+			block: func() Block {
+				tx := Transaction{Sender: w.GetAddress(), Receiver: "B", Amount: Coin, Outputs: []TxOutput{{Address: "B", Amount: Coin}}}
+				return mineBlock(t, bc, signTransaction(t, w, tx))
+			},
+			txIndex:  1,
+			rule:     RuleInputs,
+			expected: ErrInputsNotAllowed,
+		},
+		{
+			name: "Last transaction repeated",
+			block: func() Block {
+				block := mineBlock(t, bc, signedTransaction(t, w, "B", Coin, 0), signedTransaction(t, w, "C", Coin, 1))
+				block.Transactions = append(block.Transactions, block.Transactions[2])
+				if MerkleRoot(block.Transactions) != block.MerkleRoot {
+					t.Fatal("Repeating the last transaction changed the Merkle root")
+				}
+				return block
+			},
+			txIndex:  3,
+			rule:     RuleDuplicate,
+			expected: ErrDuplicateTransaction,
+		},
+		{
+			name: "Transfers overspend the sender",
+			block: func() Block {
+				return mineBlock(t, bc, signedTransaction(t, w, "B", 30*Coin, 0), signedTransaction(t, w, "C", 30*Coin, 1))
+			},
+			txIndex:  2,
+			rule:     RuleBalance,
+			expected: ErrInsufficientBalance,
+		},
+		{
+			name: "Transfer from an empty account",
+			block: func() Block {
+				return mineBlock(t, bc, signedTransaction(t, wallet.NewWallet(), "B", Coin, 0))
+			},
+			txIndex:  1,
+			rule:     RuleBalance,
+			expected: ErrInsufficientBalance,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			block := tt.block()
+			err := bc.ValidChain(append(append([]Block{}, bc.Chain...), block))
+
+			var validationErr *ValidationError
+			if !errors.As(err, &validationErr) {
+				t.Fatalf("ValidChain() error = %v; want a *ValidationError", err)
+			}
+			if !errors.Is(err, tt.expected) || !errors.Is(err, ErrInvalidBlock) {
+				t.Errorf("ValidChain() error = %v; want %v", err, tt.expected)
+			}
+			if validationErr.BlockIndex != 3 || validationErr.TxIndex != tt.txIndex || validationErr.Rule != tt.rule {
+				t.Errorf("Error at block %d, transaction %d, rule %q; want block 3, transaction %d, rule %q",
+					validationErr.BlockIndex, validationErr.TxIndex, validationErr.Rule, tt.txIndex, tt.rule)
+			}
+
+			if err := bc.AddBlock(block); !errors.Is(err, tt.expected) {
+				t.Errorf("AddBlock() error = %v; want %v", err, tt.expected)
+			}
+		})
+	}
+
+	if err := bc.ValidChain(bc.Chain); err != nil {
+		t.Errorf("ValidChain() error = %v for a valid chain", err)
+	}
+}
+
+// TestTimestampRules checks that block timestamps are well formed, after the median time past
+// and do not run ahead of our clock, and that templates keep them valid within the same second.
+func TestTimestampRules(t *testing.T) {
+	os.Remove(BlockchainFile)
+	defer os.Remove(BlockchainFile)
+	now := time.Date(2025, 7, 6, 12, 0, 0, 0, time.UTC)
+	defer mockTime(now)()
+
+	bc := NewBlockChain()
+	for i := 0; i < 3; i++ {
+		if _, err := bc.CreateBlock("miner"); err != nil {
+			t.Fatalf("CreateBlock() error = %v", err)
+		}
+	}
+	if err := bc.IsChainValid(); err != nil {
+		t.Fatalf("IsChainValid() error = %v for blocks mined within one second", err)
+	}
+	median, err := medianTimePast(bc.Chain)
+	if err != nil {
+		t.Fatalf("medianTimePast() error = %v", err)
+	}
+
+	tests := []struct {
+		name      string
+		timestamp string
+	}{
+		{name: "Not RFC 3339", timestamp: "Sun Jul  6 12:00:10 UTC 2025"},
+		{name: "Not UTC", timestamp: "2025-07-06T14:00:10+02:00"},
+		{name: "Fractional seconds", timestamp: "2025-07-06T12:00:10.5Z"},
+		{name: "Not after the median time past", timestamp: median.Format(time.RFC3339)},
+		{name: "Too far in the future", timestamp: now.Add(MaxFutureBlockTime + time.Second).Format(time.RFC3339)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			block := mineBlock(t, bc)
+			block.Timestamp = tt.timestamp
+			block = remine(block)
+
+			err := bc.AddBlock(block)
+			var validationErr *ValidationError
+			if !errors.As(err, &validationErr) || validationErr.Rule != RuleTimestamp {
+				t.Errorf("AddBlock() error = %v; want the %q rule broken", err, RuleTimestamp)
+			}
+		})
+	}
+}
+
+// TestMedianTimePast accepts a block dated before its parent as long as it is after the
+// median time past, so a block dated ahead does not drag the next ones forward.
+func TestMedianTimePast(t *testing.T) {
+	os.Remove(BlockchainFile)
+	defer os.Remove(BlockchainFile)
+	now := time.Date(2025, 7, 6, 12, 10, 0, 0, time.UTC)
+	defer mockTime(now)()
+
+	bc := NewBlockChain()
+	for i := 0; i < 4; i++ {
+		if _, err := bc.CreateBlock("miner"); err != nil {
+			t.Fatalf("CreateBlock() error = %v", err)
+		}
+	}
+
+	ahead := mineBlock(t, bc)
+	ahead.Timestamp = now.Add(time.Hour).Format(time.RFC3339)
+	if err := bc.AddBlock(remine(ahead)); err != nil {
+		t.Fatalf("AddBlock() error = %v for a block an hour ahead", err)
+	}
+
+	median, err := medianTimePast(bc.Chain)
+	if err != nil {
+		t.Fatalf("medianTimePast() error = %v", err)
+	}
+	if !median.Before(now.Add(time.Hour)) {
+		t.Errorf("medianTimePast() = %v; want it unmoved by the block ahead", median)
+	}
+
+	// Templates follow our clock, not the block ahead
+	block, err := bc.CreateBlock("miner")
+	if err != nil {
+		t.Fatalf("CreateBlock() error = %v after a block ahead", err)
+	}
+	if timestamp, _ := parseTimestamp(block.Timestamp); !timestamp.Before(now.Add(time.Hour)) {
+		t.Errorf("CreateBlock() timestamp = %s; want it before the block ahead", block.Timestamp)
+	}
+	if err := bc.IsChainValid(); err != nil {
+		t.Errorf("IsChainValid() error = %v", err)
+	}
+}
@@ -0,0 +1,170 @@
+// Command miner mines blocks for a blocklite node from a separate process. It fetches a
+// block template from the node, searches for a nonce on all CPUs and submits it, starting
+// over whenever the node's tip changes.
+//
+//	go run ./cmd/miner -node http://localhost:8080 -address YOUR_ADDRESS
+package main
+
+import (
+	"blocklite/blockchain"
+	"blocklite/miner"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"runtime"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// client talks to the mining endpoints of a node
+type client struct {
+	node    string
+	address string
+	http    *http.Client
+}
+
+func main() {
+	node := flag.String("node", "http://localhost:8080", "URL of the node's API")
+	address := flag.String("address", "system-miner", "address that receives the rewards")
+	workers := flag.Int("workers", runtime.NumCPU(), "goroutines searching for a nonce")
+	refresh := flag.Duration("refresh", 5*time.Second, "how often to check the node for a new tip")
+	flag.Parse()
+
+	blockchain.MiningWorkers = *workers
+	c := &client{node: *node, address: *address, http: &http.Client{Timeout: 10 * time.Second}}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	var hashes atomic.Uint64
+	start := time.Now()
+	for ctx.Err() == nil {
+		if err := c.mine(ctx, *refresh, &hashes); err != nil {
+			log.Printf("Mining failed: %v", err)
+			select {
+			case <-ctx.Done():
+			case <-time.After(*refresh):
+			}
+		}
+		log.Printf("%.0f hashes/s", float64(hashes.Load())/time.Since(start).Seconds())
+	}
+}
+
+// mine works on one template until a nonce is found and submitted, or the tip changes
+func (c *client) mine(ctx context.Context, refresh time.Duration, hashes *atomic.Uint64) error {
+	job, err := c.template(ctx)
+	if err != nil {
+		return err
+	}
+	log.Printf("Mining block %d on %s at difficulty %d", job.Header.Index, job.Header.PreviousHash, job.Header.Difficulty)
+
+	attempt, abort := context.WithCancel(ctx)
+	defer abort()
+	go c.watch(attempt, abort, job.Header.PreviousHash, refresh)
+
+	nonce, err := blockchain.SearchProof(attempt, job.Header, hashes)
+	if err != nil {
+		if ctx.Err() == nil {
+			log.Printf("New tip, abandoning block %d", job.Header.Index)
+		}
+		return nil
+	}
+	return c.submit(ctx, job.ID, nonce)
+}
+
+// watch asks the node for its tip every refresh and aborts the attempt when the tip has moved.
+// It does not fetch templates: every template is a new job on the node, and enough of them
+// would push out the job being mined.
+func (c *client) watch(ctx context.Context, abort context.CancelFunc, tip string, refresh time.Duration) {
+	ticker := time.NewTicker(refresh)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if current, err := c.tip(ctx); err == nil && current != tip {
+			abort()
+			return
+		}
+	}
+}
+
+// template fetches a block template paying our address
+func (c *client) template(ctx context.Context) (miner.Job, error) {
+	var job miner.Job
+	err := c.get(ctx, "/api/mining/template?miner_address="+url.QueryEscape(c.address), &job)
+	return job, err
+}
+
+// tip fetches the hash of the node's latest block
+func (c *client) tip(ctx context.Context) (string, error) {
+	var tip struct {
+		Hash string `json:"previousHash"`
+	}
+	err := c.get(ctx, "/api/previous-hash", &tip)
+	return tip.Hash, err
+}
+
+// get fetches a path of the node's API and decodes the JSON response into v
+func (c *client) get(ctx context.Context, path string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.node+path, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", c.node+path, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// submit hands the nonce found for a job to the node
+func (c *client) submit(ctx context.Context, id string, nonce int) error {
+	body, err := json.Marshal(map[string]any{"job_id": id, "nonce": nonce})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.node+"/api/mining/submit", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		Hash  string `json:"hash"`
+		Error string `json:"error"`
+	}
+	_ = json.NewDecoder(resp.Body).Decode(&result)
+	switch resp.StatusCode {
+	case http.StatusCreated:
+		log.Printf("Block accepted: %s", result.Hash)
+		return nil
+	case http.StatusConflict:
+		log.Printf("Block was stale: %s", result.Error)
+		return nil
+	default:
+		return errors.New("block refused: " + result.Error)
+	}
+}
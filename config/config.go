@@ -2,17 +2,61 @@ package config
 
 import (
 	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
 )
 
 // Configuration settings for the application
 type Config struct {
-	Port string
+	Port             string
+	TargetBlockTime  time.Duration
+	RetargetInterval int
+	LedgerModel      string
+	ChainID          string
+	NodeAddress      string
+	P2PPort          string
+	P2PAddress       string
+	Seeds            []string
+	TargetPeers      int
+	SyncInterval     time.Duration
+	SyncMaxBackoff   time.Duration
+	MinerAddress     string
+	MiningWorkers    int
+	InitialReward    string
+	HalvingInterval  int
+	MinimumReward    string
+	MaxSupply        string
+	CoinbaseMaturity int
+	AdminToken       string
 }
 
 // Load the configuration from environment variables or defaults
 func LoadConfig() *Config {
+	port := getEnv("PORT", "8080")        // Default to port 8080 if not set
+	p2pPort := getEnv("P2P_PORT", "9090") // Port of the peer-to-peer protocol
 	return &Config{
-		Port: getEnv("PORT", "8080"), // Default to port 8080 if not set
+		Port:             port,
+		TargetBlockTime:  getEnvDuration("TARGET_BLOCK_TIME", 10*time.Second), // Aimed time between blocks
+		RetargetInterval: getEnvInt("RETARGET_INTERVAL", 10),                  // Blocks between difficulty adjustments
+		LedgerModel:      getEnv("LEDGER_MODEL", "account"),                   // "account" or "utxo"
+		ChainID:          getEnv("CHAIN_ID", "blocklite"),                     // Name of the network, peers on another one are refused
+		NodeAddress:      getEnv("NODE_ADDRESS", "localhost:"+port),           // Address other nodes reach us at
+		P2PPort:          p2pPort,
+		P2PAddress:       getEnv("P2P_ADDRESS", "localhost:"+p2pPort),       // Address other nodes reach our peer protocol at
+		Seeds:            getEnvList("SEEDS"),                               // Peer addresses to start discovery from
+		TargetPeers:      getEnvInt("TARGET_PEERS", 8),                      // Outbound peer connections to keep open
+		SyncInterval:     getEnvDuration("SYNC_INTERVAL", 30*time.Second),   // Time between background consensus rounds
+		SyncMaxBackoff:   getEnvDuration("SYNC_MAX_BACKOFF", 5*time.Minute), // Longest wait between rounds while no peer answers
+		MinerAddress:     getEnv("MINER_ADDRESS", ""),                       // Mine in the background from startup, paying this address
+		MiningWorkers:    getEnvInt("MINING_WORKERS", runtime.NumCPU()),     // Goroutines searching for a proof of work together
+		InitialReward:    getEnv("INITIAL_REWARD", "50"),                    // Block reward before the first halving, in MaskedCoins
+		HalvingInterval:  getEnvInt("HALVING_INTERVAL", 210000),             // Blocks between two halvings of the reward
+		MinimumReward:    getEnv("MINIMUM_REWARD", "0.01"),                  // The reward never halves below this
+		MaxSupply:        getEnv("MAX_SUPPLY", "21000000"),                  // No reward is paid once this many MaskedCoins exist
+		CoinbaseMaturity: getEnvInt("COINBASE_MATURITY", 10),                // Confirmations a mining reward needs before it can be spent
+		AdminToken:       getEnv("ADMIN_TOKEN", ""),                         // Bearer token of the admin endpoints, localhost only when empty
 	}
 }
 
@@ -23,3 +67,33 @@ func getEnv(key, defaultValue string) string {
 	}
 	return defaultValue
 }
+
+// Retrieve a comma-separated list from an environment variable, empty if unset
+func getEnvList(key string) []string {
+	list := []string{}
+	for _, value := range strings.Split(getEnv(key, ""), ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			list = append(list, value)
+		}
+	}
+	return list
+}
+
+// Retrieve an integer environment variable or return a default value if unset or malformed
+func getEnvInt(key string, defaultValue int) int {
+	value, err := strconv.Atoi(getEnv(key, ""))
+	if err != nil {
+		return defaultValue
+	}
+	return value
+}
+
+// Retrieve a duration environment variable (e.g. "30s") or return a default value if unset, malformed
+// or not positive: a zero interval would make the loops using it spin
+func getEnvDuration(key string, defaultValue time.Duration) time.Duration {
+	value, err := time.ParseDuration(getEnv(key, ""))
+	if err != nil || value <= 0 {
+		return defaultValue
+	}
+	return value
+}
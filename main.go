@@ -4,7 +4,16 @@ import (
 	"blocklite/api"
 	"blocklite/blockchain"
 	"blocklite/config"
+	"blocklite/miner"
+	"blocklite/p2p"
+	"context"
+	"errors"
 	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
@@ -16,17 +25,93 @@ func main() {
 func testBlockChain() {
 	// Load configuration
 	cfg := config.LoadConfig()
+	blockchain.MiningWorkers = cfg.MiningWorkers
 
-	// Initialize blockchain (singleton)
-	bc := blockchain.NewBlockChain()
+	// Genesis settings shared by every node of the network
+	genesis := blockchain.DefaultGenesis()
+	genesis.ChainID = cfg.ChainID
+	genesis.Ledger = blockchain.LedgerModel(cfg.LedgerModel)
+	genesis.Emission = blockchain.Emission{
+		InitialReward:   parseAmount("INITIAL_REWARD", cfg.InitialReward),
+		HalvingInterval: cfg.HalvingInterval,
+		MinimumReward:   parseAmount("MINIMUM_REWARD", cfg.MinimumReward),
+		MaxSupply:       parseAmount("MAX_SUPPLY", cfg.MaxSupply),
+	}
+	genesis.CoinbaseMaturity = cfg.CoinbaseMaturity
+	genesis.Retarget = blockchain.Retarget{TargetBlockTime: cfg.TargetBlockTime, Interval: cfg.RetargetInterval}
+	if err := genesis.Validate(); err != nil {
+		log.Fatalf("Invalid genesis settings: %v", err)
+	}
+
+	// Initialize blockchain (singleton), refusing a saved chain that does not replay
+	bc, err := blockchain.OpenBlockChain(genesis)
+	if err != nil {
+		log.Fatalf("Failed to load %s: %v", blockchain.BlockchainFile, err)
+	}
+
+	// Announce new blocks and transactions to the registered nodes
+	gossip := blockchain.NewGossip(bc, cfg.NodeAddress)
+
+	// Accept peers on the peer-to-peer protocol and connect to the ones we know
+	book, err := p2p.LoadAddressBook(p2p.AddressBookFile)
+	if err != nil {
+		log.Printf("Ignoring unreadable address book: %v", err)
+		book = p2p.NewAddressBook(p2p.AddressBookFile)
+	}
+	node := p2p.NewNode(bc, cfg.P2PAddress, book)
+	node.SetAPIAddress(cfg.NodeAddress) // peers sync and gossip with us there
+	if err := node.Listen(":" + cfg.P2PPort); err != nil {
+		log.Fatalf("Failed to listen for peers: %v", err)
+	}
+	defer node.Close()
+	node.Discover(cfg.Seeds, cfg.TargetPeers)
+
+	// Keep up with the network in the background
+	syncer := blockchain.NewSyncer(bc, cfg.SyncInterval, cfg.SyncMaxBackoff)
+	syncer.Start()
+	defer syncer.Stop()
+
+	// Mine in the background when asked to, or once /api/miner/start is called
+	m := miner.New(bc)
+	if cfg.MinerAddress != "" {
+		if err := m.Start(cfg.MinerAddress); err != nil {
+			log.Fatalf("Failed to start the miner: %v", err)
+		}
+	}
+	defer m.Stop()
 
 	// Set up Gin router
 	router := gin.Default()
-	api.SetupRoutes(router, bc)
+	api.SetupRoutes(router, bc, gossip, node, syncer, m, cfg.AdminToken)
 
 	// Start server
-	log.Printf("Starting server on port %s", cfg.Port)
-	if err := router.Run(":" + cfg.Port); err != nil {
-		log.Fatalf("Failed to start server: %v", err)
+	server := &http.Server{Addr: ":" + cfg.Port, Handler: router}
+	go func() {
+		log.Printf("Starting server on port %s", cfg.Port)
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatalf("Failed to start server: %v", err)
+		}
+	}()
+
+	// Shut down gracefully on Ctrl-C or SIGTERM: finish the requests in flight, then stop
+	// the miner and the syncer and disconnect the peers
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	<-ctx.Done()
+
+	log.Println("Shutting down")
+	shutdown, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := server.Shutdown(shutdown); err != nil {
+		log.Printf("Server shutdown: %v", err)
+	}
+}
+
+// parseAmount parses an amount of MaskedCoins from the configuration, exiting when it is malformed
+func parseAmount(name, value string) blockchain.Amount {
+	amount, err := blockchain.ParseAmount(value)
+	if err != nil {
+		log.Fatalf("Invalid %s %q: %v", name, value, err)
 	}
+	return amount
 }
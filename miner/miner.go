@@ -0,0 +1,194 @@
+package miner
+
+import (
+	"blocklite/blockchain"
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrRunning is returned by Start when the miner is already running
+var ErrRunning = errors.New("miner is already running")
+
+// RetryDelay is how long the miner waits after a block it found was refused for another
+// reason than a new tip, so a broken template does not spin the CPU
+var RetryDelay = time.Second
+
+// Stats describes the miner since it was last started
+type Stats struct {
+	Running     bool      `json:"running"`
+	Address     string    `json:"address,omitempty"` // receives the rewards
+	Hashrate    float64   `json:"hashrate"`          // hashes per second
+	Hashes      uint64    `json:"hashes"`            // hashes tried
+	BlocksFound int       `json:"blocks_found"`      // blocks added to the chain
+	Aborted     int       `json:"aborted"`           // attempts abandoned because the tip changed
+	StartedAt   time.Time `json:"started_at,omitempty"`
+	StoppedAt   time.Time `json:"stopped_at,omitempty"`
+	LastBlock   string    `json:"last_block,omitempty"` // hash of the last block found
+	LastError   string    `json:"last_error,omitempty"`
+}
+
+// Miner mines blocks in the background until it is stopped. Whenever the tip of the chain
+// changes, because a peer's block arrived or we switched chains, the current attempt is
+// abandoned and the miner starts over on the new tip. It also hands out work to external
+// miners, see Template and Submit.
+type Miner struct {
+	bc     *blockchain.Blockchain
+	hashes atomic.Uint64
+
+	mux    sync.Mutex
+	stats  Stats
+	tip    int                // bumped every time the tip changes
+	abort  context.CancelFunc // abandons the current attempt
+	cancel context.CancelFunc // stops the miner
+	done   chan struct{}
+	jobs   []job // templates handed to external miners, oldest first
+}
+
+// New creates a miner for bc; Start runs it
+func New(bc *blockchain.Blockchain) *Miner {
+	m := &Miner{bc: bc}
+	bc.OnTipChanged(func(blockchain.Block) {
+		m.mux.Lock()
+		defer m.mux.Unlock()
+		m.tip++
+		m.jobs = nil
+		if m.abort != nil {
+			m.abort()
+		}
+	})
+	return m
+}
+
+// Start mines blocks paying address in the background until Stop is called.
+// The statistics start over.
+func (m *Miner) Start(address string) error {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	if m.cancel != nil {
+		return ErrRunning
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	m.cancel, m.done = cancel, make(chan struct{})
+	m.hashes.Store(0)
+	m.stats = Stats{Running: true, Address: address, StartedAt: time.Now()}
+	go m.run(ctx, address, m.done)
+	return nil
+}
+
+// Stop ends mining, abandoning the current attempt, and waits for the miner to return.
+// It reports whether the miner was running.
+func (m *Miner) Stop() bool {
+	m.mux.Lock()
+	cancel, done := m.cancel, m.done
+	m.cancel = nil
+	m.mux.Unlock()
+	if cancel == nil {
+		return false
+	}
+
+	cancel()
+	<-done
+
+	m.mux.Lock()
+	m.stats.Running, m.stats.StoppedAt = false, time.Now()
+	m.mux.Unlock()
+	return true
+}
+
+// Stats returns the statistics of the miner since it was last started
+func (m *Miner) Stats() Stats {
+	m.mux.Lock()
+	stats := m.stats
+	m.mux.Unlock()
+
+	stats.Hashes = m.hashes.Load()
+	if !stats.StartedAt.IsZero() {
+		end := time.Now()
+		if !stats.Running {
+			end = stats.StoppedAt
+		}
+		if elapsed := end.Sub(stats.StartedAt).Seconds(); elapsed > 0 {
+			stats.Hashrate = float64(stats.Hashes) / elapsed
+		}
+	}
+	return stats
+}
+
+// run mines one block after the other until ctx is done
+func (m *Miner) run(ctx context.Context, address string, done chan struct{}) {
+	defer close(done)
+
+	for ctx.Err() == nil {
+		if err := m.mine(ctx, address); err != nil {
+			m.mux.Lock()
+			m.stats.LastError = err.Error()
+			m.mux.Unlock()
+
+			timer := time.NewTimer(RetryDelay)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+			case <-timer.C:
+			}
+		}
+	}
+}
+
+// mine makes one attempt at a block on top of the current tip. It returns an error only
+// when the block it found was refused for another reason than a new tip.
+func (m *Miner) mine(ctx context.Context, address string) error {
+	m.mux.Lock()
+	tip := m.tip
+	m.mux.Unlock()
+
+	block := m.bc.NewBlockTemplate(address)
+
+	attempt, abort := context.WithCancel(ctx)
+	defer abort()
+	m.mux.Lock()
+	if m.tip != tip {
+		// The tip moved while we built the template
+		m.stats.Aborted++
+		m.mux.Unlock()
+		return nil
+	}
+	m.abort = abort
+	m.mux.Unlock()
+
+	proof, err := blockchain.SearchProof(attempt, block.Header(), &m.hashes)
+
+	m.mux.Lock()
+	m.abort = nil
+	if err != nil && ctx.Err() == nil {
+		m.stats.Aborted++
+	}
+	m.mux.Unlock()
+	if err != nil {
+		return nil
+	}
+
+	block.Proof = proof
+	err = m.bc.AddBlock(block)
+	if errors.Is(err, blockchain.ErrStaleBlock) {
+		m.mux.Lock()
+		m.stats.Aborted++
+		m.mux.Unlock()
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("block %d refused: %w", block.Index, err)
+	}
+
+	hash := block.CalculateHash()
+	fmt.Printf("Mined block %d: %s\n", block.Index, hash)
+	m.mux.Lock()
+	m.stats.BlocksFound++
+	m.stats.LastBlock = hash
+	m.mux.Unlock()
+	return nil
+}
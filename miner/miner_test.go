@@ -0,0 +1,87 @@
+package miner
+
+import (
+	"blocklite/blockchain"
+	"errors"
+	"os"
+	"testing"
+	"time"
+)
+
+// TestMinerFindsBlocks starts the miner, waits for a few blocks and stops it.
+func TestMinerFindsBlocks(t *testing.T) {
+	os.Remove(blockchain.BlockchainFile)
+	defer os.Remove(blockchain.BlockchainFile)
+
+	bc := blockchain.NewBlockChain()
+	m := New(bc)
+	if err := m.Start("miner"); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	if err := m.Start("miner"); !errors.Is(err, ErrRunning) {
+		t.Errorf("Start() while running error = %v; want %v", err, ErrRunning)
+	}
+
+	for deadline := time.Now().Add(10 * time.Second); bc.GetLength() < 3 && time.Now().Before(deadline); {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if !m.Stop() {
+		t.Error("Stop() = false; want true for a running miner")
+	}
+	if m.Stop() {
+		t.Error("Stop() = true; want false for a stopped miner")
+	}
+
+	stats := m.Stats()
+	if stats.Running || stats.BlocksFound < 2 || stats.BlocksFound != bc.GetLength()-1 {
+		t.Errorf("Stats() = %+v; want a stopped miner that found the %d blocks after genesis", stats, bc.GetLength()-1)
+	}
+	if stats.Hashes == 0 || stats.Hashrate <= 0 || stats.LastBlock != bc.Chain[len(bc.Chain)-1].CalculateHash() {
+		t.Errorf("Stats() = %+v; want hashes, a hashrate and the last block", stats)
+	}
+	if err := bc.ValidChain(bc.Chain); err != nil {
+		t.Errorf("ValidChain() error = %v", err)
+	}
+
+	// Nothing is mined once stopped
+	length := bc.GetLength()
+	time.Sleep(50 * time.Millisecond)
+	if bc.GetLength() != length {
+		t.Errorf("GetLength() = %d after Stop(); want %d", bc.GetLength(), length)
+	}
+}
+
+// TestMinerAbortsOnNewTip adds blocks mined elsewhere while the miner runs; every one of
+// them makes the miner give up its attempt on the old tip.
+func TestMinerAbortsOnNewTip(t *testing.T) {
+	os.Remove(blockchain.BlockchainFile)
+	defer os.Remove(blockchain.BlockchainFile)
+
+	bc := blockchain.NewBlockChain()
+	m := New(bc)
+	if err := m.Start("miner"); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	defer m.Stop()
+
+	for i := 0; i < 5; i++ {
+		if _, err := bc.CreateBlock("peer"); err != nil {
+			t.Fatalf("CreateBlock() error = %v", err)
+		}
+	}
+	m.Stop()
+
+	stats := m.Stats()
+	if stats.Aborted == 0 {
+		t.Errorf("Stats() = %+v; want attempts aborted by the new tips", stats)
+	}
+	if stats.LastError != "" {
+		t.Errorf("LastError = %q; want none", stats.LastError)
+	}
+	if bc.GetLength() != 1+5+stats.BlocksFound {
+		t.Errorf("GetLength() = %d; want genesis, 5 blocks from the peer and %d mined", bc.GetLength(), stats.BlocksFound)
+	}
+	if err := bc.ValidChain(bc.Chain); err != nil {
+		t.Errorf("ValidChain() error = %v", err)
+	}
+}
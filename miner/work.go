@@ -0,0 +1,91 @@
+package miner
+
+import (
+	"blocklite/blockchain"
+	"errors"
+	"fmt"
+)
+
+// MaxJobs is the number of templates kept for external miners; older ones are forgotten
+var MaxJobs = 64
+
+var (
+	ErrUnknownJob   = errors.New("unknown or stale job")
+	ErrInvalidProof = errors.New("nonce does not meet the difficulty")
+)
+
+// Job is a block template handed to an external miner. The miner looks for a nonce that
+// makes the hash of Header at most Target, then submits it with the job ID.
+type Job struct {
+	ID           string                 `json:"id"`
+	Header       blockchain.BlockHeader `json:"header"`
+	Target       string                 `json:"target"` // largest valid header hash, in hex
+	Transactions int                    `json:"transactions"`
+}
+
+// job is a template we handed out, with the transactions it commits to
+type job struct {
+	id    string
+	block blockchain.Block
+}
+
+// Template builds a block paying address on top of the current tip and hands out its
+// header. The template is kept until the tip changes, so the block can be assembled again
+// when a nonce is submitted.
+func (m *Miner) Template(address string) Job {
+	m.mux.Lock()
+	tip := m.tip
+	m.mux.Unlock()
+
+	block := m.bc.NewBlockTemplate(address)
+	header := block.Header()
+	id := block.CalculateHash()
+
+	m.mux.Lock()
+	if m.tip == tip && m.job(id) == nil {
+		m.jobs = append(m.jobs, job{id: id, block: block})
+		if len(m.jobs) > MaxJobs {
+			m.jobs = m.jobs[len(m.jobs)-MaxJobs:]
+		}
+	}
+	m.mux.Unlock()
+
+	return Job{ID: id, Header: header, Target: blockchain.Target(header.Difficulty), Transactions: len(block.Transactions)}
+}
+
+// Submit completes the template with the given ID with nonce and adds it to the chain.
+// It returns ErrUnknownJob when the template is unknown or the tip has changed since it
+// was handed out, and ErrInvalidProof when the nonce does not meet the difficulty.
+func (m *Miner) Submit(id string, nonce int) (blockchain.Block, error) {
+	m.mux.Lock()
+	j := m.job(id)
+	m.mux.Unlock()
+	if j == nil {
+		return blockchain.Block{}, ErrUnknownJob
+	}
+
+	block := j.block
+	block.Proof = nonce
+	if valid, _ := blockchain.VerifyProof(block.Header()); !valid {
+		return blockchain.Block{}, ErrInvalidProof
+	}
+	if err := m.bc.AddBlock(block); err != nil {
+		if errors.Is(err, blockchain.ErrStaleBlock) {
+			return blockchain.Block{}, fmt.Errorf("%w: %v", ErrUnknownJob, err)
+		}
+		return blockchain.Block{}, err
+	}
+
+	fmt.Printf("Block %d submitted by an external miner: %s\n", block.Index, block.CalculateHash())
+	return block, nil
+}
+
+// job returns the template with the given ID, or nil. The caller must hold m.mux.
+func (m *Miner) job(id string) *job {
+	for i := range m.jobs {
+		if m.jobs[i].id == id {
+			return &m.jobs[i]
+		}
+	}
+	return nil
+}
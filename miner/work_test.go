@@ -0,0 +1,80 @@
+package miner
+
+import (
+	"blocklite/blockchain"
+	"context"
+	"errors"
+	"os"
+	"testing"
+)
+
+// TestSubmitWork mines a template handed out to an external miner and submits it.
+func TestSubmitWork(t *testing.T) {
+	os.Remove(blockchain.BlockchainFile)
+	defer os.Remove(blockchain.BlockchainFile)
+
+	bc := blockchain.NewBlockChain()
+	m := New(bc)
+	job := m.Template("external")
+	if job.Header.Index != 2 || job.Header.PreviousHash != bc.Chain[0].CalculateHash() || job.Transactions != 1 {
+		t.Fatalf("Template() = %+v; want the coinbase of block 2 on top of genesis", job)
+	}
+	if job.Target != blockchain.Target(blockchain.InitialDifficulty) {
+		t.Errorf("Target = %s; want %s", job.Target, blockchain.Target(blockchain.InitialDifficulty))
+	}
+
+	nonce, err := blockchain.SearchProof(context.Background(), job.Header, nil)
+	if err != nil {
+		t.Fatalf("SearchProof() error = %v", err)
+	}
+	invalid := nonce + 1
+	for header := job.Header; ; invalid++ {
+		header.Nonce = invalid
+		if valid, _ := blockchain.VerifyProof(header); !valid {
+			break
+		}
+	}
+	if _, err := m.Submit(job.ID, invalid); !errors.Is(err, ErrInvalidProof) {
+		t.Errorf("Submit() with a bad nonce error = %v; want %v", err, ErrInvalidProof)
+	}
+	if _, err := m.Submit("unknown", nonce); !errors.Is(err, ErrUnknownJob) {
+		t.Errorf("Submit() of an unknown job error = %v; want %v", err, ErrUnknownJob)
+	}
+
+	block, err := m.Submit(job.ID, nonce)
+	if err != nil {
+		t.Fatalf("Submit() error = %v", err)
+	}
+	if bc.GetLength() != 2 || bc.Chain[1].CalculateHash() != block.CalculateHash() || bc.Chain[1].Transactions[0].Receiver != "external" {
+		t.Errorf("Chain = %+v; want the submitted block paying external on top", bc.Chain)
+	}
+
+	// The tip has moved, so the job is stale
+	if _, err := m.Submit(job.ID, nonce); !errors.Is(err, ErrUnknownJob) {
+		t.Errorf("Submit() of a stale job error = %v; want %v", err, ErrUnknownJob)
+	}
+}
+
+// TestTemplateForgetsStaleJobs drops templates once another block extends the tip.
+func TestTemplateForgetsStaleJobs(t *testing.T) {
+	os.Remove(blockchain.BlockchainFile)
+	defer os.Remove(blockchain.BlockchainFile)
+
+	bc := blockchain.NewBlockChain()
+	m := New(bc)
+	job := m.Template("external")
+	nonce, err := blockchain.SearchProof(context.Background(), job.Header, nil)
+	if err != nil {
+		t.Fatalf("SearchProof() error = %v", err)
+	}
+
+	if _, err := bc.CreateBlock("peer"); err != nil {
+		t.Fatalf("CreateBlock() error = %v", err)
+	}
+	if _, err := m.Submit(job.ID, nonce); !errors.Is(err, ErrUnknownJob) {
+		t.Errorf("Submit() after a new tip error = %v; want %v", err, ErrUnknownJob)
+	}
+	if bc.GetLength() != 2 {
+		t.Errorf("GetLength() = %d; want 2", bc.GetLength())
+	}
+}
@@ -0,0 +1,216 @@
+package p2p
+
+import (
+	"encoding/json"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// AddressBookFile is where a node keeps the addresses of the peers it knows
+const AddressBookFile = "peers.json"
+
+// Address book limits
+const (
+	MaxKnownAddresses = 1000 // the least recently seen addresses are forgotten beyond this
+	MaxAddrPerMessage = 100  // addresses sent in one addr message
+	MaxFailedAttempts = 10   // failed connections in a row after which an address is forgotten
+)
+
+// KnownAddress is an entry of the address book
+type KnownAddress struct {
+	Address     string    `json:"address"`
+	LastSeen    time.Time `json:"last_seen"`              // last time we, or a peer telling us about it, saw the node up
+	LastAttempt time.Time `json:"last_attempt,omitempty"` // last time we tried to connect
+	Failures    int       `json:"failures"`               // failed connections since the last success
+}
+
+// AddressBook remembers the addresses of other nodes across restarts
+type AddressBook struct {
+	path  string // file the book is saved to, none when empty
+	mux   sync.Mutex
+	addrs map[string]*KnownAddress
+}
+
+// addressBookFile is the format of the address book on disk
+type addressBookFile struct {
+	Addresses []KnownAddress `json:"addresses"`
+}
+
+// NewAddressBook returns an empty book saved to path, or kept in memory when path is empty
+func NewAddressBook(path string) *AddressBook {
+	return &AddressBook{path: path, addrs: make(map[string]*KnownAddress)}
+}
+
+// LoadAddressBook reads the book saved at path. A missing file gives an empty book.
+func LoadAddressBook(path string) (*AddressBook, error) {
+	book := NewAddressBook(path)
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return book, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var file addressBookFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, err
+	}
+	for _, known := range file.Addresses {
+		if known.Address != "" {
+			book.addrs[known.Address] = &known
+		}
+	}
+	return book, nil
+}
+
+// Add records an address a peer told us about, seen at lastSeen. Times in the future are
+// taken as now, and an older sighting never replaces a newer one.
+func (b *AddressBook) Add(address string, lastSeen time.Time) {
+	b.AddAll([]NetAddress{{Address: address, LastSeen: lastSeen}})
+}
+
+// AddAll records the addresses of an addr message like Add, and saves the book once for all
+// of them
+func (b *AddressBook) AddAll(addrs []NetAddress) {
+	now := time.Now()
+
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	added := false
+	for _, addr := range addrs {
+		if addr.Address == "" {
+			continue
+		}
+		added = true
+		lastSeen := addr.LastSeen
+		if lastSeen.After(now) {
+			lastSeen = now
+		}
+		known, ok := b.addrs[addr.Address]
+		if !ok {
+			known = &KnownAddress{Address: addr.Address}
+			b.addrs[addr.Address] = known
+		}
+		if lastSeen.After(known.LastSeen) {
+			known.LastSeen = lastSeen
+		}
+	}
+	if added {
+		b.evict()
+		b.save()
+	}
+}
+
+// Failed records a failed connection to address. Addresses failing MaxFailedAttempts times
+// in a row are forgotten.
+func (b *AddressBook) Failed(address string) {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	known, ok := b.addrs[address]
+	if !ok {
+		known = &KnownAddress{Address: address}
+		b.addrs[address] = known
+	}
+	known.LastAttempt = time.Now()
+	known.Failures++
+	if known.Failures >= MaxFailedAttempts {
+		delete(b.addrs, address)
+	}
+	b.save()
+}
+
+// Good records a successful connection to address
+func (b *AddressBook) Good(address string) {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	known, ok := b.addrs[address]
+	if !ok {
+		known = &KnownAddress{Address: address}
+		b.addrs[address] = known
+	}
+	known.LastSeen = time.Now()
+	known.LastAttempt = known.LastSeen
+	known.Failures = 0
+	b.evict()
+	b.save()
+}
+
+// Remove forgets an address, e.g. one of our own or of a node on another chain
+func (b *AddressBook) Remove(address string) {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	if _, ok := b.addrs[address]; ok {
+		delete(b.addrs, address)
+		b.save()
+	}
+}
+
+// Get returns the entry of an address
+func (b *AddressBook) Get(address string) (KnownAddress, bool) {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	known, ok := b.addrs[address]
+	if !ok {
+		return KnownAddress{}, false
+	}
+	return *known, true
+}
+
+// Addresses returns up to max known addresses, most recently seen first
+func (b *AddressBook) Addresses(max int) []KnownAddress {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	addrs := b.sorted()
+	if len(addrs) > max {
+		addrs = addrs[:max]
+	}
+	return addrs
+}
+
+// Len returns the number of known addresses
+func (b *AddressBook) Len() int {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	return len(b.addrs)
+}
+
+// sorted returns the entries most recently seen first. The caller must hold b.mux.
+func (b *AddressBook) sorted() []KnownAddress {
+	addrs := make([]KnownAddress, 0, len(b.addrs))
+	for _, known := range b.addrs {
+		addrs = append(addrs, *known)
+	}
+	sort.Slice(addrs, func(i, j int) bool {
+		if !addrs[i].LastSeen.Equal(addrs[j].LastSeen) {
+			return addrs[i].LastSeen.After(addrs[j].LastSeen)
+		}
+		return addrs[i].Address < addrs[j].Address
+	})
+	return addrs
+}
+
+// evict forgets the least recently seen addresses beyond MaxKnownAddresses. The caller must hold b.mux.
+func (b *AddressBook) evict() {
+	if len(b.addrs) <= MaxKnownAddresses {
+		return
+	}
+	for _, known := range b.sorted()[MaxKnownAddresses:] {
+		delete(b.addrs, known.Address)
+	}
+}
+
+// save writes the book to its file. The caller must hold b.mux.
+func (b *AddressBook) save() {
+	if b.path == "" {
+		return
+	}
+	data, err := json.MarshalIndent(addressBookFile{Addresses: b.sorted()}, "", "  ")
+	if err != nil {
+		return
+	}
+	_ = os.WriteFile(b.path, data, 0644)
+}
@@ -0,0 +1,75 @@
+package p2p
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestAddressBookPersists(t *testing.T) {
+	path := filepath.Join(t.TempDir(), AddressBookFile)
+	seen := time.Now().Add(-time.Hour).Truncate(time.Second)
+
+	book := NewAddressBook(path)
+	book.Add("a:9090", seen)
+	book.Add("a:9090", seen.Add(-time.Hour)) // an older sighting is ignored
+	book.Good("b:9090")
+	book.Failed("c:9090")
+	book.Add("", seen)
+
+	loaded, err := LoadAddressBook(path)
+	if err != nil {
+		t.Fatalf("LoadAddressBook() error = %v", err)
+	}
+	want := book.Addresses(10)
+	for i, got := range loaded.Addresses(10) {
+		if got.Address != want[i].Address || !got.LastSeen.Equal(want[i].LastSeen) ||
+			!got.LastAttempt.Equal(want[i].LastAttempt) || got.Failures != want[i].Failures {
+			t.Errorf("Loaded address %d = %+v; want %+v", i, got, want[i])
+		}
+	}
+
+	addrs := loaded.Addresses(10)
+	if len(addrs) != 3 || addrs[0].Address != "b:9090" || addrs[1].Address != "a:9090" || addrs[2].Address != "c:9090" {
+		t.Fatalf("Addresses() = %+v; want b, a, c", addrs)
+	}
+	if !addrs[1].LastSeen.Equal(seen) || addrs[2].Failures != 1 {
+		t.Errorf("Addresses() = %+v; want a seen at %v and one failure for c", addrs, seen)
+	}
+
+	if missing, err := LoadAddressBook(filepath.Join(t.TempDir(), "missing.json")); err != nil || missing.Len() != 0 {
+		t.Errorf("LoadAddressBook() of a missing file = %d addresses, %v; want an empty book", missing.Len(), err)
+	}
+}
+
+func TestAddressBookForgets(t *testing.T) {
+	book := NewAddressBook("")
+
+	for i := 0; i < MaxFailedAttempts; i++ {
+		book.Failed("down:9090")
+	}
+	if _, ok := book.Get("down:9090"); ok {
+		t.Errorf("Address failing %d times is still known", MaxFailedAttempts)
+	}
+
+	book.Failed("flaky:9090")
+	book.Good("flaky:9090")
+	if known, _ := book.Get("flaky:9090"); known.Failures != 0 {
+		t.Errorf("Failures after a good connection = %d; want 0", known.Failures)
+	}
+
+	oldest := time.Now().Add(-24 * time.Hour)
+	book.Add("oldest:9090", oldest)
+	addrs := make([]NetAddress, MaxKnownAddresses)
+	for i := range addrs {
+		addrs[i] = NetAddress{Address: fmt.Sprintf("node%d:9090", i), LastSeen: oldest.Add(time.Duration(i+1) * time.Second)}
+	}
+	book.AddAll(addrs)
+	if book.Len() != MaxKnownAddresses {
+		t.Errorf("Len() = %d; want %d", book.Len(), MaxKnownAddresses)
+	}
+	if _, ok := book.Get("oldest:9090"); ok {
+		t.Error("Least recently seen address was not evicted")
+	}
+}
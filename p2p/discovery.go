@@ -0,0 +1,142 @@
+package p2p
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Discovery timing
+var (
+	DiscoveryInterval = 30 * time.Second // time between checks of the outbound peer count
+	RetryInterval     = 5 * time.Minute  // an address that failed is not tried again before this
+)
+
+// Discover keeps about target outbound connections open in the background until Close is
+// called. Candidates come from the address book, most recently seen first, then from the
+// seed nodes. Every outbound peer is asked for the addresses it knows.
+func (n *Node) Discover(seeds []string, target int) {
+	n.wg.Add(1)
+	go func() {
+		defer n.wg.Done()
+
+		ticker := time.NewTicker(DiscoveryInterval)
+		defer ticker.Stop()
+		for {
+			n.maintainPeers(seeds, target)
+			select {
+			case <-n.quit:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// AddressBook returns the addresses the node knows
+func (n *Node) AddressBook() *AddressBook {
+	return n.book
+}
+
+// maintainPeers opens outbound connections until there are target of them or no candidate is left
+func (n *Node) maintainPeers(seeds []string, target int) {
+	for _, address := range n.candidates(seeds) {
+		if n.outbound() >= target {
+			return
+		}
+		select {
+		case <-n.quit:
+			return
+		default:
+		}
+
+		if _, err := n.Connect(address); err != nil {
+			if errors.Is(err, ErrSelfConnection) || errors.Is(err, ErrChainMismatch) {
+				n.book.Remove(address) // never worth trying again
+			} else {
+				n.book.Failed(address)
+			}
+			fmt.Printf("Connection to %s failed: %v\n", address, err)
+		}
+	}
+}
+
+// candidates returns the addresses worth connecting to: known or seed addresses we are not
+// connected to, which have not failed recently. Banned nodes are only known by their peer ID
+// after the handshake, which refuses them.
+func (n *Node) candidates(seeds []string) []string {
+	connected := map[string]bool{n.address: true}
+	n.mux.Lock()
+	for peer := range n.peers {
+		connected[peer.dialed] = true
+		connected[peer.version.Address] = true
+	}
+	n.mux.Unlock()
+
+	candidates := []string{}
+	addresses := n.book.Addresses(MaxKnownAddresses)
+	for _, seed := range seeds {
+		if _, ok := n.book.Get(seed); !ok {
+			addresses = append(addresses, KnownAddress{Address: seed})
+		}
+	}
+	for _, known := range addresses {
+		if known.Address == "" || connected[known.Address] {
+			continue
+		}
+		if known.Failures > 0 && time.Since(known.LastAttempt) < RetryInterval {
+			continue
+		}
+		connected[known.Address] = true
+		candidates = append(candidates, known.Address)
+	}
+	return candidates
+}
+
+// outbound returns the number of connections we opened
+func (n *Node) outbound() int {
+	n.mux.Lock()
+	defer n.mux.Unlock()
+
+	count := 0
+	for peer := range n.peers {
+		if !peer.inbound {
+			count++
+		}
+	}
+	return count
+}
+
+// handleGetAddr answers a getaddr with the addresses we know, but the peer's own
+func (n *Node) handleGetAddr(peer *Peer, msg Message) error {
+	reply := AddrMessage{Addresses: []NetAddress{}}
+	for _, known := range n.book.Addresses(MaxAddrPerMessage + 1) {
+		if known.Address == peer.version.Address || known.LastSeen.IsZero() {
+			continue
+		}
+		if len(reply.Addresses) == MaxAddrPerMessage {
+			break
+		}
+		reply.Addresses = append(reply.Addresses, NetAddress{Address: known.Address, LastSeen: known.LastSeen})
+	}
+	return peer.Send(MsgAddr, reply)
+}
+
+// handleAddr adds the addresses a peer sent to the address book
+func (n *Node) handleAddr(peer *Peer, msg Message) error {
+	var addr AddrMessage
+	if err := msg.Decode(&addr); err != nil {
+		return err
+	}
+	if len(addr.Addresses) > MaxAddrPerMessage {
+		return fmt.Errorf("%d addresses in one addr message, at most %d allowed", len(addr.Addresses), MaxAddrPerMessage)
+	}
+	addrs := make([]NetAddress, 0, len(addr.Addresses))
+	for _, address := range addr.Addresses {
+		if address.Address != n.address {
+			addrs = append(addrs, address)
+		}
+	}
+	n.book.AddAll(addrs)
+	return nil
+}
@@ -0,0 +1,100 @@
+package p2p
+
+import (
+	"blocklite/blockchain"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+// announcedNode starts a node listening on a free local port and announcing that address
+func announcedNode(t *testing.T, bc *blockchain.Blockchain) *Node {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	address := listener.Addr().String()
+	listener.Close()
+
+	n := NewNode(bc, address, nil)
+	if err := n.Listen(address); err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	t.Cleanup(n.Close)
+	return n
+}
+
+// TestDiscover lets C, seeded with A only, find B through the addresses A knows.
+func TestDiscover(t *testing.T) {
+	os.Remove(blockchain.BlockchainFile)
+	defer os.Remove(blockchain.BlockchainFile)
+
+	interval := DiscoveryInterval
+	t.Cleanup(func() { DiscoveryInterval = interval }) // after the nodes are closed
+	DiscoveryInterval = 20 * time.Millisecond
+
+	bc := blockchain.NewBlockChain()
+	a := announcedNode(t, bc)
+	b := announcedNode(t, copyChain(bc))
+	c := announcedNode(t, copyChain(bc))
+
+	// B connects to A, so A learns where B listens
+	if _, err := b.Connect(a.address); err != nil {
+		t.Fatalf("Connect() error = %v", err)
+	}
+	waitForPeers(t, a, 1)
+
+	c.Discover([]string{a.address, c.address, "127.0.0.1:1"}, 2)
+	for deadline := time.Now().Add(2 * time.Second); c.outbound() < 2 && time.Now().Before(deadline); {
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	peers := c.Peers()
+	if len(peers) != 2 {
+		t.Fatalf("c.Peers() = %+v; want A and B", peers)
+	}
+	for _, address := range []string{a.address, b.address} {
+		if known, ok := c.AddressBook().Get(address); !ok || known.Failures != 0 {
+			t.Errorf("Address book entry of %s = %+v, %v; want a good address", address, known, ok)
+		}
+	}
+	if _, ok := c.AddressBook().Get(c.address); ok {
+		t.Error("Node keeps its own address in its address book")
+	}
+	if known, ok := c.AddressBook().Get("127.0.0.1:1"); !ok || known.Failures != 1 {
+		t.Errorf("Address book entry of an unreachable seed = %+v, %v; want one failure", known, ok)
+	}
+
+	// Connected peers are not dialed twice
+	c.maintainPeers([]string{a.address}, 3)
+	if n := len(c.Peers()); n != 2 {
+		t.Errorf("len(Peers()) = %d; want 2", n)
+	}
+}
+
+func TestHandleAddr(t *testing.T) {
+	os.Remove(blockchain.BlockchainFile)
+	defer os.Remove(blockchain.BlockchainFile)
+
+	n := NewNode(blockchain.NewBlockChain(), "self:9090", nil)
+	seen := time.Now().Add(-time.Minute)
+
+	msg, _ := NewMessage(MsgAddr, AddrMessage{Addresses: []NetAddress{
+		{Address: "peer:9090", LastSeen: seen},
+		{Address: "self:9090", LastSeen: seen},
+	}})
+	if err := n.handleAddr(nil, msg); err != nil {
+		t.Fatalf("handleAddr() error = %v", err)
+	}
+	if addrs := n.AddressBook().Addresses(10); len(addrs) != 1 || addrs[0].Address != "peer:9090" {
+		t.Errorf("Addresses() = %+v; want only peer:9090", addrs)
+	}
+
+	flood := AddrMessage{Addresses: make([]NetAddress, MaxAddrPerMessage+1)}
+	msg, _ = NewMessage(MsgAddr, flood)
+	if err := n.handleAddr(nil, msg); err == nil {
+		t.Errorf("handleAddr() of %d addresses error = nil", len(flood.Addresses))
+	}
+}
@@ -0,0 +1,143 @@
+package p2p
+
+import (
+	"blocklite/blockchain"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// Protocol versions. A peer speaking a version below MinProtocolVersion is refused.
+const (
+	ProtocolVersion    = 1
+	MinProtocolVersion = 1
+)
+
+// MaxMessageSize bounds the length of a single frame
+const MaxMessageSize = 32 << 20
+
+// MaxHandshakeMessageSize bounds the length of a frame during the handshake, before we know
+// whether the peer is on our chain
+const MaxHandshakeMessageSize = 4 << 10
+
+// Message types
+const (
+	MsgVersion = "version" // first message of the handshake, sent by both sides
+	MsgVerack  = "verack"  // acknowledges the version of the other side
+	MsgReject  = "reject"  // explains why the handshake was refused, then the connection is closed
+	MsgPing    = "ping"
+	MsgPong    = "pong"
+	MsgGetAddr = "getaddr" // asks a peer for addresses of other nodes
+	MsgAddr    = "addr"    // answers getaddr
+)
+
+// ErrMessageTooLarge is returned for frames longer than MaxMessageSize
+var ErrMessageTooLarge = errors.New("message too large")
+
+// Message is a single frame on the wire. Frames are the length of the JSON encoded message
+// as a 4 byte big-endian integer, followed by the message itself.
+type Message struct {
+	Type    string          `json:"type"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+// VersionMessage introduces a node during the handshake
+type VersionMessage struct {
+	Version     int    `json:"version"`
+	ChainID     string `json:"chain_id"`
+	GenesisHash string `json:"genesis_hash"`
+	BestHeight  int    `json:"best_height"`
+	Address     string `json:"address,omitempty"`     // address the node accepts peers on
+	APIAddress  string `json:"api_address,omitempty"` // address of the node's HTTP API, where it syncs and gossips
+	Nonce       uint64 `json:"nonce"`                 // random per node, detects connections to ourselves
+}
+
+// PingMessage is sent periodically to keep a connection alive; the peer echoes the nonce in a pong
+type PingMessage struct {
+	Nonce uint64 `json:"nonce"`
+}
+
+// AddrMessage lists nodes the sender knows about
+type AddrMessage struct {
+	Addresses []NetAddress `json:"addresses"`
+}
+
+// NetAddress is a node address with the last time it was seen up
+type NetAddress struct {
+	Address  string    `json:"address"`
+	LastSeen time.Time `json:"last_seen"`
+}
+
+// RejectMessage tells a peer why it was refused
+type RejectMessage struct {
+	Reason string `json:"reason"`
+}
+
+// NewMessage builds a message with a JSON encoded payload. A nil payload is left out.
+func NewMessage(msgType string, payload any) (Message, error) {
+	msg := Message{Type: msgType}
+	if payload != nil {
+		data, err := json.Marshal(payload)
+		if err != nil {
+			return Message{}, err
+		}
+		msg.Payload = data
+	}
+	return msg, nil
+}
+
+// Decode decodes the payload of a message into v
+func (m Message) Decode(v any) error {
+	if err := json.Unmarshal(m.Payload, v); err != nil {
+		return fmt.Errorf("%w: %s payload: %w", blockchain.ErrMalformed, m.Type, err)
+	}
+	return nil
+}
+
+// WriteMessage writes a message as one frame
+func WriteMessage(w io.Writer, msg Message) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	if len(data) > MaxMessageSize {
+		return fmt.Errorf("%w: %d bytes", ErrMessageTooLarge, len(data))
+	}
+
+	frame := make([]byte, 4+len(data))
+	binary.BigEndian.PutUint32(frame, uint32(len(data)))
+	copy(frame[4:], data)
+	_, err = w.Write(frame)
+	return err
+}
+
+// ReadMessage reads one frame. Frames longer than MaxMessageSize are refused before
+// their body is read.
+func ReadMessage(r io.Reader) (Message, error) {
+	return readMessage(r, MaxMessageSize)
+}
+
+// readMessage reads one frame of at most limit bytes
+func readMessage(r io.Reader, limit uint32) (Message, error) {
+	var length [4]byte
+	if _, err := io.ReadFull(r, length[:]); err != nil {
+		return Message{}, err
+	}
+	size := binary.BigEndian.Uint32(length[:])
+	if size > limit {
+		return Message{}, fmt.Errorf("%w: %d bytes", ErrMessageTooLarge, size)
+	}
+
+	data := make([]byte, size)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return Message{}, err
+	}
+	var msg Message
+	if err := json.Unmarshal(data, &msg); err != nil {
+		return Message{}, fmt.Errorf("%w: frame: %w", blockchain.ErrMalformed, err)
+	}
+	return msg, nil
+}
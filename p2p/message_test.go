@@ -0,0 +1,80 @@
+package p2p
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestMessageFraming(t *testing.T) {
+	version := VersionMessage{Version: ProtocolVersion, ChainID: "test", GenesisHash: "abc", BestHeight: 7, Nonce: 42}
+
+	var buf bytes.Buffer
+	for _, payload := range []any{version, nil, PingMessage{Nonce: 1}} {
+		msg, err := NewMessage(MsgVersion, payload)
+		if err != nil {
+			t.Fatalf("NewMessage() error = %v", err)
+		}
+		if err := WriteMessage(&buf, msg); err != nil {
+			t.Fatalf("WriteMessage() error = %v", err)
+		}
+	}
+
+	// Frames are read back one at a time, in order
+	msg, err := ReadMessage(&buf)
+	if err != nil {
+		t.Fatalf("ReadMessage() error = %v", err)
+	}
+	var got VersionMessage
+	if err := msg.Decode(&got); err != nil || !reflect.DeepEqual(got, version) {
+		t.Errorf("Decode() = %+v, %v; want %+v", got, err, version)
+	}
+	if msg, err := ReadMessage(&buf); err != nil || msg.Type != MsgVersion || msg.Payload != nil {
+		t.Errorf("ReadMessage() = %+v, %v; want an empty version message", msg, err)
+	}
+	var ping PingMessage
+	if msg, err := ReadMessage(&buf); err != nil || msg.Decode(&ping) != nil || ping.Nonce != 1 {
+		t.Errorf("ReadMessage() = %+v, %v; want a ping with nonce 1", msg, err)
+	}
+}
+
+func TestReadMessageRejectsBadFrames(t *testing.T) {
+	tests := []struct {
+		name  string
+		frame []byte
+		want  error
+	}{
+		{"Too large", binary.BigEndian.AppendUint32(nil, MaxMessageSize+1), ErrMessageTooLarge},
+		{"Truncated", append(binary.BigEndian.AppendUint32(nil, 10), "{}"...), nil},
+		{"Not JSON", append(binary.BigEndian.AppendUint32(nil, 3), "abc"...), nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ReadMessage(bytes.NewReader(tt.frame))
+			if err == nil {
+				t.Fatal("ReadMessage() error = nil")
+			}
+			if tt.want != nil && !errors.Is(err, tt.want) {
+				t.Errorf("ReadMessage() error = %v; want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestHandshakeFrameLimit(t *testing.T) {
+	frame := binary.BigEndian.AppendUint32(nil, MaxHandshakeMessageSize+1)
+	if _, err := readMessage(bytes.NewReader(frame), MaxHandshakeMessageSize); !errors.Is(err, ErrMessageTooLarge) {
+		t.Errorf("readMessage() error = %v; want %v", err, ErrMessageTooLarge)
+	}
+
+	// The same frame is fine once the handshake is done
+	frame = append(frame, `{"type":"ping","payload":"`...)
+	frame = append(frame, bytes.Repeat([]byte("a"), MaxHandshakeMessageSize+1-len(`{"type":"ping","payload":"`)-2)...)
+	frame = append(frame, `"}`...)
+	if _, err := ReadMessage(bytes.NewReader(frame)); errors.Is(err, ErrMessageTooLarge) {
+		t.Errorf("ReadMessage() error = %v after the handshake", err)
+	}
+}
@@ -0,0 +1,400 @@
+package p2p
+
+import (
+	"blocklite/blockchain"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Handshake errors
+var (
+	ErrIncompatibleVersion = errors.New("incompatible protocol version")
+	ErrChainMismatch       = errors.New("peer is on another chain")
+	ErrSelfConnection      = errors.New("connected to ourselves")
+	ErrRejected            = errors.New("rejected by peer")
+	ErrUnexpectedMessage   = errors.New("unexpected message")
+)
+
+// HandlerFunc handles a message received from a peer. Returning an error disconnects the peer.
+type HandlerFunc func(peer *Peer, msg Message) error
+
+// Node speaks the peer protocol for a blockchain: it accepts and opens TCP connections, runs
+// the handshake on each, keeps them alive with pings and dispatches the other messages to
+// the registered handlers.
+type Node struct {
+	bc      *blockchain.Blockchain
+	address string // address we accept peers on, announced in the handshake
+	nonce   uint64 // random, detects connections to ourselves
+
+	apiAddress string // address of our HTTP API, announced in the handshake
+
+	book *AddressBook
+
+	mux        sync.Mutex
+	listener   net.Listener
+	inbound    int // accepted connections still open, handshakes included
+	peers      map[*Peer]bool
+	registered map[string]bool // HTTP APIs of peers we added to the nodes of the blockchain
+	handlers   map[string]HandlerFunc
+	wg         sync.WaitGroup
+	quit       chan struct{}
+	closeOnce  sync.Once
+}
+
+// NewNode creates a node for bc. address is where other nodes reach us, it is announced to
+// peers. The addresses of the nodes we meet are kept in book, or in memory when book is nil.
+func NewNode(bc *blockchain.Blockchain, address string, book *AddressBook) *Node {
+	if book == nil {
+		book = NewAddressBook("")
+	}
+	n := &Node{
+		bc:         bc,
+		address:    address,
+		nonce:      rand.Uint64(),
+		book:       book,
+		peers:      make(map[*Peer]bool),
+		registered: make(map[string]bool),
+		handlers:   make(map[string]HandlerFunc),
+		quit:       make(chan struct{}),
+	}
+	n.Handle(MsgGetAddr, n.handleGetAddr)
+	n.Handle(MsgAddr, n.handleAddr)
+	return n
+}
+
+// SetAPIAddress sets the address other nodes reach our HTTP API at. It is announced in the
+// handshake, so that peers register us for sync and gossip. Call it before Listen or Connect.
+func (n *Node) SetAPIAddress(address string) {
+	n.mux.Lock()
+	defer n.mux.Unlock()
+	n.apiAddress = address
+}
+
+// Handle registers fn for messages of the given type. Messages without a handler are ignored.
+func (n *Node) Handle(msgType string, fn HandlerFunc) {
+	n.mux.Lock()
+	defer n.mux.Unlock()
+	n.handlers[msgType] = fn
+}
+
+// Listen accepts peers on address in the background until Close is called. Connections
+// beyond MaxInboundPeers are closed right away.
+func (n *Node) Listen(address string) error {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return err
+	}
+	n.mux.Lock()
+	n.listener = listener
+	n.mux.Unlock()
+
+	n.wg.Add(1)
+	go func() {
+		defer n.wg.Done()
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return // listener closed
+			}
+			if !n.acceptInbound() {
+				fmt.Printf("Refusing %s: %d inbound peers already\n", conn.RemoteAddr(), MaxInboundPeers)
+				conn.Close()
+				continue
+			}
+			go func() {
+				defer n.releaseInbound()
+				peer, err := n.handshake(conn, true)
+				if err != nil {
+					fmt.Printf("Handshake with %s failed: %v\n", conn.RemoteAddr(), err)
+					return
+				}
+				n.book.Add(peer.version.Address, time.Now()) // where the peer says it accepts connections
+				n.addPeer(peer)
+				n.run(peer)
+			}()
+		}
+	}()
+	return nil
+}
+
+// acceptInbound counts a new inbound connection, unless there are MaxInboundPeers already
+func (n *Node) acceptInbound() bool {
+	n.mux.Lock()
+	defer n.mux.Unlock()
+	if n.inbound >= MaxInboundPeers {
+		return false
+	}
+	n.inbound++
+	return true
+}
+
+// releaseInbound forgets an inbound connection that was closed
+func (n *Node) releaseInbound() {
+	n.mux.Lock()
+	defer n.mux.Unlock()
+	n.inbound--
+}
+
+// ListenAddr returns the address the node listens on, nil before Listen
+func (n *Node) ListenAddr() net.Addr {
+	n.mux.Lock()
+	defer n.mux.Unlock()
+	if n.listener == nil {
+		return nil
+	}
+	return n.listener.Addr()
+}
+
+// Connect opens a connection to the node at address and runs the handshake. The peer is
+// served in the background and asked for the addresses it knows.
+func (n *Node) Connect(address string) (*Peer, error) {
+	conn, err := net.DialTimeout("tcp", address, HandshakeTimeout)
+	if err != nil {
+		return nil, err
+	}
+	peer, err := n.handshake(conn, false)
+	if err != nil {
+		return nil, err
+	}
+	peer.dialed = address
+	n.book.Good(address)
+
+	n.addPeer(peer)
+	go n.run(peer)
+	if err := peer.Send(MsgGetAddr, nil); err != nil {
+		peer.Close()
+		return nil, err
+	}
+	return peer, nil
+}
+
+// Peers returns the connected peers, ordered by address
+func (n *Node) Peers() []PeerInfo {
+	n.mux.Lock()
+	defer n.mux.Unlock()
+
+	peers := make([]PeerInfo, 0, len(n.peers))
+	for peer := range n.peers {
+		peers = append(peers, peer.Info())
+	}
+	sort.Slice(peers, func(i, j int) bool { return peers[i].Address < peers[j].Address })
+	return peers
+}
+
+// Close stops listening and discovery and disconnects every peer
+func (n *Node) Close() {
+	n.closeOnce.Do(func() { close(n.quit) })
+
+	n.mux.Lock()
+	if n.listener != nil {
+		n.listener.Close()
+	}
+	for peer := range n.peers {
+		peer.Close()
+	}
+	n.mux.Unlock()
+
+	n.wg.Wait()
+}
+
+// versionMessage describes our node and chain
+func (n *Node) versionMessage() VersionMessage {
+	n.mux.Lock()
+	apiAddress := n.apiAddress
+	n.mux.Unlock()
+	return VersionMessage{
+		Version:     ProtocolVersion,
+		ChainID:     n.bc.GetChainID(),
+		GenesisHash: n.bc.GetGenesisHash(),
+		BestHeight:  n.bc.GetLength(),
+		Address:     n.address,
+		APIAddress:  apiAddress,
+		Nonce:       n.nonce,
+	}
+}
+
+// checkVersion refuses peers we cannot talk to: an older protocol, another chain, ourselves
+// or a banned node, known by id
+func (n *Node) checkVersion(theirs VersionMessage, id string) error {
+	ours := n.versionMessage()
+	switch {
+	case theirs.Nonce == ours.Nonce:
+		return ErrSelfConnection
+	case n.bc.PeerScores().IsBanned(id):
+		return blockchain.ErrPeerBanned
+	case theirs.Version < MinProtocolVersion:
+		return fmt.Errorf("%w: %d, need at least %d", ErrIncompatibleVersion, theirs.Version, MinProtocolVersion)
+	case theirs.ChainID != ours.ChainID:
+		return fmt.Errorf("%w: chain ID %q, ours is %q", ErrChainMismatch, theirs.ChainID, ours.ChainID)
+	case theirs.GenesisHash != ours.GenesisHash:
+		return fmt.Errorf("%w: genesis %s, ours is %s", ErrChainMismatch, theirs.GenesisHash, ours.GenesisHash)
+	}
+	return nil
+}
+
+// handshake exchanges version messages on a new connection. Both sides send their version,
+// check the other one and acknowledge it with a verack, or send a reject and hang up.
+// The connection is closed when the handshake fails.
+func (n *Node) handshake(conn net.Conn, inbound bool) (*Peer, error) {
+	peer, err := n.exchangeVersions(conn, inbound)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return peer, nil
+}
+
+// exchangeVersions runs the handshake messages within HandshakeTimeout
+func (n *Node) exchangeVersions(conn net.Conn, inbound bool) (*Peer, error) {
+	conn.SetDeadline(time.Now().Add(HandshakeTimeout))
+	defer conn.SetDeadline(time.Time{})
+
+	msg, err := NewMessage(MsgVersion, n.versionMessage())
+	if err != nil {
+		return nil, err
+	}
+	if err := WriteMessage(conn, msg); err != nil {
+		return nil, err
+	}
+
+	var theirs VersionMessage
+	if err := expect(conn, MsgVersion, &theirs); err != nil {
+		return nil, err
+	}
+	if err := n.checkVersion(theirs, peerID(conn, theirs)); err != nil {
+		if reject, rerr := NewMessage(MsgReject, RejectMessage{Reason: err.Error()}); rerr == nil {
+			WriteMessage(conn, reject)
+		}
+		return nil, err
+	}
+
+	if err := WriteMessage(conn, Message{Type: MsgVerack}); err != nil {
+		return nil, err
+	}
+	if err := expect(conn, MsgVerack, nil); err != nil {
+		return nil, err
+	}
+	return newPeer(conn, theirs, inbound), nil
+}
+
+// expect reads the next message, which must be of type msgType, and decodes its payload
+// into v unless v is nil. A reject from the peer is returned as ErrRejected. Frames are
+// limited to MaxHandshakeMessageSize.
+func expect(conn net.Conn, msgType string, v any) error {
+	msg, err := readMessage(conn, MaxHandshakeMessageSize)
+	if err != nil {
+		return err
+	}
+	switch msg.Type {
+	case msgType:
+		if v == nil {
+			return nil
+		}
+		return msg.Decode(v)
+	case MsgReject:
+		var reject RejectMessage
+		msg.Decode(&reject)
+		return fmt.Errorf("%w: %s", ErrRejected, reject.Reason)
+	default:
+		return fmt.Errorf("%w: %s during handshake, want %s", ErrUnexpectedMessage, msg.Type, msgType)
+	}
+}
+
+// addPeer registers a peer whose handshake completed; run serves it. The HTTP API of the
+// peer, at the IP address it connected from and the port it announced, joins the nodes of
+// the blockchain, so that we sync and gossip with it.
+func (n *Node) addPeer(peer *Peer) {
+	n.mux.Lock()
+	defer n.mux.Unlock()
+	n.peers[peer] = true
+	n.wg.Add(1)
+
+	if api := peer.apiAddress(); api != "" && !n.bc.RegisteredNodes()[api] {
+		n.bc.RegisterNode(api)
+		n.registered[api] = true
+	}
+}
+
+// removePeer forgets a peer that disconnected. The HTTP API it announced leaves the nodes of
+// the blockchain again if we added it, unless another connected peer announced it too.
+func (n *Node) removePeer(peer *Peer) {
+	n.mux.Lock()
+	defer n.mux.Unlock()
+	delete(n.peers, peer)
+
+	api := peer.apiAddress()
+	if !n.registered[api] {
+		return
+	}
+	for other := range n.peers {
+		if other.apiAddress() == api {
+			return
+		}
+	}
+	delete(n.registered, api)
+	n.bc.UnregisterNode(api)
+}
+
+// run serves a peer until it disconnects: it answers pings, records pongs and passes every
+// other message to its handler. Malformed messages and timeouts count against the peer.
+func (n *Node) run(peer *Peer) {
+	defer func() {
+		peer.Close()
+		n.removePeer(peer)
+		n.wg.Done()
+	}()
+
+	go peer.keepalive()
+
+	for {
+		msg, err := peer.receive()
+		if err != nil {
+			select {
+			case <-peer.Done():
+			default:
+				fmt.Printf("Peer %s disconnected: %v\n", peer.Addr(), err)
+				n.bc.PeerScores().Punish(peer.id(), err)
+			}
+			return
+		}
+		if err := n.dispatch(peer, msg); err != nil {
+			fmt.Printf("Disconnecting peer %s: %v\n", peer.Addr(), err)
+			n.bc.PeerScores().Punish(peer.id(), err)
+			return
+		}
+	}
+}
+
+// dispatch handles one message received after the handshake
+func (n *Node) dispatch(peer *Peer, msg Message) error {
+	switch msg.Type {
+	case MsgPing:
+		var ping PingMessage
+		if err := msg.Decode(&ping); err != nil {
+			return err
+		}
+		return peer.Send(MsgPong, ping)
+	case MsgPong:
+		var pong PingMessage
+		if err := msg.Decode(&pong); err != nil {
+			return err
+		}
+		peer.pong(pong.Nonce)
+		return nil
+	case MsgVersion, MsgVerack:
+		return fmt.Errorf("%w: %s after the handshake", ErrUnexpectedMessage, msg.Type)
+	}
+
+	n.mux.Lock()
+	handler := n.handlers[msg.Type]
+	n.mux.Unlock()
+	if handler == nil {
+		return nil
+	}
+	return handler(peer, msg)
+}
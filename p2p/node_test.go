@@ -0,0 +1,260 @@
+package p2p
+
+import (
+	"blocklite/blockchain"
+	"errors"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+// listeningNode starts a node for bc on a free local port
+func listeningNode(t *testing.T, bc *blockchain.Blockchain) (*Node, string) {
+	t.Helper()
+	n := NewNode(bc, "", nil)
+	if err := n.Listen("127.0.0.1:0"); err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	t.Cleanup(n.Close)
+	return n, n.ListenAddr().String()
+}
+
+// copyChain returns a blockchain on the same chain as bc
+func copyChain(bc *blockchain.Blockchain) *blockchain.Blockchain {
+	return &blockchain.Blockchain{Chain: append([]blockchain.Block{}, bc.Chain...), Nodes: make(map[string]bool)}
+}
+
+// waitForPeers waits until n has count peers
+func waitForPeers(t *testing.T, n *Node, count int) []PeerInfo {
+	t.Helper()
+	for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+		if peers := n.Peers(); len(peers) == count {
+			return peers
+		}
+	}
+	t.Fatalf("Peers() = %+v; want %d peers", n.Peers(), count)
+	return nil
+}
+
+func TestHandshake(t *testing.T) {
+	os.Remove(blockchain.BlockchainFile)
+	defer os.Remove(blockchain.BlockchainFile)
+
+	bc := blockchain.NewBlockChain()
+	bc.CreateBlock("miner")
+	server, address := listeningNode(t, bc)
+
+	client := NewNode(copyChain(bc), "client:9090", nil)
+	defer client.Close()
+	peer, err := client.Connect(address)
+	if err != nil {
+		t.Fatalf("Connect() error = %v", err)
+	}
+	if v := peer.Version(); v.Version != ProtocolVersion || v.BestHeight != 2 || v.GenesisHash != bc.GetGenesisHash() {
+		t.Errorf("Version() = %+v; want version %d at height 2 on our genesis", v, ProtocolVersion)
+	}
+
+	peers := waitForPeers(t, server, 1)
+	if !peers[0].Inbound || peers[0].Listen != "client:9090" {
+		t.Errorf("Peers() = %+v; want the inbound client listening on client:9090", peers)
+	}
+	if peers := client.Peers(); len(peers) != 1 || peers[0].Inbound {
+		t.Errorf("client.Peers() = %+v; want one outbound peer", peers)
+	}
+
+	peer.Close()
+	waitForPeers(t, server, 0)
+}
+
+func TestHandshakeRefusesIncompatiblePeers(t *testing.T) {
+	os.Remove(blockchain.BlockchainFile)
+	defer os.Remove(blockchain.BlockchainFile)
+
+	bc := blockchain.NewBlockChain()
+	server, address := listeningNode(t, bc)
+
+	otherGenesis := copyChain(bc)
+	otherGenesis.Chain[0].Timestamp = "2000-01-01T00:00:00Z"
+	otherNetwork := copyChain(bc)
+	otherNetwork.ChainID = "testnet"
+
+	tests := []struct {
+		name   string
+		client *Node
+		want   error
+	}{
+		{"Other genesis", NewNode(otherGenesis, "", nil), ErrChainMismatch},
+		{"Other chain ID", NewNode(otherNetwork, "", nil), ErrChainMismatch},
+		{"Ourselves", server, ErrSelfConnection},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := tt.client.Connect(address); !errors.Is(err, tt.want) {
+				t.Errorf("Connect() error = %v; want %v", err, tt.want)
+			}
+		})
+	}
+
+	t.Run("Old protocol version", func(t *testing.T) {
+		conn, err := net.Dial("tcp", address)
+		if err != nil {
+			t.Fatalf("Dial() error = %v", err)
+		}
+		defer conn.Close()
+
+		version := server.versionMessage()
+		version.Version, version.Nonce = MinProtocolVersion-1, 1
+		msg, _ := NewMessage(MsgVersion, version)
+		WriteMessage(conn, msg)
+
+		if err := expect(conn, MsgVersion, nil); err != nil {
+			t.Fatalf("Server version error = %v", err)
+		}
+		if err := expect(conn, MsgVerack, nil); !errors.Is(err, ErrRejected) {
+			t.Errorf("Handshake error = %v; want %v", err, ErrRejected)
+		}
+	})
+
+	if peers := server.Peers(); len(peers) != 0 {
+		t.Errorf("Peers() = %+v; want none", peers)
+	}
+}
+
+func TestKeepalive(t *testing.T) {
+	os.Remove(blockchain.BlockchainFile)
+	defer os.Remove(blockchain.BlockchainFile)
+
+	interval, timeout := PingInterval, PeerTimeout
+	t.Cleanup(func() { PingInterval, PeerTimeout = interval, timeout }) // after the nodes are closed
+	PingInterval, PeerTimeout = 10*time.Millisecond, 200*time.Millisecond
+
+	bc := blockchain.NewBlockChain()
+	server, address := listeningNode(t, bc)
+
+	// A peer answering pings stays connected and gets a latency
+	client := NewNode(copyChain(bc), "", nil)
+	t.Cleanup(client.Close)
+	peer, err := client.Connect(address)
+	if err != nil {
+		t.Fatalf("Connect() error = %v", err)
+	}
+	time.Sleep(2 * PeerTimeout)
+	if info := peer.Info(); info.Latency == 0 {
+		t.Errorf("Info() = %+v; want a ping latency", info)
+	}
+	waitForPeers(t, server, 1)
+
+	// A peer that goes silent after the handshake is dropped
+	conn, err := net.Dial("tcp", address)
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	defer conn.Close()
+	version := client.versionMessage()
+	version.Nonce = 1
+	msg, _ := NewMessage(MsgVersion, version)
+	WriteMessage(conn, msg)
+	WriteMessage(conn, Message{Type: MsgVerack})
+
+	waitForPeers(t, server, 2)
+	waitForPeers(t, server, 1)
+}
+
+func TestListenLimitsInboundPeers(t *testing.T) {
+	os.Remove(blockchain.BlockchainFile)
+	defer os.Remove(blockchain.BlockchainFile)
+	defer func(limit int) { MaxInboundPeers = limit }(MaxInboundPeers)
+	MaxInboundPeers = 1
+
+	_, address := listeningNode(t, blockchain.NewBlockChain())
+
+	// The first connection stays open in the handshake, the second is closed at once
+	first, err := net.Dial("tcp", address)
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	defer first.Close()
+	second, err := net.Dial("tcp", address)
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	defer second.Close()
+
+	second.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := ReadMessage(second); err == nil || errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("ReadMessage() error = %v; want the connection closed", err)
+	}
+	first.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if msg, err := ReadMessage(first); err != nil || msg.Type != MsgVersion {
+		t.Errorf("ReadMessage() = %+v, %v; want the version message", msg, err)
+	}
+}
+
+func TestPeersJoinTheNodes(t *testing.T) {
+	os.Remove(blockchain.BlockchainFile)
+	defer os.Remove(blockchain.BlockchainFile)
+
+	bc := blockchain.NewBlockChain()
+	server, address := listeningNode(t, bc)
+
+	client := NewNode(copyChain(bc), "client:9090", nil)
+	client.SetAPIAddress("localhost:8080")
+	defer client.Close()
+	peer, err := client.Connect(address)
+	if err != nil {
+		t.Fatalf("Connect() error = %v", err)
+	}
+	waitForPeers(t, server, 1)
+	// The API is registered at the address the peer connected from
+	if nodes := bc.RegisteredNodes(); !nodes["127.0.0.1:8080"] || nodes["localhost:8080"] {
+		t.Errorf("RegisteredNodes() = %v; want the API of the peer at 127.0.0.1:8080", nodes)
+	}
+
+	peer.Close()
+	waitForPeers(t, server, 0)
+	if bc.RegisteredNodes()["127.0.0.1:8080"] {
+		t.Errorf("RegisteredNodes() = %v; want the API of the peer gone with it", bc.RegisteredNodes())
+	}
+
+	// A node registered by hand stays when its peer leaves
+	bc.RegisterNode("127.0.0.1:8080")
+	if peer, err = client.Connect(address); err != nil {
+		t.Fatalf("Connect() error = %v", err)
+	}
+	waitForPeers(t, server, 1)
+	peer.Close()
+	waitForPeers(t, server, 0)
+	if !bc.RegisteredNodes()["127.0.0.1:8080"] {
+		t.Errorf("RegisteredNodes() = %v; want the node registered by hand kept", bc.RegisteredNodes())
+	}
+}
+
+// TestAPIAddress builds the API of a peer from the address it connected from and the port it
+// announced.
+func TestAPIAddress(t *testing.T) {
+	tests := []struct {
+		name      string
+		remoteIP  string
+		announced string
+		want      string
+	}{
+		{"Remote peer", "203.0.113.7", "203.0.113.7:8080", "203.0.113.7:8080"},
+		{"Announced host ignored", "203.0.113.7", "198.51.100.1:8080", "203.0.113.7:8080"},
+		{"Remote peer announcing loopback", "203.0.113.7", "localhost:8080", "203.0.113.7:8080"},
+		{"Local peer", "127.0.0.1", "localhost:8080", "127.0.0.1:8080"},
+		{"Local peer on every interface", "127.0.0.1", "0.0.0.0:8080", "127.0.0.1:8080"},
+		{"Loopback connection for a host elsewhere", "127.0.0.1", "203.0.113.7:8080", ""},
+		{"Unspecified IP", "0.0.0.0", "localhost:8080", ""},
+		{"No port", "203.0.113.7", "203.0.113.7", ""},
+		{"No API", "203.0.113.7", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := apiAddress(tt.remoteIP, tt.announced); got != tt.want {
+				t.Errorf("apiAddress(%q, %q) = %q; want %q", tt.remoteIP, tt.announced, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,226 @@
+package p2p
+
+import (
+	"blocklite/blockchain"
+	"math/rand/v2"
+	"net"
+	"sync"
+	"time"
+)
+
+// Connection timing
+var (
+	HandshakeTimeout = 10 * time.Second // the whole handshake must finish within this time
+	PingInterval     = 30 * time.Second // time between keepalive pings
+	PeerTimeout      = 90 * time.Second // a peer silent for this long is disconnected
+	WriteTimeout     = 10 * time.Second // bound on sending a single message
+)
+
+// MaxInboundPeers bounds the inbound connections, handshakes included; more are closed at once
+var MaxInboundPeers = 64
+
+// Peer is a connection to another node that completed the handshake
+type Peer struct {
+	conn    net.Conn
+	version VersionMessage
+	inbound bool
+	dialed  string // address we connected to, empty for inbound peers
+
+	pingInterval time.Duration // PingInterval and PeerTimeout when the peer connected
+	timeout      time.Duration
+
+	writeMux sync.Mutex // one frame at a time
+
+	mux       sync.Mutex
+	connected time.Time
+	lastSeen  time.Time
+	pingNonce uint64 // nonce of the unanswered ping, 0 for none
+	pingSent  time.Time
+	latency   time.Duration
+
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+// PeerInfo describes a connected peer
+type PeerInfo struct {
+	Address     string        `json:"address"`     // remote address of the connection
+	Listen      string        `json:"listen"`      // address the peer accepts connections on
+	Inbound     bool          `json:"inbound"`     // whether the peer connected to us
+	Version     int           `json:"version"`     // protocol version of the peer
+	BestHeight  int           `json:"best_height"` // height the peer announced in its handshake
+	ConnectedAt time.Time     `json:"connected_at"`
+	LastSeen    time.Time     `json:"last_seen"`
+	Latency     time.Duration `json:"latency_ns"` // round trip of the last answered ping
+}
+
+// newPeer wraps a connection whose handshake completed
+func newPeer(conn net.Conn, version VersionMessage, inbound bool) *Peer {
+	now := time.Now()
+	return &Peer{
+		conn:         conn,
+		version:      version,
+		inbound:      inbound,
+		pingInterval: PingInterval,
+		timeout:      PeerTimeout,
+		connected:    now,
+		lastSeen:     now,
+		closed:       make(chan struct{}),
+	}
+}
+
+// Addr returns the remote address of the connection
+func (p *Peer) Addr() string {
+	return p.conn.RemoteAddr().String()
+}
+
+// id identifies the peer in the scores and bans, see blockchain.PeerID
+func (p *Peer) id() string {
+	return peerID(p.conn, p.version)
+}
+
+// peerID identifies the node behind a connection by the IP address of the connection and the
+// port of the HTTP API it announced, or else of its peer port, like gossip and sync do
+func peerID(conn net.Conn, version VersionMessage) string {
+	host, _, _ := net.SplitHostPort(conn.RemoteAddr().String())
+	if version.APIAddress != "" {
+		return blockchain.PeerID(host, version.APIAddress)
+	}
+	return blockchain.PeerID(host, version.Address)
+}
+
+// apiAddress returns the HTTP API of the peer to register with the blockchain, or "" when
+// there is none to register
+func (p *Peer) apiAddress() string {
+	host, _, _ := net.SplitHostPort(p.conn.RemoteAddr().String())
+	return apiAddress(host, p.version.APIAddress)
+}
+
+// apiAddress builds the HTTP API of a node from the IP address its connection comes from and
+// the port of the API it announced; the announced host is not trusted. It returns "" when the
+// node announced no port, when the IP address is unusable, and for a loopback connection from
+// a node that announced a host elsewhere, as one relayed by a local proxy would be: its API
+// is not on this machine.
+func apiAddress(remoteIP, announced string) string {
+	host, port, err := net.SplitHostPort(announced)
+	if err != nil || port == "" {
+		return ""
+	}
+	ip := net.ParseIP(remoteIP)
+	if ip == nil || ip.IsUnspecified() {
+		return ""
+	}
+	if ip.IsLoopback() && !isLocalHost(host) {
+		return ""
+	}
+	return net.JoinHostPort(remoteIP, port)
+}
+
+// isLocalHost reports whether an announced host designates the machine it runs on
+func isLocalHost(host string) bool {
+	if host == "" || host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && (ip.IsLoopback() || ip.IsUnspecified())
+}
+
+// Version returns the version message the peer sent in its handshake
+func (p *Peer) Version() VersionMessage {
+	return p.version
+}
+
+// Info returns a snapshot of the peer
+func (p *Peer) Info() PeerInfo {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	return PeerInfo{
+		Address:     p.Addr(),
+		Listen:      p.version.Address,
+		Inbound:     p.inbound,
+		Version:     p.version.Version,
+		BestHeight:  p.version.BestHeight,
+		ConnectedAt: p.connected,
+		LastSeen:    p.lastSeen,
+		Latency:     p.latency,
+	}
+}
+
+// Send writes a message to the peer
+func (p *Peer) Send(msgType string, payload any) error {
+	msg, err := NewMessage(msgType, payload)
+	if err != nil {
+		return err
+	}
+	p.writeMux.Lock()
+	defer p.writeMux.Unlock()
+
+	p.conn.SetWriteDeadline(time.Now().Add(WriteTimeout))
+	return WriteMessage(p.conn, msg)
+}
+
+// Close disconnects the peer
+func (p *Peer) Close() {
+	p.closeOnce.Do(func() {
+		close(p.closed)
+		p.conn.Close()
+	})
+}
+
+// Done is closed once the peer is disconnected
+func (p *Peer) Done() <-chan struct{} {
+	return p.closed
+}
+
+// receive reads the next message. It fails when the peer stays silent for PeerTimeout.
+func (p *Peer) receive() (Message, error) {
+	p.conn.SetReadDeadline(time.Now().Add(p.timeout))
+	msg, err := ReadMessage(p.conn)
+	if err != nil {
+		return Message{}, err
+	}
+
+	p.mux.Lock()
+	p.lastSeen = time.Now()
+	p.mux.Unlock()
+	return msg, nil
+}
+
+// ping sends a keepalive ping
+func (p *Peer) ping() error {
+	nonce := rand.Uint64() | 1 // never 0, which means no ping is outstanding
+
+	p.mux.Lock()
+	p.pingNonce, p.pingSent = nonce, time.Now()
+	p.mux.Unlock()
+
+	return p.Send(MsgPing, PingMessage{Nonce: nonce})
+}
+
+// pong records the answer to our last ping
+func (p *Peer) pong(nonce uint64) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	if nonce == p.pingNonce {
+		p.latency = time.Since(p.pingSent)
+		p.pingNonce = 0
+	}
+}
+
+// keepalive pings the peer every PingInterval until it disconnects
+func (p *Peer) keepalive() {
+	ticker := time.NewTicker(p.pingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.closed:
+			return
+		case <-ticker.C:
+			if err := p.ping(); err != nil {
+				p.Close()
+				return
+			}
+		}
+	}
+}
@@ -9,6 +9,9 @@ import (
 	"math/big"
 )
 
+// halfOrder is half the order of the curve, the highest s a signature may use
+var halfOrder = new(big.Int).Rsh(elliptic.P256().Params().N, 1)
+
 type Wallet struct {
 	PrivateKey *ecdsa.PrivateKey
 	PublicKey  []byte
@@ -19,7 +22,10 @@ func NewWallet() *Wallet {
 	if err != nil {
 		panic(err)
 	}
-	public := append(private.PublicKey.X.Bytes(), private.PublicKey.Y.Bytes()...)
+	// Both coordinates are padded to 32 bytes so that Verify can split the key in halves
+	public := make([]byte, 64)
+	private.PublicKey.X.FillBytes(public[:32])
+	private.PublicKey.Y.FillBytes(public[32:])
 
 	return &Wallet{private, public}
 }
@@ -34,8 +40,15 @@ func Sign(privateKey *ecdsa.PrivateKey, data string) (string, error) {
 	if err != nil {
 		return "", err
 	}
+	// (r, N-s) is just as valid; only the low s is accepted by Verify
+	if s.Cmp(halfOrder) > 0 {
+		s.Sub(privateKey.Curve.Params().N, s)
+	}
 
-	signature := append(r.Bytes(), s.Bytes()...)
+	// r and s are padded to 32 bytes so that Verify can split the signature in halves
+	signature := make([]byte, 64)
+	r.FillBytes(signature[:32])
+	s.FillBytes(signature[32:])
 	return hex.EncodeToString(signature), nil
 }
 
@@ -45,8 +58,10 @@ func Verify(publicKeyHex string, data string, signatureHex string) bool {
 		return false
 	}
 
+	// A signature has a single accepted encoding, 64 bytes in lowercase hex with a low s,
+	// so that it cannot be re-encoded to change the ID of the transaction carrying it
 	sigBytes, err := hex.DecodeString(signatureHex)
-	if err != nil || len(sigBytes) < 64 {
+	if err != nil || len(sigBytes) != 64 || hex.EncodeToString(sigBytes) != signatureHex {
 		return false
 	}
 
@@ -55,6 +70,9 @@ func Verify(publicKeyHex string, data string, signatureHex string) bool {
 
 	r := new(big.Int).SetBytes(rBytes)
 	s := new(big.Int).SetBytes(sBytes)
+	if s.Cmp(halfOrder) > 0 {
+		return false
+	}
 
 	xBytes := publicKeyBytes[:len(publicKeyBytes)/2]
 	yBytes := publicKeyBytes[len(publicKeyBytes)/2:]
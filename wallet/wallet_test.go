@@ -1,6 +1,9 @@
 package wallet
 
 import (
+	"encoding/hex"
+	"math/big"
+	"strings"
 	"testing"
 )
 
@@ -31,3 +34,37 @@ func TestWallet(t *testing.T) {
 		t.Error("Verification should fail for wrong public key")
 	}
 }
+
+// TestVerifyCanonical checks that re-encodings of a valid signature are refused, so they
+// cannot change the ID of a transaction
+func TestVerifyCanonical(t *testing.T) {
+	w := NewWallet()
+	data := "some transaction data"
+	signature, err := Sign(w.PrivateKey, data)
+	if err != nil {
+		t.Fatalf("Failed to sign: %v", err)
+	}
+
+	sigBytes, _ := hex.DecodeString(signature)
+	s := new(big.Int).SetBytes(sigBytes[32:])
+	highS := make([]byte, 64)
+	copy(highS, sigBytes[:32])
+	new(big.Int).Sub(w.PrivateKey.Curve.Params().N, s).FillBytes(highS[32:])
+
+	tests := []struct {
+		name      string
+		signature string
+	}{
+		{name: "Uppercase hex", signature: strings.ToUpper(signature)},
+		{name: "Padded halves", signature: "00" + signature[:64] + "00" + signature[64:]},
+		{name: "High s", signature: hex.EncodeToString(highS)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if Verify(w.GetAddress(), data, tt.signature) {
+				t.Error("Verify accepted a re-encoded signature")
+			}
+		})
+	}
+}